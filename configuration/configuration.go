@@ -66,6 +66,52 @@ type Configuration struct {
 
 	// Policy configures registry policy options.
 	Policy Policy `yaml:"policy,omitempty"`
+
+	// RateLimit configures the rate limits of the requests.
+	RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+}
+
+// RateLimit configures token bucket rate limits for the requests pulling
+// manifests, pulling blobs and uploading content.
+type RateLimit struct {
+	// Redis shares the state of the rate limits between the registry
+	// replicas through the configured redis client. By default, the state is
+	// kept in memory.
+	Redis bool `yaml:"redis,omitempty"`
+
+	// TrustedProxies are the addresses or CIDR networks of the proxies whose
+	// X-Forwarded-For and X-Real-Ip headers identify the clients. The headers
+	// of the other clients are ignored.
+	TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
+	// Manifests limits the manifest GET and HEAD requests.
+	Manifests RateLimits `yaml:"manifests,omitempty"`
+
+	// Blobs limits the blob GET and HEAD requests.
+	Blobs RateLimits `yaml:"blobs,omitempty"`
+
+	// Uploads limits the blob upload and manifest PUT requests.
+	Uploads RateLimits `yaml:"uploads,omitempty"`
+}
+
+// RateLimits are the rate limits of a kind of requests, each keyed by a
+// property of the request.
+type RateLimits struct {
+	// RemoteAddr limits the requests of each client address.
+	RemoteAddr RateLimitBucket `yaml:"remoteaddr,omitempty"`
+
+	// User limits the requests of each authenticated user.
+	User RateLimitBucket `yaml:"user,omitempty"`
+
+	// Repository limits the requests to each repository.
+	Repository RateLimitBucket `yaml:"repository,omitempty"`
+}
+
+// RateLimitBucket is a token bucket, allowing Rate requests per second on
+// average and bursts of up to Burst requests. A zero rate disables it.
+type RateLimitBucket struct {
+	Rate  float64 `yaml:"rate,omitempty"`
+	Burst int     `yaml:"burst,omitempty"`
 }
 
 // Policy defines configuration options for managing registry policies.
@@ -81,6 +127,22 @@ type Repository struct {
 	// If this field is non-empty, the registry enforces that all uploaded
 	// content belongs to one of the specified classes.
 	Classes []string `yaml:"classes"`
+
+	// ImmutableTags protects the tags matching its rules from being pointed
+	// at a different manifest or deleted once pushed.
+	ImmutableTags []ImmutableTagRule `yaml:"immutabletags,omitempty"`
+}
+
+// ImmutableTagRule selects immutable tags by repository and tag.
+type ImmutableTagRule struct {
+	// Repositories are glob patterns of the repositories of the rule, matching
+	// the repositories within the matching namespaces too. An empty list
+	// matches all the repositories.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Tags are regular expressions of the tags of the rule. An empty list
+	// matches all the tags.
+	Tags []string `yaml:"tags,omitempty"`
 }
 
 // Catalog provides configuration options for the /v2/_catalog endpoint.
@@ -480,6 +542,8 @@ func (storage Storage) Type() string {
 			// allow configuration of redirect
 		case "tag":
 			// allow configuration of tag
+		case "quota":
+			// allow configuration of quota
 		default:
 			storageType = append(storageType, k)
 		}
@@ -536,6 +600,8 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of redirect
 				case "tag":
 					// allow configuration of tag
+				case "quota":
+					// allow configuration of quota
 				default:
 					types = append(types, k)
 				}
@@ -636,6 +702,9 @@ type Notifications struct {
 	// respond to webhook notifications. In the future, we may allow other
 	// kinds of endpoints, such as external queues.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
+	// Replication configures the replication of the repositories pushed to
+	// the registry to other registries.
+	Replication Replication `yaml:"replication,omitempty"`
 }
 
 // Endpoint describes the configuration of an http webhook notification
@@ -650,6 +719,44 @@ type Endpoint struct {
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+	Queue             EndpointQueue `yaml:"queue"`             // persistent queue of the events pending
+	Format            string        `yaml:"format"`            // format of the requests, envelope or cloudevents
+	Secret            string        `yaml:"secret"`            // secret signing the requests
+	Filter            Filter        `yaml:"filter"`            // filter events by repository, tag and actor
+}
+
+// EndpointQueue configures a write-ahead log of the events pending for an
+// endpoint, replayed in order when the registry restarts.
+type EndpointQueue struct {
+	Directory   string `yaml:"directory,omitempty"`   // local directory of the queue
+	Storage     bool   `yaml:"storage,omitempty"`     // keeps the queue with the registry storage driver
+	Instance    string `yaml:"instance,omitempty"`    // identifies the registry instance owning the queue in the storage
+	MaxAttempts int    `yaml:"maxattempts,omitempty"` // attempts before moving an event to the dead letters
+}
+
+// Replication configures the replication of the manifests, tags and deletions
+// of the registry to other registries.
+type Replication struct {
+	// QueueDirectory is the local directory holding the replications
+	// pending for each target. If not set, the pending replications are
+	// lost when the registry stops.
+	QueueDirectory string `yaml:"queuedirectory,omitempty"`
+
+	// Targets are the registries the repositories are replicated to.
+	Targets []ReplicationTarget `yaml:"targets,omitempty"`
+}
+
+// ReplicationTarget describes a registry the repositories are replicated to.
+type ReplicationTarget struct {
+	Name         string        `yaml:"name"`                   // identifies the target in the registry instance.
+	Disabled     bool          `yaml:"disabled,omitempty"`     // disables the target
+	URL          string        `yaml:"url"`                    // url of the target registry
+	Username     string        `yaml:"username,omitempty"`     // username authenticating with the target registry
+	Password     string        `yaml:"password,omitempty"`     // password authenticating with the target registry
+	Repositories []string      `yaml:"repositories,omitempty"` // glob patterns of the repositories replicated, all if empty
+	Backoff      time.Duration `yaml:"backoff,omitempty"`      // delay before retrying a failed replication, doubled after each attempt
+	MaxBackoff   time.Duration `yaml:"maxbackoff,omitempty"`   // maximum delay before retrying a failed replication
+	MaxAttempts  int           `yaml:"maxattempts,omitempty"`  // attempts before a replication is dropped, unlimited if zero
 }
 
 // Events configures notification events.
@@ -663,6 +770,21 @@ type Ignore struct {
 	Actions    []string `yaml:"actions"`    // ignore action types
 }
 
+// Filter selects the events sent to an endpoint by their repository, tag and
+// actor.
+type Filter struct {
+	Repositories     FilterPatterns `yaml:"repositories,omitempty"`     // repository glob patterns
+	Tags             FilterPatterns `yaml:"tags,omitempty"`             // tag regular expressions
+	IgnorePullActors []string       `yaml:"ignorepullactors,omitempty"` // actors whose pull events are ignored
+}
+
+// FilterPatterns lists the patterns an event must match, if any, and those it
+// must not match.
+type FilterPatterns struct {
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
 // Middleware configures named middlewares to be applied at injection points.
 type Middleware struct {
 	// Name the middleware registers itself as
@@ -692,6 +814,74 @@ type Proxy struct {
 	// if not set, defaults to 7 * 24 hours
 	// If set to zero, will never expire cache
 	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// MaxStale bounds how long after it was last resolved from the remote
+	// registry a tag is resolved from the cache while the remote registry
+	// is unreachable. If not set or zero, tags are resolved from the cache
+	// regardless of their age.
+	MaxStale *time.Duration `yaml:"maxstale,omitempty"`
+
+	// Remotes configures several remote registries, each serving the
+	// repositories of a namespace. If set, RemoteURL, Username, Password,
+	// Exec and TTL are ignored.
+	Remotes []ProxyRemote `yaml:"remotes,omitempty"`
+
+	// Prefetch lists the references pulled into the cache when the registry
+	// starts, such as "library/golang:1.23". A "*" in the repository name
+	// matches the repositories already cached, such as "myorg/*:latest".
+	Prefetch []string `yaml:"prefetch,omitempty"`
+
+	// PrefetchInterval is the interval at which the Prefetch references are
+	// pulled again to keep them fresh. If zero, they are only pulled when
+	// the registry starts.
+	PrefetchInterval time.Duration `yaml:"prefetchinterval,omitempty"`
+
+	// SpoolDirectory is the local directory where the blobs being pulled
+	// from the remote registries are spooled, to serve the concurrent
+	// requests for them. If not set, the default temporary directory is used.
+	SpoolDirectory string `yaml:"spooldirectory,omitempty"`
+}
+
+// ProxyRemote configures a remote registry serving a namespace of a pull
+// through cache
+type ProxyRemote struct {
+	// Namespace selects the repositories served by the remote registry. It
+	// is either "*", matching all repositories, or a prefix followed by
+	// "/*", such as "docker.io/*". The remote with the longest matching
+	// namespace is used.
+	Namespace string `yaml:"namespace"`
+
+	// Rewrite replaces the namespace prefix in the names of the repositories
+	// pulled from the remote registry. If not set, the prefix is stripped.
+	Rewrite string `yaml:"rewrite,omitempty"`
+
+	// RemoteURL is the URL of the remote registry
+	RemoteURL string `yaml:"remoteurl"`
+
+	// Username of the remote registry user
+	Username string `yaml:"username"`
+
+	// Password of the remote registry user
+	Password string `yaml:"password"`
+
+	// Exec specifies a custom exec-based command to retrieve credentials.
+	// If set, Username and Password are ignored.
+	Exec *ExecConfig `yaml:"exec,omitempty"`
+
+	// TTL is the expiry time of the content pulled from the remote registry
+	// if not set, defaults to 7 * 24 hours
+	// If set to zero, will never expire cache
+	TTL *time.Duration `yaml:"ttl,omitempty"`
+
+	// MaxStale bounds how long after it was last resolved from the remote
+	// registry a tag is resolved from the cache while the remote registry
+	// is unreachable. If not set or zero, there is no bound.
+	MaxStale *time.Duration `yaml:"maxstale,omitempty"`
+}
+
+// Enabled returns true if the registry is configured as a pull through cache.
+func (proxy Proxy) Enabled() bool {
+	return proxy.RemoteURL != "" || len(proxy.Remotes) > 0
 }
 
 // ExecConfig defines the configuration for executing a command as a credential helper.
@@ -43,6 +43,18 @@ func (err ErrRepositoryUnknown) Error() string {
 	return fmt.Sprintf("unknown repository name=%s", err.Name)
 }
 
+// ErrQuotaExceeded is returned when storing content in the named repository
+// would exceed the storage quota configured for Prefix.
+type ErrQuotaExceeded struct {
+	Name   string
+	Prefix string
+	Limit  int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("repository %s exceeds storage quota of %d bytes for prefix %q", err.Name, err.Limit, err.Prefix)
+}
+
 // ErrRepositoryNameInvalid should be used to denote an invalid repository
 // name. Reason may set, indicating the cause of invalidity.
 type ErrRepositoryNameInvalid struct {
@@ -59,9 +59,17 @@ type ImageIndex struct {
 	// MediaType is the media type of this schema.
 	MediaType string `json:"mediaType,omitempty"`
 
+	// ArtifactType is the media type of the artifact when the index is
+	// used for content other than a multi-platform image.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Manifests references a list of manifests
 	Manifests []v1.Descriptor `json:"manifests"`
 
+	// Subject is an optional reference to another manifest which this
+	// index refers to.
+	Subject *v1.Descriptor `json:"subject,omitempty"`
+
 	// Annotations is an optional field that contains arbitrary metadata for the
 	// image index
 	Annotations map[string]string `json:"annotations,omitempty"`
@@ -55,6 +55,10 @@ type Manifest struct {
 	// MediaType is the media type of this schema.
 	MediaType string `json:"mediaType,omitempty"`
 
+	// ArtifactType is the media type of the artifact when the manifest is
+	// used for content other than a container image.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config v1.Descriptor `json:"config"`
 
@@ -62,6 +66,10 @@ type Manifest struct {
 	// configuration.
 	Layers []v1.Descriptor `json:"layers"`
 
+	// Subject is an optional reference to another manifest which this
+	// manifest refers to, such as the image a signature applies to.
+	Subject *v1.Descriptor `json:"subject,omitempty"`
+
 	// Annotations contains arbitrary metadata for the image manifest.
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
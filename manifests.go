@@ -49,6 +49,14 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ReferrerEnumerator enables iterating over the manifests which declare a
+// given manifest as their subject.
+type ReferrerEnumerator interface {
+	// Referrers calls ingester with a descriptor for each manifest referring
+	// to subject.
+	Referrers(ctx context.Context, subject digest.Digest, ingester func(v1.Descriptor) error) error
+}
+
 // Describable is an interface for descriptors.
 //
 // Implementations of Describable are generally objects which can be
@@ -16,4 +16,7 @@ var (
 
 	// ProxyNamespace is the prometheus namespace of proxy related metrics
 	ProxyNamespace = metrics.NewNamespace(NamespacePrefix, "proxy", nil)
+
+	// ReplicationNamespace is the prometheus namespace of replication related metrics
+	ReplicationNamespace = metrics.NewNamespace(NamespacePrefix, "replication", nil)
 )
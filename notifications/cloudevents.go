@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"net/url"
+	"time"
+)
+
+const (
+	// CloudEventsMediaType is the mediatype of a CloudEvents 1.0 event in
+	// structured mode.
+	CloudEventsMediaType = "application/cloudevents+json"
+	// CloudEventsBatchMediaType is the mediatype of a batch of CloudEvents
+	// 1.0 events.
+	CloudEventsBatchMediaType = "application/cloudevents-batch+json"
+
+	// CloudEventsSpecVersion is the version of the CloudEvents specification
+	// the events conform to.
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsTypePrefix prefixes the action of an event in the type of
+	// its CloudEvents event, such as "io.distribution.registry.push".
+	CloudEventsTypePrefix = "io.distribution.registry."
+)
+
+// Formats of the requests sent to the endpoints.
+const (
+	// FormatEnvelope sends the events in an Envelope, the default.
+	FormatEnvelope = "envelope"
+	// FormatCloudEvents sends each event as a CloudEvents event in
+	// structured mode.
+	FormatCloudEvents = "cloudevents"
+	// FormatCloudEventsBatch sends the events as a batch of CloudEvents
+	// events.
+	FormatCloudEventsBatch = "cloudevents-batch"
+)
+
+// CloudEvent defines the fields of a CloudEvents 1.0 event in JSON format,
+// holding a registry event as its data.
+type CloudEvent struct {
+	// SpecVersion is the version of the CloudEvents specification.
+	SpecVersion string `json:"specversion"`
+
+	// ID is the ID of the registry event.
+	ID string `json:"id"`
+
+	// Source identifies the repository of the registry event, as seen by
+	// the client of the request generating it.
+	Source string `json:"source"`
+
+	// Type is the action of the registry event, prefixed by
+	// CloudEventsTypePrefix.
+	Type string `json:"type"`
+
+	// Subject is the repository of the registry event.
+	Subject string `json:"subject,omitempty"`
+
+	// Time is the time at which the registry event occurred.
+	Time time.Time `json:"time,omitempty"`
+
+	// DataContentType is the mediatype of Data.
+	DataContentType string `json:"datacontenttype"`
+
+	// Data is the registry event.
+	Data Event `json:"data"`
+}
+
+// NewCloudEvent returns the CloudEvents event holding the registry event.
+func NewCloudEvent(event Event) CloudEvent {
+	host := event.Request.Host
+	if host == "" {
+		host = event.Source.Addr
+	}
+	source := url.URL{Host: host, Path: "/v2/" + event.Target.Repository}
+
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          source.String(),
+		Type:            CloudEventsTypePrefix + event.Action,
+		Subject:         event.Target.Repository,
+		Time:            event.Timestamp,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
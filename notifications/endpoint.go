@@ -5,11 +5,16 @@ import (
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	events "github.com/docker/go-events"
 )
 
 // EndpointConfig covers the optional configuration parameters for an active
-// endpoint.
+// endpoint. If QueueDriver is set, the events pending are kept in a
+// write-ahead log under QueuePath in the driver, and moved to the dead
+// letters after MaxAttempts failures, if set. The requests are sent in
+// Format, and signed with Secret if set. Only the events selected by Filter,
+// if set, are sent.
 type EndpointConfig struct {
 	Headers           http.Header
 	Timeout           time.Duration
@@ -18,6 +23,12 @@ type EndpointConfig struct {
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	QueueDriver       storagedriver.StorageDriver `json:"-"`
+	QueuePath         string
+	MaxAttempts       int
+	Format            string
+	Secret            string       `json:"-"`
+	Filter            *EventFilter `json:"-"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -37,6 +48,10 @@ func (ec *EndpointConfig) defaults() {
 	if ec.Transport == nil {
 		ec.Transport = http.DefaultTransport.(*http.Transport)
 	}
+
+	if ec.QueuePath == "" {
+		ec.QueuePath = "/"
+	}
 }
 
 // Endpoint is a reliable, queued, thread-safe sink that notify external http
@@ -61,14 +76,23 @@ func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
 	endpoint.defaults()
 	endpoint.metrics = newSafeMetrics(name)
 
-	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
+	// Configures the inmemory or persistent queue, retry, http pipeline.
+	sink := newHTTPSink(
 		endpoint.url, endpoint.Timeout, endpoint.Headers,
 		endpoint.Transport, endpoint.metrics.httpStatusListener())
-	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	sink.format = endpoint.Format
+	sink.secret = []byte(endpoint.Secret)
+	endpoint.Sink = sink
+	if endpoint.QueueDriver != nil {
+		endpoint.Sink = newPersistentEventQueue(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff),
+			endpoint.QueueDriver, endpoint.QueuePath, endpoint.MaxAttempts, endpoint.metrics.eventQueueListener())
+	} else {
+		endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
+		endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	}
 	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
 	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+	endpoint.Sink = newFilteringSink(endpoint.Sink, config.Filter)
 
 	register(&endpoint)
 	return &endpoint
@@ -0,0 +1,145 @@
+package notifications
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+// EventFilter selects events by their repository, tag and actor.
+type EventFilter struct {
+	includeRepositories []string
+	excludeRepositories []string
+	includeTags         []*regexp.Regexp
+	excludeTags         []*regexp.Regexp
+	ignorePullActors    map[string]bool
+}
+
+// NewEventFilter returns the filter of the configuration, checking its
+// patterns.
+func NewEventFilter(config configuration.Filter) (*EventFilter, error) {
+	for _, pattern := range append(config.Repositories.Include, config.Repositories.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q: %v", pattern, err)
+		}
+	}
+
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag pattern %q: %v", pattern, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	includeTags, err := compile(config.Tags.Include)
+	if err != nil {
+		return nil, err
+	}
+	excludeTags, err := compile(config.Tags.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	ignorePullActors := make(map[string]bool)
+	for _, actor := range config.IgnorePullActors {
+		ignorePullActors[actor] = true
+	}
+
+	return &EventFilter{
+		includeRepositories: config.Repositories.Include,
+		excludeRepositories: config.Repositories.Exclude,
+		includeTags:         includeTags,
+		excludeTags:         excludeTags,
+		ignorePullActors:    ignorePullActors,
+	}, nil
+}
+
+// Match returns true if the event is selected by the filter: its repository
+// matches an included pattern, if any, and no excluded one, its tag, if any,
+// matches an included pattern, if any, and no excluded one, and it is not a
+// pull of an ignored actor.
+func (f *EventFilter) Match(event Event) bool {
+	if event.Action == EventActionPull && f.ignorePullActors[event.Actor.Name] {
+		return false
+	}
+
+	repository := event.Target.Repository
+	if len(f.includeRepositories) > 0 && !matchRepository(f.includeRepositories, repository) {
+		return false
+	}
+	if matchRepository(f.excludeRepositories, repository) {
+		return false
+	}
+
+	if tag := event.Target.Tag; tag != "" {
+		if len(f.includeTags) > 0 && !matchTag(f.includeTags, tag) {
+			return false
+		}
+		if matchTag(f.excludeTags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRepository returns true if a pattern matches the repository or one of
+// the namespaces containing it, so that "team/*" matches "team/app" and
+// "team/app/base".
+func matchRepository(patterns []string, repository string) bool {
+	for _, pattern := range patterns {
+		for name := repository; name != "."; name = path.Dir(name) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if !strings.Contains(name, "/") {
+				break
+			}
+		}
+	}
+	return false
+}
+
+func matchTag(patterns []*regexp.Regexp, tag string) bool {
+	for _, re := range patterns {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteringSink discards the events not selected by its filter, and passes
+// the rest along.
+type filteringSink struct {
+	events.Sink
+	filter *EventFilter
+}
+
+func newFilteringSink(sink events.Sink, filter *EventFilter) events.Sink {
+	if filter == nil {
+		return sink
+	}
+
+	return &filteringSink{
+		Sink:   sink,
+		filter: filter,
+	}
+}
+
+// Write discards the events not selected by the filter and passes the rest
+// along.
+func (fs *filteringSink) Write(event events.Event) error {
+	if e, ok := event.(Event); ok && !fs.filter.Match(e) {
+		return nil
+	}
+
+	return fs.Sink.Write(event)
+}
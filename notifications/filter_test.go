@@ -0,0 +1,100 @@
+package notifications
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestEventFilter(t *testing.T) {
+	event := func(action, repository, tag, actor string) Event {
+		e := createTestEvent(action, repository, "manifest")
+		e.Target.Tag = tag
+		e.Actor.Name = actor
+		return e
+	}
+
+	filter, err := NewEventFilter(configuration.Filter{
+		Repositories: configuration.FilterPatterns{
+			Include: []string{"team/*", "library/ubuntu"},
+			Exclude: []string{"team/scratch"},
+		},
+		Tags: configuration.FilterPatterns{
+			Include: []string{`^v[0-9]+`},
+			Exclude: []string{`-rc`},
+		},
+		IgnorePullActors: []string{"replicator"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating filter: %v", err)
+	}
+
+	for _, tc := range []struct {
+		event    Event
+		expected bool
+	}{
+		{event: event("push", "team/app", "", ""), expected: true},
+		{event: event("push", "team/app/base", "v1", ""), expected: true},
+		{event: event("push", "library/ubuntu", "v22.04", ""), expected: true},
+		{event: event("push", "team", "", "")},
+		{event: event("push", "library/debian", "", "")},
+		{event: event("push", "team/scratch", "", "")},
+		{event: event("push", "team/scratch/app", "", "")},
+		{event: event("push", "team/app", "latest", "")},
+		{event: event("push", "team/app", "v2-rc1", "")},
+		{event: event("pull", "team/app", "v1", "replicator")},
+		{event: event("push", "team/app", "v1", "replicator"), expected: true},
+		{event: event("pull", "team/app", "v1", "user"), expected: true},
+	} {
+		if filter.Match(tc.event) != tc.expected {
+			t.Fatalf("unexpected match of %s %s:%s by %s: %v", tc.event.Action, tc.event.Target.Repository, tc.event.Target.Tag, tc.event.Actor.Name, !tc.expected)
+		}
+	}
+
+	// an empty filter selects every event
+	filter, err = NewEventFilter(configuration.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error creating filter: %v", err)
+	}
+	if !filter.Match(event("pull", "any/repository", "any", "replicator")) {
+		t.Fatalf("expected empty filter to match")
+	}
+}
+
+func TestEventFilterInvalid(t *testing.T) {
+	for _, config := range []configuration.Filter{
+		{Repositories: configuration.FilterPatterns{Include: []string{"team/["}}},
+		{Repositories: configuration.FilterPatterns{Exclude: []string{"team/["}}},
+		{Tags: configuration.FilterPatterns{Include: []string{"v("}}},
+		{Tags: configuration.FilterPatterns{Exclude: []string{"v("}}},
+	} {
+		if _, err := NewEventFilter(config); err == nil {
+			t.Fatalf("expected invalid filter %+v to be rejected", config)
+		}
+	}
+}
+
+func TestFilteringSink(t *testing.T) {
+	filter, err := NewEventFilter(configuration.Filter{
+		Repositories: configuration.FilterPatterns{Include: []string{"team/*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testSink{}
+	s := newFilteringSink(ts, filter)
+	selected := createTestEvent("push", "team/app", "manifest")
+	for _, event := range []Event{selected, createTestEvent("push", "other/app", "manifest")} {
+		if err := s.Write(event); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.count != 1 || !reflect.DeepEqual(ts.event, selected) {
+		t.Fatalf("unexpected events written: %d, %#v", ts.count, ts.event)
+	}
+}
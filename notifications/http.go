@@ -22,8 +22,10 @@ type httpSink struct {
 	client    *http.Client
 	listeners []httpStatusListener
 
-	// TODO(stevvooe): Allow one to configure the media type accepted by this
-	// sink and choose the serialization based on that.
+	// format is the format of the requests, FormatEnvelope if empty
+	format string
+	// secret signs the requests, if set
+	secret []byte
 }
 
 // newHTTPSink returns an unreliable, single-flight http sink. Wrap in other
@@ -64,15 +66,11 @@ func (hs *httpSink) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
-	envelope := Envelope{
-		Events: []events.Event{event},
-	}
-
 	// TODO(stevvooe): It is not ideal to keep re-encoding the request body on
 	// retry but we are going to do it to keep the code simple. It is likely
 	// we could change the event struct to manage its own buffer.
 
-	p, err := json.MarshalIndent(envelope, "", "   ")
+	p, mediaType, err := hs.encode(event)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -80,8 +78,21 @@ func (hs *httpSink) Write(event events.Event) error {
 		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	req, err := http.NewRequest(http.MethodPost, hs.url, bytes.NewReader(p))
+	if err != nil {
+		for _, listener := range hs.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error creating request: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", mediaType)
+	if len(hs.secret) > 0 {
+		// the request is signed on each attempt, so that its timestamp
+		// stays within the tolerance of the receiver
+		Sign(req.Header, hs.secret, p, time.Now())
+	}
+
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -112,6 +123,30 @@ func (hs *httpSink) Write(event events.Event) error {
 	}
 }
 
+// encode returns the body of the request notifying the event in the format
+// of the sink, and its media type.
+func (hs *httpSink) encode(event events.Event) ([]byte, string, error) {
+	switch hs.format {
+	case FormatCloudEvents, FormatCloudEventsBatch:
+		e, ok := event.(Event)
+		if !ok {
+			return nil, "", fmt.Errorf("unexpected event type %T", event)
+		}
+		if hs.format == FormatCloudEvents {
+			p, err := json.Marshal(NewCloudEvent(e))
+			return p, CloudEventsMediaType, err
+		}
+		p, err := json.Marshal([]CloudEvent{NewCloudEvent(e)})
+		return p, CloudEventsBatchMediaType, err
+	default:
+		envelope := Envelope{
+			Events: []events.Event{event},
+		}
+		p, err := json.MarshalIndent(envelope, "", "   ")
+		return p, EventsMediaType, err
+	}
+}
+
 // Close the endpoint
 func (hs *httpSink) Close() error {
 	hs.mu.Lock()
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	events "github.com/docker/go-events"
@@ -199,6 +201,137 @@ func TestHTTPSink(t *testing.T) {
 	}
 }
 
+// TestHTTPSinkFormats checks the body and signature of the requests in each
+// format.
+func TestHTTPSinkFormats(t *testing.T) {
+	secret := []byte("secret")
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	event := createTestEvent("push", "library/test", schema2.MediaTypeManifest)
+	event.Request.Host = "registry.example.com"
+
+	for _, format := range []string{"", FormatEnvelope, FormatCloudEvents, FormatCloudEventsBatch} {
+		sink := newHTTPSink(server.URL, 0, nil, nil)
+		sink.format = format
+		sink.secret = secret
+		if err := sink.Write(event); err != nil {
+			t.Fatalf("%q: unexpected error writing event: %v", format, err)
+		}
+		r, body := <-requests, <-bodies
+
+		if err := VerifySignature(r.Header, secret, body, time.Minute); err != nil {
+			t.Fatalf("%q: unexpected error verifying signature: %v", format, err)
+		}
+
+		var cloudEvents []CloudEvent
+		mediaType := r.Header.Get("Content-Type")
+		switch format {
+		case FormatCloudEvents:
+			var cloudEvent CloudEvent
+			if err := json.Unmarshal(body, &cloudEvent); err != nil {
+				t.Fatalf("%q: error decoding cloud event: %v", format, err)
+			}
+			cloudEvents = append(cloudEvents, cloudEvent)
+			if mediaType != CloudEventsMediaType {
+				t.Fatalf("%q: incorrect media type: %q != %q", format, mediaType, CloudEventsMediaType)
+			}
+		case FormatCloudEventsBatch:
+			if err := json.Unmarshal(body, &cloudEvents); err != nil {
+				t.Fatalf("%q: error decoding cloud events: %v", format, err)
+			}
+			if mediaType != CloudEventsBatchMediaType {
+				t.Fatalf("%q: incorrect media type: %q != %q", format, mediaType, CloudEventsBatchMediaType)
+			}
+		default:
+			if mediaType != EventsMediaType {
+				t.Fatalf("%q: incorrect media type: %q != %q", format, mediaType, EventsMediaType)
+			}
+			continue
+		}
+
+		if len(cloudEvents) != 1 {
+			t.Fatalf("%q: expected one cloud event, got %d", format, len(cloudEvents))
+		}
+		ce := cloudEvents[0]
+		if ce.SpecVersion != "1.0" || ce.ID != event.ID || ce.Type != "io.distribution.registry.push" ||
+			ce.Source != "//registry.example.com/v2/library/test" || ce.Subject != "library/test" {
+			t.Fatalf("%q: unexpected cloud event: %+v", format, ce)
+		}
+		if ce.Data.Target.Digest != event.Target.Digest || !ce.Time.Equal(event.Timestamp) {
+			t.Fatalf("%q: unexpected cloud event data: %+v", format, ce.Data)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"events":[]}`)
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name     string
+		header   func() http.Header
+		expected error
+	}{
+		{
+			name: "valid",
+			header: func() http.Header {
+				header := http.Header{}
+				Sign(header, secret, body, now)
+				return header
+			},
+		},
+		{
+			name: "other secret",
+			header: func() http.Header {
+				header := http.Header{}
+				Sign(header, []byte("other"), body, now)
+				return header
+			},
+			expected: ErrSignatureInvalid,
+		},
+		{
+			name: "timestamp changed",
+			header: func() http.Header {
+				header := http.Header{}
+				Sign(header, secret, body, now.Add(-time.Hour))
+				header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+				return header
+			},
+			expected: ErrSignatureInvalid,
+		},
+		{
+			name: "replayed",
+			header: func() http.Header {
+				header := http.Header{}
+				Sign(header, secret, body, now.Add(-time.Hour))
+				return header
+			},
+			expected: ErrSignatureExpired,
+		},
+		{
+			name:     "unsigned",
+			header:   func() http.Header { return http.Header{} },
+			expected: ErrSignatureInvalid,
+		},
+	} {
+		if err := VerifySignature(tc.header(), secret, body, 5*time.Minute); err != tc.expected {
+			t.Fatalf("%s: unexpected error: %v != %v", tc.name, err, tc.expected)
+		}
+	}
+}
+
 func createTestEvent(action, repo, typ string) Event {
 	event := createEvent(action)
 
@@ -127,6 +127,16 @@ func (msl *manifestServiceListener) Put(ctx context.Context, sm distribution.Man
 	return dgst, err
 }
 
+// Referrers forwards to the wrapped ManifestService, if it supports listing
+// referrers. No events are dispatched for the manifests it reads.
+func (msl *manifestServiceListener) Referrers(ctx context.Context, subject digest.Digest, ingester func(v1.Descriptor) error) error {
+	referrers, ok := msl.ManifestService.(distribution.ReferrerEnumerator)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	return referrers.Referrers(ctx, subject, ingester)
+}
+
 type blobServiceListener struct {
 	distribution.BlobStore
 	parent *repositoryListener
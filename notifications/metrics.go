@@ -75,12 +75,13 @@ func init() {
 // number of events. The goal of this to export it via expvar but we may find
 // some other future solution to be better.
 type EndpointMetrics struct {
-	Pending   int            // events pending in queue
-	Events    int            // total events incoming
-	Successes int            // total events written successfully
-	Failures  int            // total events failed
-	Errors    int            // total events errored
-	Statuses  map[string]int // status code histogram, per call event
+	Pending     int            // events pending in queue
+	Events      int            // total events incoming
+	Successes   int            // total events written successfully
+	Failures    int            // total events failed
+	Errors      int            // total events errored
+	DeadLetters int            // total events moved to the dead letters
+	Statuses    map[string]int // status code histogram, per call event
 }
 
 // safeMetrics guards the metrics implementation with a lock and provides a
@@ -150,8 +151,8 @@ func (emsl *endpointMetricsHTTPStatusListener) err(err error, event events.Event
 	eventsCounter.WithValues("Errors", emsl.EndpointName).Inc(1)
 }
 
-// endpointMetricsEventQueueListener maintains the incoming events counter and
-// the queues pending count.
+// endpointMetricsEventQueueListener maintains the incoming events counter,
+// the queues pending count and the dead letters counter.
 type endpointMetricsEventQueueListener struct {
 	*safeMetrics
 }
@@ -174,6 +175,14 @@ func (eqc *endpointMetricsEventQueueListener) egress(event events.Event) {
 	pendingGauge.WithValues(eqc.EndpointName).Dec(1)
 }
 
+func (eqc *endpointMetricsEventQueueListener) deadLetter(event events.Event) {
+	eqc.Lock()
+	defer eqc.Unlock()
+	eqc.DeadLetters++
+
+	eventsCounter.WithValues("DeadLetters", eqc.EndpointName).Inc(1)
+}
+
 // register places the endpoint into expvar so that stats are tracked.
 func register(e *Endpoint) {
 	endpoints.mu.Lock()
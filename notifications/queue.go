@@ -0,0 +1,321 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pendingDir holds the events pending in the write-ahead log
+	pendingDir = "pending"
+	// deadLetterDir holds the events dropped after too many attempts
+	deadLetterDir = "deadletter"
+
+	// loadBackoff is the wait before retrying to load the write-ahead log
+	loadBackoff = 5 * time.Second
+)
+
+// persistentEventQueue accepts messages into a write-ahead log stored with a
+// storage driver, for asynchronous consumption by a sink. An event is stored
+// before Write returns and removed once the sink accepted it, so the events
+// pending survive restarts and are replayed in order. The queue retries the
+// writes to the sink with its strategy, and moves an event to the dead
+// letters after maxAttempts failures, if set.
+type persistentEventQueue struct {
+	sink        events.Sink
+	strategy    events.RetryStrategy
+	driver      storagedriver.StorageDriver
+	root        string
+	maxAttempts int
+	listeners   []eventQueueListener
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	entries []*queueEntry // sorted by sequence
+	seq     int64
+	closed  bool
+
+	done    chan struct{} // closed with the queue
+	stopped chan struct{} // closed when run returns
+}
+
+// queueEntry is an event stored in the write-ahead log, named by its
+// sequence.
+type queueEntry struct {
+	seq      int64
+	Attempts int   `json:"attempts"`
+	Event    Event `json:"event"`
+}
+
+// deadLetterListener is called when an event is moved to the dead letters.
+// It is optionally implemented by an eventQueueListener.
+type deadLetterListener interface {
+	deadLetter(event events.Event)
+}
+
+// newPersistentEventQueue returns a queue to the provided sink with its
+// write-ahead log under root in the driver. The events pending in the log
+// are replayed before the events written to the queue.
+func newPersistentEventQueue(sink events.Sink, strategy events.RetryStrategy, driver storagedriver.StorageDriver, root string, maxAttempts int, listeners ...eventQueueListener) *persistentEventQueue {
+	pq := persistentEventQueue{
+		sink:        sink,
+		strategy:    strategy,
+		driver:      driver,
+		root:        root,
+		maxAttempts: maxAttempts,
+		listeners:   listeners,
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	pq.cond = sync.NewCond(&pq.mu)
+	go pq.run()
+	return &pq
+}
+
+// Write stores the event in the write-ahead log and accepts it into the
+// queue, failing if the queue has been closed or the event cannot be
+// stored.
+func (pq *persistentEventQueue) Write(event events.Event) error {
+	e, ok := event.(Event)
+	if !ok {
+		return fmt.Errorf("persistenteventqueue: unexpected event type %T", event)
+	}
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if pq.closed {
+		return ErrSinkClosed
+	}
+
+	// sequences follow the clock so that they stay ordered after the events
+	// pending in a log not loaded yet
+	pq.seq = max(pq.seq+1, time.Now().UnixNano())
+	entry := &queueEntry{seq: pq.seq, Event: e}
+	if err := pq.store(entry); err != nil {
+		return fmt.Errorf("persistenteventqueue: error storing event: %v", err)
+	}
+
+	for _, listener := range pq.listeners {
+		listener.ingress(event)
+	}
+	pq.entries = append(pq.entries, entry)
+	pq.cond.Signal() // signal waiters
+
+	return nil
+}
+
+// Close shuts down the event queue. The events pending are kept in the
+// write-ahead log, to be replayed by the next queue.
+func (pq *persistentEventQueue) Close() error {
+	pq.mu.Lock()
+	if pq.closed {
+		pq.mu.Unlock()
+		return fmt.Errorf("persistenteventqueue: already closed")
+	}
+
+	pq.closed = true
+	close(pq.done)
+	pq.cond.Broadcast()
+	pq.mu.Unlock()
+
+	<-pq.stopped
+	return pq.sink.Close()
+}
+
+// run is the main goroutine to flush events to the target sink, after
+// loading the events pending in the write-ahead log.
+func (pq *persistentEventQueue) run() {
+	defer close(pq.stopped)
+
+	for {
+		err := pq.load()
+		if err == nil {
+			break
+		}
+
+		logrus.Errorf("persistenteventqueue: error loading events pending for %v, retrying in %v: %v", pq.sink, loadBackoff, err)
+		select {
+		case <-time.After(loadBackoff):
+		case <-pq.done:
+			return
+		}
+	}
+
+	for {
+		entry := pq.next()
+		if entry == nil {
+			return // nil entry means event queue is closed.
+		}
+
+		if backoff := pq.strategy.Proceed(entry.Event); backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-pq.done:
+				return
+			}
+		}
+
+		err := pq.sink.Write(entry.Event)
+		if err == nil {
+			pq.strategy.Success(entry.Event)
+			if err := pq.driver.Delete(context.Background(), pq.entryPath(pendingDir, entry)); err != nil {
+				if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+					logrus.Errorf("persistenteventqueue: error removing event %d: %v", entry.seq, err)
+				}
+			}
+			pq.pop(entry, false)
+			continue
+		}
+		if err == ErrSinkClosed {
+			return
+		}
+
+		pq.strategy.Failure(entry.Event, err)
+		entry.Attempts++
+		if pq.maxAttempts > 0 && entry.Attempts >= pq.maxAttempts {
+			logrus.Errorf("persistenteventqueue: error writing event %d to %v after %d attempts, moving it to the dead letters: %v", entry.seq, pq.sink, entry.Attempts, err)
+			if err := pq.store(entry); err != nil {
+				logrus.Errorf("persistenteventqueue: error storing event %d: %v", entry.seq, err)
+			}
+			if err := pq.driver.Move(context.Background(), pq.entryPath(pendingDir, entry), pq.entryPath(deadLetterDir, entry)); err != nil {
+				logrus.Errorf("persistenteventqueue: error moving event %d to the dead letters: %v", entry.seq, err)
+			}
+			pq.pop(entry, true)
+			continue
+		}
+
+		logrus.Warnf("persistenteventqueue: error writing event %d to %v, retrying: %v", entry.seq, pq.sink, err)
+		if err := pq.store(entry); err != nil {
+			logrus.Errorf("persistenteventqueue: error storing event %d: %v", entry.seq, err)
+		}
+	}
+}
+
+// next blocks until an event is pending, and returns it without removing
+// it from the queue. When closed, nil will be returned.
+func (pq *persistentEventQueue) next() *queueEntry {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	for len(pq.entries) < 1 {
+		if pq.closed {
+			return nil
+		}
+
+		pq.cond.Wait()
+	}
+
+	return pq.entries[0]
+}
+
+// pop removes the first entry of the queue, once written to the sink or
+// moved to the dead letters.
+func (pq *persistentEventQueue) pop(entry *queueEntry, deadLetter bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.entries = pq.entries[1:]
+	for _, listener := range pq.listeners {
+		listener.egress(entry.Event)
+		if dl, ok := listener.(deadLetterListener); ok && deadLetter {
+			dl.deadLetter(entry.Event)
+		}
+	}
+}
+
+// load adds the events pending in the write-ahead log ahead of the events
+// written since the queue started. Entries which cannot be read are moved
+// to the dead letters.
+func (pq *persistentEventQueue) load() error {
+	ctx := context.Background()
+	paths, err := pq.driver.List(ctx, path.Join(pq.root, pendingDir))
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	var loaded []*queueEntry
+	for _, p := range paths {
+		name := path.Base(p)
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".json") {
+			continue // not an entry, such as a temporary file of the driver
+		}
+
+		content, err := pq.driver.GetContent(ctx, p)
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+
+		entry := &queueEntry{seq: seq}
+		if err := json.Unmarshal(content, entry); err != nil {
+			logrus.Errorf("persistenteventqueue: invalid event %d, moving it to the dead letters: %v", seq, err)
+			if err := pq.driver.Move(ctx, p, pq.entryPath(deadLetterDir, entry)); err != nil {
+				return err
+			}
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	// the events written since the queue started are in the log already
+	written := make(map[int64]bool, len(pq.entries))
+	for _, entry := range pq.entries {
+		written[entry.seq] = true
+	}
+	for _, entry := range loaded {
+		if written[entry.seq] {
+			continue
+		}
+		pq.seq = max(pq.seq, entry.seq)
+		for _, listener := range pq.listeners {
+			listener.ingress(entry.Event)
+		}
+		pq.entries = append(pq.entries, entry)
+	}
+	sort.SliceStable(pq.entries, func(i, j int) bool {
+		return pq.entries[i].seq < pq.entries[j].seq
+	})
+	pq.cond.Signal()
+
+	return nil
+}
+
+// store writes the entry to the write-ahead log.
+func (pq *persistentEventQueue) store(entry *queueEntry) error {
+	p, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return pq.driver.PutContent(context.Background(), pq.entryPath(pendingDir, entry), p)
+}
+
+func (pq *persistentEventQueue) entryPath(dir string, entry *queueEntry) string {
+	return path.Join(pq.root, dir, fmt.Sprintf("%020d.json", entry.seq))
+}
+
+func (pq *persistentEventQueue) String() string {
+	return fmt.Sprintf("persistenteventqueue{%v, %s}", pq.sink, pq.root)
+}
@@ -0,0 +1,175 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	events "github.com/docker/go-events"
+)
+
+// recordingSink records the repositories of the events written, failing
+// while fail is set.
+type recordingSink struct {
+	mu           sync.Mutex
+	fail         bool
+	attempts     int
+	repositories []string
+}
+
+func (rs *recordingSink) Write(event events.Event) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.attempts++
+	if rs.fail {
+		return errors.New("unavailable")
+	}
+	rs.repositories = append(rs.repositories, event.(Event).Target.Repository)
+	return nil
+}
+
+func (rs *recordingSink) Close() error {
+	return nil
+}
+
+func (rs *recordingSink) written() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.repositories...)
+}
+
+func waitForEvents(t *testing.T, sink *recordingSink, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if written := sink.written(); len(written) >= n {
+			return written
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d events, got %v", n, sink.written())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPersistentEventQueueReplay(t *testing.T) {
+	driver := inmemory.New()
+	failing := &recordingSink{fail: true}
+	metrics := newSafeMetrics("")
+	pq := newPersistentEventQueue(failing, events.NewBreaker(1, time.Hour), driver, "/queue", 0, metrics.eventQueueListener())
+
+	for i := 0; i < 3; i++ {
+		if err := pq.Write(createTestEvent("push", fmt.Sprintf("library/test%d", i), "manifest")); err != nil {
+			t.Fatalf("error writing event: %v", err)
+		}
+	}
+	checkClose(t, pq)
+
+	metrics.Lock()
+	if metrics.Events != 3 || metrics.Pending != 3 {
+		t.Fatalf("unexpected metrics of the failing queue: %+v", metrics.EndpointMetrics)
+	}
+	metrics.Unlock()
+
+	// the events pending are replayed by the next queue, before the events
+	// written since
+	var sink recordingSink
+	metrics = newSafeMetrics("")
+	pq = newPersistentEventQueue(&sink, events.NewBreaker(1, time.Hour), driver, "/queue", 0, metrics.eventQueueListener())
+	if err := pq.Write(createTestEvent("push", "library/test3", "manifest")); err != nil {
+		t.Fatalf("error writing event: %v", err)
+	}
+	written := waitForEvents(t, &sink, 4)
+	for i, repository := range written {
+		if expected := fmt.Sprintf("library/test%d", i); repository != expected {
+			t.Fatalf("unexpected event %d: %s != %s", i, repository, expected)
+		}
+	}
+	checkClose(t, pq)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.Events != 4 || metrics.Pending != 0 {
+		t.Fatalf("unexpected metrics of the replaying queue: %+v", metrics.EndpointMetrics)
+	}
+	if paths, err := driver.List(context.Background(), "/queue/"+pendingDir); err == nil && len(paths) != 0 {
+		t.Fatalf("unexpected events left in the log: %v", paths)
+	}
+}
+
+func TestPersistentEventQueueDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	driver := inmemory.New()
+	if err := driver.PutContent(ctx, "/queue/pending/00000000000000000001.json", []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recordingSink{fail: true}
+	metrics := newSafeMetrics("")
+	pq := newPersistentEventQueue(sink, events.NewBreaker(10, time.Millisecond), driver, "/queue", 3, metrics.eventQueueListener())
+	if err := pq.Write(createTestEvent("push", "library/dead", "manifest")); err != nil {
+		t.Fatalf("error writing event: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		metrics.Lock()
+		deadLetters := metrics.DeadLetters
+		metrics.Unlock()
+		if deadLetters == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the event to be moved to the dead letters")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sink.mu.Lock()
+	sink.fail = false
+	sink.mu.Unlock()
+	if err := pq.Write(createTestEvent("push", "library/alive", "manifest")); err != nil {
+		t.Fatalf("error writing event: %v", err)
+	}
+	if written := waitForEvents(t, sink, 1); written[0] != "library/alive" {
+		t.Fatalf("unexpected event written: %v", written)
+	}
+	checkClose(t, pq)
+
+	sink.mu.Lock()
+	if sink.attempts != 4 {
+		t.Fatalf("unexpected number of attempts: %d != 4", sink.attempts)
+	}
+	sink.mu.Unlock()
+
+	// the invalid entry and the event failing are kept as dead letters
+	paths, err := driver.List(ctx, "/queue/"+deadLetterDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("unexpected dead letters: %v", paths)
+	}
+	for _, p := range paths {
+		if path.Base(p) == "00000000000000000001.json" {
+			continue
+		}
+		content, err := driver.GetContent(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := &queueEntry{}
+		if err := json.Unmarshal(content, entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Attempts != 3 || entry.Event.Target.Repository != "library/dead" {
+			t.Fatalf("unexpected dead letter: %+v", entry)
+		}
+	}
+}
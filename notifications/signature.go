@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader is the header holding the HMAC-SHA256 signature of a
+	// request, as "sha256=" followed by the hex encoded signature of the
+	// timestamp, a period and the body of the request.
+	SignatureHeader = "Registry-Signature"
+	// TimestampHeader is the header holding the time at which a request was
+	// signed, in seconds since the Unix epoch.
+	TimestampHeader = "Registry-Timestamp"
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	// ErrSignatureInvalid is returned when the signature of a request does
+	// not match its body and timestamp.
+	ErrSignatureInvalid = errors.New("notifications: invalid signature")
+	// ErrSignatureExpired is returned when the timestamp of a request is out
+	// of the tolerance, as a replayed request would be.
+	ErrSignatureExpired = errors.New("notifications: signature expired")
+)
+
+// Sign sets the timestamp and signature headers of a request with the body,
+// signed with the secret at time t.
+func Sign(header http.Header, secret, body []byte, t time.Time) {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	header.Set(TimestampHeader, timestamp)
+	header.Set(SignatureHeader, signaturePrefix+hex.EncodeToString(signature(secret, timestamp, body)))
+}
+
+// VerifySignature checks that the signature headers of a request match the
+// body signed with the secret, and that the request was signed within
+// tolerance of now. Receivers should also reject the event IDs already
+// received within the tolerance to discard replayed requests.
+func VerifySignature(header http.Header, secret, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+
+	encoded, ok := strings.CutPrefix(header.Get(SignatureHeader), signaturePrefix)
+	if !ok {
+		return ErrSignatureInvalid
+	}
+	sig, err := hex.DecodeString(encoded)
+	if err != nil || !hmac.Equal(sig, signature(secret, timestamp, body)) {
+		return ErrSignatureInvalid
+	}
+
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func signature(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
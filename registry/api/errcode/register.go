@@ -224,6 +224,28 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodeQuotaExceeded is returned when storing content would exceed
+	// the storage quota of the repository.
+	ErrorCodeQuotaExceeded = register(errGroup, ErrorDescriptor{
+		Value:   "QUOTA_EXCEEDED",
+		Message: "storage quota exceeded",
+		Description: `Returned when a blob upload, blob mount or manifest
+		upload would exceed the storage quota configured for the
+		repository or its namespace.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
+	// ErrorCodeTagImmutable is returned when a manifest upload or delete
+	// would change or remove a tag protected by the immutable tags policy.
+	ErrorCodeTagImmutable = register(errGroup, ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `Returned when a manifest upload would point a tag
+		protected by the immutable tags policy at a different manifest, or
+		when a manifest delete would remove a protected tag.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
 )
 
 var (
@@ -98,6 +98,13 @@ var (
 		Format:      "<digest>",
 	}
 
+	subjectHeader = ParameterDescriptor{
+		Name:        "OCI-Subject",
+		Description: "Digest of the subject declared by the uploaded manifest, indicating that the registry has indexed it for the referrers API.",
+		Type:        "digest",
+		Format:      "<digest>",
+	}
+
 	linkHeader = ParameterDescriptor{
 		Name:        "Link",
 		Type:        "link",
@@ -605,6 +612,7 @@ var routeDescriptors = []RouteDescriptor{
 									},
 									contentLengthZeroHeader,
 									digestHeader,
+									subjectHeader,
 								},
 							},
 						},
@@ -728,6 +736,96 @@ var routeDescriptors = []RouteDescriptor{
 		},
 	},
 
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "Retrieve the manifests which declare the manifest identified by `name` and `digest` as their subject.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch an image index listing the referrers of the manifest identified by `digest`. The subject manifest does not need to exist in the repository.",
+				Requests: []RequestDescriptor{
+					{
+						Name: "Referrers",
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							{
+								Name:        "digest",
+								Type:        "path",
+								Required:    true,
+								Format:      digest.DigestRegexp.String(),
+								Description: `Digest of the subject manifest.`,
+							},
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Description: "Only return referrers with the given artifact type.",
+								Format:      "<media type>",
+								Required:    false,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "An image index whose manifests are the descriptors of the referrers of `digest`.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "Comma separated list of the filters applied to the response, if any.",
+										Format:      "artifactType",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+    "schemaVersion": 2,
+    "mediaType": "application/vnd.oci.image.index.v1+json",
+    "manifests": [
+        {
+            "mediaType": <media type>,
+            "digest": <digest>,
+            "size": <size>,
+            "artifactType": <artifact type>,
+            "annotations": {...}
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The name or digest was invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+									errcode.ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+
 	{
 		Name:        RouteNameBlob,
 		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/blobs/{digest:" + digest.DigestRegexp.String() + "}",
@@ -1609,4 +1707,62 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+
+	{
+		// The repository route must be registered after all other routes
+		// under a repository name, since names may contain their path
+		// components.
+		Name:        RouteNameRepository,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/",
+		Entity:      "Repository",
+		Description: "Operations on the repository identified by `name`.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodDelete,
+				Description: "Delete the repository identified by `name`, removing its manifests, tags and layer links. Blobs which are no longer referenced are removed by garbage collection. Repositories whose name starts with `name` are not affected.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Name",
+								Description: "The specified `name` was invalid and the delete was unable to proceed.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+							{
+								Name:        "Not allowed",
+								Description: "Repository delete is not allowed because the registry is configured as a pull-through cache or `delete` has been disabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 }
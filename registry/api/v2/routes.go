@@ -16,6 +16,8 @@ const (
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameReferrers       = "referrers"
+	RouteNameRepository      = "repository"
 )
 
 var (
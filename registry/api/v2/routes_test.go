@@ -142,6 +142,30 @@ func TestRouter(t *testing.T) {
 			RequestURI: "/v2/foo/bar/blobs/uploads/totalandcompletejunk++$$-==",
 			StatusCode: http.StatusNotFound,
 		},
+		{
+			RouteName:  RouteNameReferrers,
+			RequestURI: "/v2/foo/bar/referrers/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameRepository,
+			RequestURI: "/v2/foo/bar/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			// Check ambiguity: ensure the repository route does not shadow
+			// the blob upload route.
+			RouteName:  RouteNameBlobUpload,
+			RequestURI: "/v2/foo/bar/blobs/uploads/",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			// Check ambiguity: ensure we can distinguish between tags for
 			// "foo/bar/image/image" and image for "foo/bar/image" with tag
@@ -139,6 +139,18 @@ func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (
 	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
+// BuildRepositoryURL constructs a url for the named repository.
+func (ub *URLBuilder) BuildRepositoryURL(name reference.Named) (string, error) {
+	route := ub.cloneRoute(RouteNameRepository)
+
+	repositoryURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return repositoryURL.String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
@@ -174,6 +186,19 @@ func (ub *URLBuilder) BuildBlobURL(ref reference.Canonical) (string, error) {
 	return layerURL.String(), nil
 }
 
+// BuildReferrersURL constructs the url listing the referrers of the manifest
+// identified by name and dgst, including any url values.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
 // BuildBlobUploadURL constructs a url to begin a blob upload in the
 // repository identified by name.
 func (ub *URLBuilder) BuildBlobUploadURL(name reference.Named, values ...url.Values) (string, error) {
@@ -54,6 +54,14 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				})
 			},
 		},
+		{
+			description:  "test repository url",
+			expectedPath: "/v2/foo/bar/",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildRepositoryURL(fooBarRef)
+			},
+		},
 		{
 			description:  "test manifest url tagged ref",
 			expectedPath: "/v2/foo/bar/manifests/tag",
@@ -80,6 +88,26 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildBlobURL(ref)
 			},
 		},
+		{
+			description:  "build referrers url",
+			expectedPath: "/v2/foo/bar/referrers/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+				return urlBuilder.BuildReferrersURL(ref)
+			},
+		},
+		{
+			description:  "build referrers url with artifactType filter",
+			expectedPath: "/v2/foo/bar/referrers/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5?artifactType=application%2Fexample",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+				return urlBuilder.BuildReferrersURL(ref, url.Values{
+					"artifactType": []string{"application/example"},
+				})
+			},
+		},
 		{
 			description:  "build blob upload url",
 			expectedPath: "/v2/foo/bar/blobs/uploads/",
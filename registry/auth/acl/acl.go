@@ -0,0 +1,186 @@
+// Package acl provides the access control lists of the access controllers
+// authenticating users by themselves, such as htpasswd and mtls, granting
+// users and groups of users actions on repositories.
+package acl
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// file is the format of an ACL file, granting users and groups of users
+// actions on the repositories matching patterns, such as:
+//
+//	groups:
+//	  developers: [alice, bob]
+//	rules:
+//	  - groups: [developers]
+//	    repositories: ["team/*"]
+//	    actions: [pull, push]
+//	  - users: ["*"]
+//	    repositories: ["library/*"]
+//	    actions: [pull]
+//	  - users: [admin]
+//	    repositories: ["*"]
+//	    actions: ["*", "registry:catalog:*"]
+//
+// A user pattern matches the users as path.Match does, and the "*" user
+// matches all the users. A repository pattern matches the repositories it
+// names and those within them, so "team/*" matches "team/app" and
+// "team/app/base". The "*" action matches all the repository actions.
+// Actions on other resources are written as "type:name:action".
+type file struct {
+	Groups map[string][]string `yaml:"groups"`
+	Rules  []rule              `yaml:"rules"`
+}
+
+// rule grants the users and the members of the groups the actions on the
+// repositories.
+type rule struct {
+	Users        []string `yaml:"users"`
+	Groups       []string `yaml:"groups"`
+	Repositories []string `yaml:"repositories"`
+	Actions      []string `yaml:"actions"`
+}
+
+// ACL checks access against an ACL file, parsed again when it changes.
+type ACL struct {
+	path    string
+	modtime time.Time
+	mu      sync.Mutex
+	file    *file
+}
+
+// New returns the ACL of the file at path, checking the file.
+func New(path string) (*ACL, error) {
+	a := &ACL{path: path}
+	if _, err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Check returns an error wrapping auth.ErrAccessDenied if the latest ACL file
+// does not grant the user every access, or the error reading the file.
+func (a *ACL) Check(username string, access ...auth.Access) error {
+	f, err := a.load()
+	if err != nil {
+		return err
+	}
+
+	for _, access := range access {
+		if !f.allows(username, access) {
+			return fmt.Errorf("%w: %s %s:%s to %q", auth.ErrAccessDenied, access.Action, access.Type, access.Name, username)
+		}
+	}
+	return nil
+}
+
+func (f *file) allows(username string, access auth.Access) bool {
+	for _, rule := range f.Rules {
+		if !f.applies(rule, username) {
+			continue
+		}
+		if access.Type == "repository" {
+			if (slices.Contains(rule.Actions, "*") || slices.Contains(rule.Actions, access.Action)) &&
+				matchRepository(rule.Repositories, access.Name) {
+				return true
+			}
+			continue
+		}
+		resource := access.Type + ":" + access.Name + ":"
+		if slices.Contains(rule.Actions, resource+"*") || slices.Contains(rule.Actions, resource+access.Action) {
+			return true
+		}
+	}
+	return false
+}
+
+// applies returns true if the rule applies to the user, or to a group of the
+// user.
+func (f *file) applies(rule rule, username string) bool {
+	if matchUser(rule.Users, username) {
+		return true
+	}
+	for _, group := range rule.Groups {
+		if matchUser(f.Groups[group], username) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchUser(patterns []string, username string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(pattern, username); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRepository returns true if a pattern matches the repository or one of
+// the namespaces containing it.
+func matchRepository(patterns []string, repository string) bool {
+	for _, pattern := range patterns {
+		for name := repository; ; name = path.Dir(name) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if !strings.Contains(name, "/") {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// load returns the ACL file, dynamically parsing the latest rules.
+func (a *ACL) load() (*file, error) {
+	fstat, err := os.Stat(a.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := fstat.ModTime()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil || !a.modtime.Equal(lastModified) {
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			return nil, err
+		}
+
+		var f file
+		if err := yaml.UnmarshalStrict(data, &f); err != nil {
+			return nil, fmt.Errorf("unable to parse acl file %q: %v", a.path, err)
+		}
+		for i, rule := range f.Rules {
+			for _, group := range rule.Groups {
+				if _, ok := f.Groups[group]; !ok {
+					return nil, fmt.Errorf("acl file %q: rule %d: unknown group %q", a.path, i, group)
+				}
+			}
+			for _, pattern := range append(rule.Users, rule.Repositories...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("acl file %q: rule %d: invalid pattern %q: %v", a.path, i, pattern, err)
+				}
+			}
+		}
+		a.modtime = lastModified
+		a.file = &f
+	}
+	return a.file, nil
+}
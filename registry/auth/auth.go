@@ -43,6 +43,11 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrAccessDenied is returned, possibly wrapped, when an authenticated
+	// request is not granted the access it requires. The registry responds
+	// with a 403 DENIED error rather than a challenge.
+	ErrAccessDenied = errors.New("access denied")
 )
 
 // InitFunc is the type of an AccessController factory function and is used
@@ -109,6 +114,16 @@ type AccessController interface {
 	Authorized(r *http.Request, access ...Access) (*Grant, error)
 }
 
+// Handler is optionally implemented by an AccessController serving requests
+// on the registry, such as the token server of the token access controller.
+// The requests to its path are not subject to the access controller.
+type Handler interface {
+	http.Handler
+
+	// Path returns the path of the requests served by the handler.
+	Path() string
+}
+
 // CredentialAuthenticator is an object which is able to authenticate credentials
 type CredentialAuthenticator interface {
 	AuthenticateUser(username, password string) error
@@ -20,6 +20,7 @@ import (
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,11 +31,9 @@ func init() {
 }
 
 type accessController struct {
-	realm    string
-	path     string
-	modtime  time.Time
-	mu       sync.Mutex
-	htpasswd *htpasswd
+	realm         string
+	authenticator *Authenticator
+	acl           *acl.ACL
 }
 
 var _ auth.AccessController = &accessController{}
@@ -53,7 +52,20 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if err := createHtpasswdFile(path); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
+	ac := &accessController{realm: realm.(string), authenticator: NewAuthenticator(path)}
+
+	if aclOpt, present := options["acl"]; present {
+		aclPath, ok := aclOpt.(string)
+		if !ok || aclPath == "" {
+			return nil, fmt.Errorf(`"acl" must be a path for htpasswd access controller`)
+		}
+		a, err := acl.New(aclPath)
+		if err != nil {
+			return nil, err
+		}
+		ac.acl = a
+	}
+	return ac, nil
 }
 
 func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
@@ -65,43 +77,90 @@ func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.
 		}
 	}
 
-	// Dynamically parsing the latest account list
-	fstat, err := os.Stat(ac.path)
+	if err := ac.authenticator.AuthenticateUser(username, password); err != nil {
+		if err != auth.ErrAuthenticationFailure {
+			return nil, err
+		}
+		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
+		return nil, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrAuthenticationFailure,
+		}
+	}
+
+	if ac.acl != nil {
+		if err := ac.acl.Check(username, accessRecords...); err != nil {
+			return nil, err
+		}
+	}
+
+	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+}
+
+// Authenticator authenticates users against an htpasswd file, parsed again
+// when it changes.
+type Authenticator struct {
+	path     string
+	modtime  time.Time
+	mu       sync.Mutex
+	htpasswd *htpasswd
+}
+
+var _ auth.CredentialAuthenticator = &Authenticator{}
+
+// NewAuthenticator returns an authenticator of the users of the htpasswd
+// file at path.
+func NewAuthenticator(path string) *Authenticator {
+	return &Authenticator{path: path}
+}
+
+// AuthenticateUser checks the credential against the latest htpasswd file. It
+// returns auth.ErrAuthenticationFailure if the check fails, or the error
+// reading the file.
+func (a *Authenticator) AuthenticateUser(username, password string) error {
+	h, err := a.load()
+	if err != nil {
+		return err
+	}
+	return h.authenticateUser(username, password)
+}
+
+// HasUser returns true if the user is in the latest htpasswd file.
+func (a *Authenticator) HasUser(username string) (bool, error) {
+	h, err := a.load()
+	if err != nil {
+		return false, err
+	}
+	_, ok := h.entries[username]
+	return ok, nil
+}
+
+// load returns the htpasswd file, dynamically parsing the latest account
+// list.
+func (a *Authenticator) load() (*htpasswd, error) {
+	fstat, err := os.Stat(a.path)
 	if err != nil {
 		return nil, err
 	}
 
 	lastModified := fstat.ModTime()
-	ac.mu.Lock()
-	if ac.htpasswd == nil || !ac.modtime.Equal(lastModified) {
-		ac.modtime = lastModified
-
-		f, err := os.Open(ac.path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.htpasswd == nil || !a.modtime.Equal(lastModified) {
+		f, err := os.Open(a.path)
 		if err != nil {
-			ac.mu.Unlock()
 			return nil, err
 		}
 		defer f.Close()
 
 		h, err := newHTPasswd(f)
 		if err != nil {
-			ac.mu.Unlock()
 			return nil, err
 		}
-		ac.htpasswd = h
+		a.modtime = lastModified
+		a.htpasswd = h
 	}
-	localHTPasswd := ac.htpasswd
-	ac.mu.Unlock()
-
-	if err := localHTPasswd.authenticateUser(username, password); err != nil {
-		dcontext.GetLogger(req.Context()).Errorf("error authenticating user %q: %v", username, err)
-		return nil, &challenge{
-			realm: ac.realm,
-			err:   auth.ErrAuthenticationFailure,
-		}
-	}
-
-	return &auth.Grant{User: auth.UserInfo{Name: username}}, nil
+	return a.htpasswd, nil
 }
 
 // challenge implements the auth.Challenge interface.
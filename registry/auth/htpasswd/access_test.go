@@ -2,11 +2,14 @@ package htpasswd
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 )
@@ -154,3 +157,101 @@ func TestCreateHtpasswdFile(t *testing.T) {
 		t.Fatalf("failed to find default user in file %s", string(content))
 	}
 }
+
+func TestACLAccessController(t *testing.T) {
+	dir := t.TempDir()
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	// the password of both users is baggins
+	if err := os.WriteFile(htpasswdPath, []byte(`bilbo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W
+frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	aclPath := filepath.Join(dir, "acl.yml")
+	if err := os.WriteFile(aclPath, []byte(`groups:
+  hobbits: [bilbo, frodo]
+rules:
+  - groups: [hobbits]
+    repositories: ["shire/*"]
+    actions: [pull, push]
+  - users: ["*"]
+    repositories: [library]
+    actions: [pull]
+  - users: [bilbo]
+    actions: ["*", "registry:catalog:*"]
+    repositories: ["*"]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	accessController, err := newAccessController(map[string]interface{}{
+		"realm": "The-Shire",
+		"path":  htpasswdPath,
+		"acl":   aclPath,
+	})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	authorized := func(user string, access ...auth.Access) error {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.SetBasicAuth(user, "baggins")
+		_, err := accessController.Authorized(req, access...)
+		return err
+	}
+	repository := func(name, action string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+	}
+	catalog := auth.Access{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}
+
+	for _, tc := range []struct {
+		user    string
+		access  []auth.Access
+		allowed bool
+	}{
+		{user: "frodo", allowed: true},
+		{user: "frodo", access: []auth.Access{repository("shire/ring", "pull"), repository("shire/ring", "push")}, allowed: true},
+		{user: "frodo", access: []auth.Access{repository("shire/ring/one", "push")}, allowed: true},
+		{user: "frodo", access: []auth.Access{repository("library", "pull")}, allowed: true},
+		{user: "frodo", access: []auth.Access{repository("shire/ring", "delete")}},
+		{user: "frodo", access: []auth.Access{repository("shire/ring", "pull"), repository("mordor/ring", "pull")}},
+		{user: "frodo", access: []auth.Access{repository("library", "push")}},
+		{user: "frodo", access: []auth.Access{catalog}},
+		{user: "bilbo", access: []auth.Access{repository("mordor/ring", "delete"), catalog}, allowed: true},
+	} {
+		err := authorized(tc.user, tc.access...)
+		if tc.allowed && err != nil {
+			t.Fatalf("unexpected error authorizing %s for %v: %v", tc.user, tc.access, err)
+		}
+		if !tc.allowed && !errors.Is(err, auth.ErrAccessDenied) {
+			t.Fatalf("expected access of %s to %v to be denied: %v", tc.user, tc.access, err)
+		}
+	}
+
+	// a denied user is still challenged for invalid credentials
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.SetBasicAuth("frodo", "wrong")
+	if _, err := accessController.Authorized(req, repository("mordor/ring", "pull")); err == nil {
+		t.Fatal("expected invalid credentials to be challenged")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("unexpected error for invalid credentials: %v", err)
+	}
+
+	// the acl file is reloaded when it changes
+	if err := os.WriteFile(aclPath, []byte(`rules:
+  - users: [frodo]
+    repositories: ["mordor/*"]
+    actions: [pull]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(aclPath, time.Now().Add(time.Minute), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := authorized("frodo", repository("mordor/ring", "pull")); err != nil {
+		t.Fatalf("unexpected error authorizing with the reloaded acl: %v", err)
+	}
+	if err := authorized("frodo", repository("shire/ring", "pull")); !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected access denied with the reloaded acl: %v", err)
+	}
+}
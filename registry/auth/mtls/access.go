@@ -0,0 +1,141 @@
+// Package mtls provides an access controller authenticating the clients by the
+// certificate they present in a mutual TLS handshake, verified against the
+// client CAs of the registry TLS configuration, and granting them access with
+// a rules file.
+package mtls
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
+	"github.com/sirupsen/logrus"
+)
+
+// Identities of a client certificate.
+const (
+	// IdentityCommonName is the common name of the subject of the
+	// certificate.
+	IdentityCommonName = "cn"
+	// IdentityURI is the first URI subject alternative name of the
+	// certificate.
+	IdentityURI = "uri"
+	// IdentitySPIFFE is the SPIFFE ID of the certificate, its URI subject
+	// alternative name with the spiffe scheme.
+	IdentitySPIFFE = "spiffe"
+)
+
+var (
+	// ErrNoCertificate is returned when a request does not present a
+	// verified client certificate.
+	ErrNoCertificate = errors.New("no verified client certificate")
+
+	// ErrNoIdentity is returned when a verified client certificate does not
+	// hold the configured identity.
+	ErrNoIdentity = errors.New("no identity in client certificate")
+)
+
+func init() {
+	if err := auth.Register("mtls", auth.InitFunc(newAccessController)); err != nil {
+		logrus.Errorf("failed to register mtls auth: %v", err)
+	}
+}
+
+type accessController struct {
+	identities []string
+	rules      *acl.ACL
+}
+
+var _ auth.AccessController = &accessController{}
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	rulesOpt, present := options["rules"]
+	rules, ok := rulesOpt.(string)
+	if !present || !ok || rules == "" {
+		return nil, fmt.Errorf(`"rules" must be set for mtls access controller`)
+	}
+
+	// by default, the most specific identity of the certificate is used
+	identities := []string{IdentitySPIFFE, IdentityURI, IdentityCommonName}
+	if identityOpt, present := options["identity"]; present {
+		identity, ok := identityOpt.(string)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf(`"identity" must be a string for mtls access controller`)
+		case identity == IdentityCommonName, identity == IdentityURI, identity == IdentitySPIFFE:
+			identities = []string{identity}
+		default:
+			return nil, fmt.Errorf("unknown identity %q for mtls access controller", identity)
+		}
+	}
+
+	a, err := acl.New(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &accessController{identities: identities, rules: a}, nil
+}
+
+// Authorized grants the access of the rules to the identity of the verified
+// client certificate of the request.
+func (ac *accessController) Authorized(req *http.Request, accessRecords ...auth.Access) (*auth.Grant, error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil, challenge{err: ErrNoCertificate}
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	identity := ac.identity(cert)
+	if identity == "" {
+		return nil, challenge{err: ErrNoIdentity}
+	}
+
+	if err := ac.rules.Check(identity, accessRecords...); err != nil {
+		return nil, err
+	}
+
+	dcontext.GetLogger(req.Context()).Debugf("authenticated client certificate %q", identity)
+	return &auth.Grant{User: auth.UserInfo{Name: identity}}, nil
+}
+
+// identity returns the first configured identity the certificate holds.
+func (ac *accessController) identity(cert *x509.Certificate) string {
+	for _, identity := range ac.identities {
+		switch identity {
+		case IdentityCommonName:
+			if cert.Subject.CommonName != "" {
+				return cert.Subject.CommonName
+			}
+		case IdentityURI:
+			if len(cert.URIs) > 0 {
+				return cert.URIs[0].String()
+			}
+		case IdentitySPIFFE:
+			for _, uri := range cert.URIs {
+				if strings.EqualFold(uri.Scheme, "spiffe") {
+					return uri.String()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// challenge implements the auth.Challenge interface. Client certificates are
+// presented during the TLS handshake, so the challenge sets no headers.
+type challenge struct {
+	err error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets no header on the response.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("mutual tls authentication challenge: %s", ch.err)
+}
@@ -0,0 +1,122 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+func newTestCertificate(t *testing.T, cn string, uris ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAccessController(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.yml")
+	if err := os.WriteFile(rules, []byte(`groups:
+  ci: ["spiffe://example.org/ci/*"]
+rules:
+  - groups: [ci]
+    repositories: ["team/*"]
+    actions: [pull, push]
+  - users: [builder]
+    repositories: ["library/*"]
+    actions: [pull]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	accessController, err := newAccessController(map[string]interface{}{"rules": rules})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+	cnAccessController, err := newAccessController(map[string]interface{}{"rules": rules, "identity": "cn"})
+	if err != nil {
+		t.Fatalf("error creating access controller: %v", err)
+	}
+
+	workload := newTestCertificate(t, "builder", "https://example.org/builder", "spiffe://example.org/ci/pipeline")
+	authorized := func(ac auth.AccessController, cert *x509.Certificate, access ...auth.Access) (*auth.Grant, error) {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return ac.Authorized(req, access...)
+	}
+	repository := func(name, action string) auth.Access {
+		return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+	}
+
+	// the spiffe id is preferred by default
+	grant, err := authorized(accessController, workload, repository("team/app", "push"))
+	if err != nil {
+		t.Fatalf("unexpected error authorizing workload: %v", err)
+	}
+	if grant.User.Name != "spiffe://example.org/ci/pipeline" {
+		t.Fatalf("unexpected user: %q", grant.User.Name)
+	}
+	if _, err := authorized(accessController, workload, repository("library/ubuntu", "pull")); !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected access denied: %v", err)
+	}
+
+	grant, err = authorized(cnAccessController, workload, repository("library/ubuntu", "pull"))
+	if err != nil {
+		t.Fatalf("unexpected error authorizing workload by common name: %v", err)
+	}
+	if grant.User.Name != "builder" {
+		t.Fatalf("unexpected user: %q", grant.User.Name)
+	}
+
+	// requests without a verified certificate are challenged
+	if _, err := authorized(accessController, nil); err == nil {
+		t.Fatal("expected request without certificate to be challenged")
+	} else if _, ok := err.(auth.Challenge); !ok {
+		t.Fatalf("unexpected error without certificate: %v", err)
+	}
+	if _, err := authorized(cnAccessController, newTestCertificate(t, "")); !errors.Is(err.(challenge).err, ErrNoIdentity) {
+		t.Fatalf("unexpected error without identity: %v", err)
+	}
+
+	if _, err := newAccessController(map[string]interface{}{"rules": rules, "identity": "email"}); err == nil {
+		t.Fatal("expected unknown identity to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/json"
@@ -11,7 +12,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/go-jose/go-jose/v4"
@@ -158,6 +161,7 @@ type accessController struct {
 	service           string
 	rootCerts         *x509.CertPool
 	trustedKeys       map[string]crypto.PublicKey
+	remoteKeys        *remoteKeySet
 	signingAlgorithms []jose.SignatureAlgorithm
 }
 
@@ -176,6 +180,9 @@ type tokenAccessOptions struct {
 	rootCertBundle    string
 	jwks              string
 	signingAlgorithms []string
+	oidcIssuer        string
+	jwksRefresh       time.Duration
+	server            *tokenServerOptions
 }
 
 // checkOptions gathers the necessary options
@@ -243,6 +250,36 @@ func checkOptions(options map[string]interface{}) (tokenAccessOptions, error) {
 		}
 	}
 
+	if oidcIssuerVal, ok := options["oidcissuer"]; ok {
+		oidcIssuer, ok := oidcIssuerVal.(string)
+		if !ok || !strings.HasPrefix(oidcIssuer, "https://") {
+			return tokenAccessOptions{}, errors.New("token auth requires a valid https url: oidcissuer")
+		}
+		opts.oidcIssuer = oidcIssuer
+	}
+
+	opts.jwksRefresh = defaultJWKSRefreshInterval
+	if jwksRefreshVal, ok := options["jwksrefreshinterval"]; ok {
+		var err error
+		switch v := jwksRefreshVal.(type) {
+		case string:
+			opts.jwksRefresh, err = time.ParseDuration(v)
+		case int:
+			opts.jwksRefresh = time.Duration(v)
+		default:
+			err = errors.New("not a duration")
+		}
+		if err != nil || opts.jwksRefresh < minJWKSRefetchInterval {
+			return tokenAccessOptions{}, fmt.Errorf("token auth requires a valid jwksrefreshinterval of at least %s", minJWKSRefetchInterval)
+		}
+	}
+
+	server, err := checkServerOptions(options)
+	if err != nil {
+		return tokenAccessOptions{}, err
+	}
+	opts.server = server
+
 	return opts, nil
 }
 
@@ -282,8 +319,6 @@ func getRootCerts(path string) ([]*x509.Certificate, error) {
 }
 
 func getJwks(path string) (*jose.JSONWebKeySet, error) {
-	// TODO(milosgajdos): we should consider providing a JWKS
-	// URL from which the JWKS could be fetched
 	jp, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open jwks file %q: %s", path, err)
@@ -326,6 +361,7 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		rootCerts []*x509.Certificate
 		jwks      *jose.JSONWebKeySet
 		signAlgos []jose.SignatureAlgorithm
+		server    *tokenServer
 	)
 
 	if config.rootCertBundle != "" {
@@ -335,15 +371,26 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		}
 	}
 
-	if config.jwks != "" {
+	remote := config.oidcIssuer != "" || strings.HasPrefix(config.jwks, "https://")
+	if remote && config.oidcIssuer != "" && config.jwks != "" {
+		return nil, errors.New("token auth requires either jwks or oidcissuer")
+	}
+	if config.jwks != "" && !remote {
 		jwks, err = jwkFetcher(config.jwks)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	if (len(rootCerts) == 0 && jwks == nil) || // no certs bundle and no jwks
-		(len(rootCerts) == 0 && jwks != nil && len(jwks.Keys) == 0) { // no certs bundle and empty jwks
+	if config.server != nil {
+		server, err = newTokenServer(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !remote && server == nil && ((len(rootCerts) == 0 && jwks == nil) || // no certs bundle, no jwks and no server
+		(len(rootCerts) == 0 && jwks != nil && len(jwks.Keys) == 0)) { // no certs bundle and empty jwks
 		return nil, errors.New("token auth requires at least one token signing key")
 	}
 
@@ -362,6 +409,10 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		}
 	}
 
+	if server != nil {
+		trustedKeys[server.key.KeyID] = server.key.Key
+	}
+
 	signAlgos, err = getSigningAlgorithms(config.signingAlgorithms)
 	if err != nil {
 		return nil, err
@@ -372,7 +423,17 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		signAlgos = defaultSigningAlgorithms
 	}
 
-	return &accessController{
+	var remoteKeys *remoteKeySet
+	if remote {
+		remoteKeys = newRemoteKeySet(config.jwks, config.oidcIssuer, config.jwksRefresh, trustedKeys)
+		// the registry starts without the remote keys if they are unavailable,
+		// as reported by the health check
+		if err := remoteKeys.refresh(context.Background()); err != nil {
+			logrus.Errorf("unable to fetch token auth signing keys: %v", err)
+		}
+	}
+
+	ac := &accessController{
 		realm:             config.realm,
 		autoRedirect:      config.autoRedirect,
 		autoRedirectPath:  config.autoRedirectPath,
@@ -380,10 +441,31 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 		service:           config.service,
 		rootCerts:         rootPool,
 		trustedKeys:       trustedKeys,
+		remoteKeys:        remoteKeys,
 		signingAlgorithms: signAlgos,
+	}
+
+	if server == nil {
+		return ac, nil
+	}
+	if !slices.Contains(signAlgos, server.algorithm) {
+		return nil, fmt.Errorf("token auth server signing algorithm %s is not among the signing algorithms", server.algorithm)
+	}
+	return &serverAccessController{
+		accessController: ac,
+		tokenServer:      server,
 	}, nil
 }
 
+// serverAccessController is an accessController with a built-in token server,
+// which the registry serves at the path of the token endpoint.
+type serverAccessController struct {
+	*accessController
+	*tokenServer
+}
+
+var _ auth.Handler = &serverAccessController{}
+
 // Authorized handles checking whether the given request is authorized
 // for actions on resources described by the given access items.
 func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Access) (*auth.Grant, error) {
@@ -407,11 +489,24 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		return nil, challenge
 	}
 
+	trustedKeys := ac.trustedKeys
+	if ac.remoteKeys != nil {
+		var kid string
+		if len(token.JWT.Headers) > 0 {
+			header := token.JWT.Headers[0]
+			kid = header.KeyID
+			if header.JSONWebKey != nil {
+				kid = header.JSONWebKey.KeyID
+			}
+		}
+		trustedKeys = ac.remoteKeys.trustedKeys(req.Context(), kid)
+	}
+
 	verifyOpts := VerifyOptions{
 		TrustedIssuers:    []string{ac.issuer},
 		AcceptedAudiences: []string{ac.service},
 		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
+		TrustedKeys:       trustedKeys,
 	}
 
 	claims, err := token.Verify(verifyOpts)
@@ -433,3 +528,12 @@ func (ac *accessController) Authorized(req *http.Request, accessItems ...auth.Ac
 		Resources: claims.resources(),
 	}, nil
 }
+
+// Check reports the health of the source of the remote signing keys, if any,
+// fetching the keys if they are stale.
+func (ac *accessController) Check(ctx context.Context) error {
+	if ac.remoteKeys == nil {
+		return nil
+	}
+	return ac.remoteKeys.Check(ctx)
+}
@@ -0,0 +1,193 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	defaultJWKSRefreshInterval = time.Hour
+
+	// minJWKSRefetchInterval is the minimum interval between the fetches of
+	// the remote keys, and the initial backoff after a failed fetch.
+	minJWKSRefetchInterval = 10 * time.Second
+
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// remoteKeysClient is the client fetching the remote keys and discovery
+// documents.
+var remoteKeysClient = &http.Client{Timeout: 10 * time.Second}
+
+// remoteKeySet caches the keys of a remote JWKS, fetched from a URL or from
+// the discovery document of an OIDC issuer. The keys are fetched again when
+// they are older than the refresh interval, and when a token is signed by an
+// unknown key, backing off after failures.
+type remoteKeySet struct {
+	url        string // the URL of the JWKS, unless discovered
+	issuer     string // the OIDC issuer discovering the URL, if any
+	interval   time.Duration
+	staticKeys map[string]crypto.PublicKey
+
+	fetchMu sync.Mutex // serializes the fetches
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // the remote and static keys
+	fetched time.Time                   // the last successful fetch
+	next    time.Time                   // the earliest next fetch
+	backoff time.Duration
+	err     error // the error of the last fetch
+}
+
+func newRemoteKeySet(url, issuer string, interval time.Duration, staticKeys map[string]crypto.PublicKey) *remoteKeySet {
+	return &remoteKeySet{
+		url:        url,
+		issuer:     issuer,
+		interval:   interval,
+		staticKeys: staticKeys,
+		keys:       staticKeys,
+		err:        errors.New("remote keys not fetched yet"),
+	}
+}
+
+// trustedKeys returns the trusted keys, fetching the remote keys when they are
+// stale or when kid is unknown, unless backing off.
+func (rk *remoteKeySet) trustedKeys(ctx context.Context, kid string) map[string]crypto.PublicKey {
+	rk.mu.Lock()
+	keys := rk.keys
+	_, known := keys[kid]
+	now := time.Now()
+	due := !now.Before(rk.next) && (now.Sub(rk.fetched) >= rk.interval || (kid != "" && !known))
+	rk.mu.Unlock()
+
+	if !due {
+		return keys
+	}
+	if err := rk.refresh(ctx); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error fetching token signing keys: %v", err)
+	}
+
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	return rk.keys
+}
+
+// refresh fetches the remote keys, unless they are being fetched or backing
+// off.
+func (rk *remoteKeySet) refresh(ctx context.Context) error {
+	rk.fetchMu.Lock()
+	defer rk.fetchMu.Unlock()
+
+	rk.mu.Lock()
+	if time.Now().Before(rk.next) {
+		// fetched while waiting for the lock, or backing off
+		err := rk.err
+		rk.mu.Unlock()
+		return err
+	}
+	rk.mu.Unlock()
+
+	keys, err := rk.fetch(ctx)
+
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	now := time.Now()
+	rk.err = err
+	if err != nil {
+		rk.backoff = min(max(2*rk.backoff, minJWKSRefetchInterval), rk.interval)
+		rk.next = now.Add(rk.backoff)
+		return err
+	}
+
+	trusted := make(map[string]crypto.PublicKey, len(keys)+len(rk.staticKeys))
+	for kid, key := range rk.staticKeys {
+		trusted[kid] = key
+	}
+	for kid, key := range keys {
+		trusted[kid] = key
+	}
+	rk.keys = trusted
+	rk.fetched = now
+	rk.backoff = 0
+	rk.next = now.Add(minJWKSRefetchInterval)
+	return nil
+}
+
+// fetch returns the keys of the remote JWKS, discovering its URL first if
+// needed.
+func (rk *remoteKeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	url := rk.url
+	if rk.issuer != "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(ctx, strings.TrimSuffix(rk.issuer, "/")+oidcDiscoveryPath, &discovery); err != nil {
+			return nil, err
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(rk.issuer, "/") {
+			return nil, fmt.Errorf("oidc discovery document of %q is for issuer %q", rk.issuer, discovery.Issuer)
+		}
+		if !strings.HasPrefix(discovery.JWKSURI, "https://") {
+			return nil, fmt.Errorf("oidc discovery document of %q has no https jwks_uri", rk.issuer)
+		}
+		url = discovery.JWKSURI
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := getJSON(ctx, url, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		keys[key.KeyID] = key.Public()
+	}
+	return keys, nil
+}
+
+// Check returns the error of the last fetch of the keys, fetching them first
+// if they are stale.
+func (rk *remoteKeySet) Check(ctx context.Context) error {
+	rk.mu.Lock()
+	due := time.Since(rk.fetched) >= rk.interval
+	rk.mu.Unlock()
+	if due {
+		return rk.refresh(ctx)
+	}
+
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	return rk.err
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := remoteKeysClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode %s: %v", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// testIdentityProvider serves the discovery document and the keys of an OIDC
+// issuer.
+type testIdentityProvider struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jose.JSONWebKey
+	fail    bool
+	fetches int
+}
+
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+	idp := &testIdentityProvider{}
+	idp.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+
+		switch r.URL.Path {
+		case oidcDiscoveryPath:
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/keys"})
+		case "/keys":
+			idp.fetches++
+			if idp.fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			// nolint:errcheck
+			json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: idp.keys})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(idp.Close)
+
+	client := remoteKeysClient
+	remoteKeysClient = idp.Client()
+	t.Cleanup(func() { remoteKeysClient = client })
+	return idp
+}
+
+// rotate makes the identity provider serve a new signing key, returning it.
+func (idp *testIdentityProvider) rotate(t *testing.T, kid string) *jose.JSONWebKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := &jose.JSONWebKey{Key: key, KeyID: kid, Algorithm: string(jose.ES256)}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.keys = []jose.JSONWebKey{jwk.Public()}
+	return jwk
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	key1 := idp.rotate(t, "key-1")
+
+	service := "test-service.example.com"
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":      "https://auth.example.com/token/",
+		"issuer":     idp.URL,
+		"service":    service,
+		"oidcissuer": idp.URL,
+	})
+	if err != nil {
+		t.Fatalf("unable to create access controller: %v", err)
+	}
+	controller := ac.(*accessController)
+
+	authorized := func(jwk *jose.JSONWebKey) error {
+		now := time.Now()
+		token, err := makeTestToken(jwk, idp.URL, service, nil, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.Raw)
+		_, err = ac.Authorized(req)
+		return err
+	}
+
+	if err := authorized(key1); err != nil {
+		t.Fatalf("unexpected error authorizing token: %v", err)
+	}
+	if err := controller.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected health check error: %v", err)
+	}
+
+	// a token signed by an unknown key is only refetched after the minimum
+	// interval
+	key2 := idp.rotate(t, "key-2")
+	if err := authorized(key2); err == nil {
+		t.Fatal("expected token signed by a rotated key to be rejected within the minimum interval")
+	}
+	controller.remoteKeys.next = time.Time{}
+	if err := authorized(key2); err != nil {
+		t.Fatalf("unexpected error authorizing token signed by a rotated key: %v", err)
+	}
+	if err := authorized(key1); err == nil {
+		t.Fatal("expected token signed by a retired key to be rejected")
+	}
+
+	// failed fetches back off, keeping the keys, and fail the health check
+	idp.mu.Lock()
+	idp.fail = true
+	idp.fetches = 0
+	idp.mu.Unlock()
+	controller.remoteKeys.next = time.Time{}
+	controller.remoteKeys.fetched = time.Time{}
+	if err := controller.Check(context.Background()); err == nil {
+		t.Fatal("expected health check to fail")
+	}
+	if err := authorized(key2); err != nil {
+		t.Fatalf("unexpected error authorizing token while backing off: %v", err)
+	}
+	if err := authorized(key1); err == nil {
+		t.Fatal("expected token signed by a retired key to be rejected")
+	}
+	idp.mu.Lock()
+	fetches := idp.fetches
+	idp.mu.Unlock()
+	if fetches != 1 {
+		t.Fatalf("unexpected fetches while backing off: %d", fetches)
+	}
+	if backoff := controller.remoteKeys.backoff; backoff != minJWKSRefetchInterval {
+		t.Fatalf("unexpected backoff: %s", backoff)
+	}
+}
+
+func TestRemoteJWKSOptions(t *testing.T) {
+	options := map[string]interface{}{
+		"realm":   "https://auth.example.com/token/",
+		"issuer":  "test-issuer.example.com",
+		"service": "test-service.example.com",
+	}
+	for _, invalid := range []map[string]interface{}{
+		{"oidcissuer": "http://idp.example.com"},
+		{"oidcissuer": "https://idp.example.com", "jwks": "https://idp.example.com/keys"},
+		{"jwks": "https://idp.example.com/keys", "jwksrefreshinterval": "1s"},
+	} {
+		for k, v := range options {
+			invalid[k] = v
+		}
+		if _, err := newAccessController(invalid); err == nil {
+			t.Fatalf("expected options %v to be rejected", invalid)
+		}
+	}
+}
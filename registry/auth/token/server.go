@@ -0,0 +1,557 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
+	"github.com/distribution/distribution/v3/registry/auth/htpasswd"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	defaultTokenExpiration        = 5 * time.Minute
+	defaultRefreshTokenExpiration = 30 * 24 * time.Hour
+
+	// refreshAudiencePrefix prefixes the service in the audience of the
+	// refresh tokens, so that they are not accepted as access tokens.
+	refreshAudiencePrefix = "refresh:"
+)
+
+// tokenServerOptions is a convenience type for handling
+// options to the constructor of a tokenServer.
+type tokenServerOptions struct {
+	signingKey        string
+	htpasswd          string
+	acl               string
+	users             string
+	expiration        time.Duration
+	refreshExpiration time.Duration
+}
+
+// checkServerOptions gathers the options of the token server
+// from the given map, if any.
+func checkServerOptions(options map[string]interface{}) (*tokenServerOptions, error) {
+	serverVal, ok := options["server"]
+	if !ok || serverVal == nil {
+		return nil, nil
+	}
+
+	server := make(map[string]interface{})
+	switch v := serverVal.(type) {
+	case map[string]interface{}:
+		server = v
+	case map[interface{}]interface{}:
+		for key, val := range v {
+			server[fmt.Sprint(key)] = val
+		}
+	default:
+		return nil, errors.New("token auth server must be a map of options")
+	}
+
+	opts := tokenServerOptions{
+		expiration:        defaultTokenExpiration,
+		refreshExpiration: defaultRefreshTokenExpiration,
+	}
+	for key, dst := range map[string]*string{"signingkey": &opts.signingKey, "htpasswd": &opts.htpasswd, "acl": &opts.acl, "users": &opts.users} {
+		if val, ok := server[key]; ok {
+			s, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("token auth server requires a valid option string: %q", key)
+			}
+			*dst = s
+		}
+	}
+	for key, dst := range map[string]*time.Duration{"expiration": &opts.expiration, "refreshexpiration": &opts.refreshExpiration} {
+		val, ok := server[key]
+		if !ok {
+			continue
+		}
+		switch v := val.(type) {
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("token auth server option %q: %v", key, err)
+			}
+			*dst = d
+		case int:
+			*dst = time.Duration(v)
+		default:
+			return nil, fmt.Errorf("token auth server requires a valid option duration: %q", key)
+		}
+		if *dst <= 0 {
+			return nil, fmt.Errorf("token auth server option %q must be positive", key)
+		}
+	}
+
+	if opts.signingKey == "" {
+		return nil, errors.New("token auth server requires a signingkey")
+	}
+	if (opts.htpasswd == "") == (opts.users == "") {
+		return nil, errors.New("token auth server requires either an htpasswd or a users file")
+	}
+	if opts.acl != "" && opts.htpasswd == "" {
+		return nil, errors.New("token auth server acl requires an htpasswd file")
+	}
+	return &opts, nil
+}
+
+// tokenServer issues the tokens verified by the access controller, to the
+// users authenticated by an htpasswd or a users file. It serves the Docker
+// token and the OAuth2 password and refresh token flows.
+type tokenServer struct {
+	path              string
+	issuer            string
+	service           string
+	signer            jose.Signer
+	algorithm         jose.SignatureAlgorithm
+	key               jose.JSONWebKey // public key
+	expiration        time.Duration
+	refreshExpiration time.Duration
+	users             userStore
+}
+
+var _ auth.Handler = &tokenServer{}
+
+// userStore authenticates the users of the token server and grants them
+// access.
+type userStore interface {
+	// authenticate returns auth.ErrAuthenticationFailure if the credential
+	// is invalid.
+	authenticate(username, password string) error
+
+	// exists returns true if the user can still be issued tokens.
+	exists(username string) (bool, error)
+
+	// grant returns the access granted to the user among the access
+	// requested.
+	grant(username string, requested []*ResourceActions) ([]*ResourceActions, error)
+}
+
+// newTokenServer returns the token server of the access controller
+// options.
+func newTokenServer(config tokenAccessOptions) (*tokenServer, error) {
+	opts := config.server
+	signer, algorithm, key, err := loadSigningKey(opts.signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	path := config.autoRedirectPath
+	if !config.autoRedirect {
+		u, err := url.Parse(config.realm)
+		if err != nil {
+			return nil, fmt.Errorf("token auth server: invalid realm: %v", err)
+		}
+		path = u.Path
+	}
+	if path == "" {
+		path = defaultAutoRedirectPath
+	}
+
+	var users userStore
+	if opts.htpasswd != "" {
+		h := htpasswdUsers{Authenticator: htpasswd.NewAuthenticator(opts.htpasswd)}
+		if opts.acl != "" {
+			if h.acl, err = acl.New(opts.acl); err != nil {
+				return nil, err
+			}
+		}
+		users = h
+	} else {
+		users = newUsersFile(opts.users)
+	}
+
+	return &tokenServer{
+		path:              path,
+		issuer:            config.issuer,
+		service:           config.service,
+		signer:            signer,
+		algorithm:         algorithm,
+		key:               key,
+		expiration:        opts.expiration,
+		refreshExpiration: opts.refreshExpiration,
+		users:             users,
+	}, nil
+}
+
+// loadSigningKey reads the PEM encoded private key at path, and returns a
+// signer with the key, its algorithm and the public key.
+func loadSigningKey(path string) (jose.Signer, jose.SignatureAlgorithm, jose.JSONWebKey, error) {
+	var public jose.JSONWebKey
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", public, fmt.Errorf("unable to read token auth server signing key %q: %s", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", public, fmt.Errorf("token auth server signing key %q is not PEM encoded", path)
+	}
+
+	var key crypto.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", public, fmt.Errorf("unable to parse token auth server signing key %q: %s", path, err)
+	}
+
+	var algorithm jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		case elliptic.P521():
+			algorithm = jose.ES512
+		default:
+			return nil, "", public, fmt.Errorf("unsupported token auth server signing key curve: %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		algorithm = jose.EdDSA
+	default:
+		return nil, "", public, fmt.Errorf("unsupported token auth server signing key type: %T", key)
+	}
+
+	private := jose.JSONWebKey{Key: key, Algorithm: string(algorithm), Use: "sig"}
+	public = private.Public()
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, "", public, err
+	}
+	private.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	public.KeyID = private.KeyID
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: private}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, "", public, err
+	}
+	return signer, algorithm, public, nil
+}
+
+// Path returns the path of the token endpoint.
+func (ts *tokenServer) Path() string {
+	return ts.path
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	Token        string `json:"token,omitempty"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	IssuedAt     string `json:"issued_at"`
+}
+
+// ServeHTTP issues tokens with the Docker token flow on GET requests, and
+// with the OAuth2 password and refresh token flows on POST requests.
+func (ts *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ts.serveToken(w, r)
+	case http.MethodPost:
+		ts.serveOAuth2Token(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveToken implements the Docker token flow, authenticating the user with
+// basic authentication.
+func (ts *tokenServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if service := query.Get("service"); service != "" && service != ts.service {
+		ts.writeError(w, r, http.StatusBadRequest, "invalid_request", fmt.Errorf("unknown service %q", service))
+		return
+	}
+	requested, err := parseScopes(query["scope"])
+	if err != nil {
+		ts.writeError(w, r, http.StatusBadRequest, "invalid_scope", err)
+		return
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ts.service))
+		ts.writeError(w, r, http.StatusUnauthorized, "invalid_client", auth.ErrInvalidCredential)
+		return
+	}
+	if err := ts.users.authenticate(username, password); err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ts.service))
+		ts.writeError(w, r, http.StatusUnauthorized, "invalid_client", err)
+		return
+	}
+
+	ts.issue(w, r, username, requested, query.Get("offline_token") == "true", false)
+}
+
+// serveOAuth2Token implements the OAuth2 password and refresh token flows.
+func (ts *tokenServer) serveOAuth2Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		ts.writeError(w, r, http.StatusBadRequest, "invalid_request", err)
+		return
+	}
+	if service := r.PostForm.Get("service"); service != "" && service != ts.service {
+		ts.writeError(w, r, http.StatusBadRequest, "invalid_request", fmt.Errorf("unknown service %q", service))
+		return
+	}
+	requested, err := parseScopes(r.PostForm["scope"])
+	if err != nil {
+		ts.writeError(w, r, http.StatusBadRequest, "invalid_scope", err)
+		return
+	}
+
+	var username string
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case "password":
+		username = r.PostForm.Get("username")
+		if err := ts.users.authenticate(username, r.PostForm.Get("password")); err != nil {
+			ts.writeError(w, r, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+	case "refresh_token":
+		username, err = ts.verifyRefreshToken(r.PostForm.Get("refresh_token"))
+		if err != nil {
+			ts.writeError(w, r, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+	default:
+		ts.writeError(w, r, http.StatusBadRequest, "unsupported_grant_type", fmt.Errorf("unsupported grant type %q", grantType))
+		return
+	}
+
+	offline := r.PostForm.Get("access_type") == "offline" || r.PostForm.Get("grant_type") == "refresh_token"
+	ts.issue(w, r, username, requested, offline, true)
+}
+
+// issue writes the response with a token granting the user access, and a
+// refresh token if offline.
+func (ts *tokenServer) issue(w http.ResponseWriter, r *http.Request, username string, requested []*ResourceActions, offline, oauth2 bool) {
+	granted, err := ts.users.grant(username, requested)
+	if err != nil {
+		ts.writeError(w, r, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+
+	now := time.Now()
+	token, err := ts.sign(username, AudienceList{ts.service}, granted, now, ts.expiration)
+	if err != nil {
+		ts.writeError(w, r, http.StatusInternalServerError, "server_error", err)
+		return
+	}
+
+	response := tokenResponse{
+		AccessToken: token,
+		ExpiresIn:   int(ts.expiration / time.Second),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+	}
+	if oauth2 {
+		scopes := make([]string, 0, len(granted))
+		for _, ra := range granted {
+			scopes = append(scopes, formatScope(ra))
+		}
+		response.Scope = strings.Join(scopes, " ")
+	} else {
+		response.Token = token
+	}
+	if offline {
+		response.RefreshToken, err = ts.sign(username, AudienceList{refreshAudiencePrefix + ts.service}, nil, now, ts.refreshExpiration)
+		if err != nil {
+			ts.writeError(w, r, http.StatusInternalServerError, "server_error", err)
+			return
+		}
+	}
+
+	dcontext.GetLogger(r.Context()).Infof("issued token to %q for %v", username, response.Scope)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		dcontext.GetLogger(r.Context()).Errorf("error writing token response: %v", err)
+	}
+}
+
+// sign returns a token for the subject and audience granting access.
+func (ts *tokenServer) sign(subject string, audience AudienceList, access []*ResourceActions, now time.Time, expiration time.Duration) (string, error) {
+	var jti [16]byte
+	if _, err := rand.Read(jti[:]); err != nil {
+		return "", err
+	}
+	if access == nil {
+		access = []*ResourceActions{}
+	}
+
+	claims := ClaimSet{
+		Issuer:     ts.issuer,
+		Subject:    subject,
+		Audience:   audience,
+		Expiration: now.Add(expiration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      base64.RawURLEncoding.EncodeToString(jti[:]),
+		Access:     access,
+	}
+	return jwt.Signed(ts.signer).Claims(claims).Serialize()
+}
+
+// verifyRefreshToken returns the subject of a valid refresh token, if the
+// user still exists.
+func (ts *tokenServer) verifyRefreshToken(raw string) (string, error) {
+	token, err := jwt.ParseSigned(raw, []jose.SignatureAlgorithm{ts.algorithm})
+	if err != nil {
+		return "", ErrMalformedToken
+	}
+	var claims ClaimSet
+	if err := token.Claims(ts.key.Key, &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+	if claims.Issuer != ts.issuer || !contains(claims.Audience, refreshAudiencePrefix+ts.service) {
+		return "", ErrInvalidToken
+	}
+	if time.Now().After(time.Unix(claims.Expiration, 0)) {
+		return "", ErrInvalidToken
+	}
+
+	ok, err := ts.users.exists(claims.Subject)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", auth.ErrAuthenticationFailure
+	}
+	return claims.Subject, nil
+}
+
+func (ts *tokenServer) writeError(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	dcontext.GetLogger(r.Context()).Warnf("token request failed: %v", err)
+	if status == http.StatusInternalServerError {
+		err = errors.New("internal error")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// nolint:errcheck
+	json.NewEncoder(w).Encode(struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}{code, err.Error()})
+}
+
+// parseScopes parses the scopes of a token request, such as
+// "repository:samalba/my-app:pull,push", each parameter holding one or more
+// scopes separated by spaces.
+func parseScopes(params []string) ([]*ResourceActions, error) {
+	var scopes []*ResourceActions
+	for _, param := range params {
+		for _, scope := range strings.Fields(param) {
+			typ, rest, ok := strings.Cut(scope, ":")
+			i := strings.LastIndex(rest, ":")
+			if !ok || i < 0 {
+				return nil, fmt.Errorf("invalid scope %q", scope)
+			}
+			ra := &ResourceActions{Type: typ, Name: rest[:i]}
+			if t, class, ok := strings.Cut(typ, "("); ok && strings.HasSuffix(class, ")") {
+				ra.Type, ra.Class = t, strings.TrimSuffix(class, ")")
+			}
+			if ra.Type == "" || ra.Name == "" {
+				return nil, fmt.Errorf("invalid scope %q", scope)
+			}
+			for _, action := range strings.Split(rest[i+1:], ",") {
+				if action != "" {
+					ra.Actions = append(ra.Actions, action)
+				}
+			}
+			scopes = append(scopes, ra)
+		}
+	}
+	return scopes, nil
+}
+
+// formatScope returns the scope granting the resource actions.
+func formatScope(ra *ResourceActions) string {
+	typ := ra.Type
+	if ra.Class != "" {
+		typ += "(" + ra.Class + ")"
+	}
+	return typ + ":" + ra.Name + ":" + strings.Join(ra.Actions, ",")
+}
+
+// htpasswdUsers grants the users of an htpasswd file the access allowed by
+// the ACL, or all the access they request without one.
+type htpasswdUsers struct {
+	*htpasswd.Authenticator
+	acl *acl.ACL
+}
+
+func (h htpasswdUsers) authenticate(username, password string) error {
+	return h.AuthenticateUser(username, password)
+}
+
+func (h htpasswdUsers) exists(username string) (bool, error) {
+	return h.HasUser(username)
+}
+
+func (h htpasswdUsers) grant(username string, requested []*ResourceActions) ([]*ResourceActions, error) {
+	if h.acl == nil {
+		return requested, nil
+	}
+
+	granted := []*ResourceActions{}
+	for _, ra := range requested {
+		var actions []string
+		for _, action := range ra.Actions {
+			if slices.Contains(actions, action) {
+				continue
+			}
+			err := h.acl.Check(username, auth.Access{
+				Resource: auth.Resource{Type: ra.Type, Class: ra.Class, Name: ra.Name},
+				Action:   action,
+			})
+			switch {
+			case err == nil:
+				actions = append(actions, action)
+			case !errors.Is(err, auth.ErrAccessDenied):
+				return nil, err
+			}
+		}
+		if len(actions) > 0 {
+			granted = append(granted, &ResourceActions{
+				Type:    ra.Type,
+				Class:   ra.Class,
+				Name:    ra.Name,
+				Actions: actions,
+			})
+		}
+	}
+	return granted, nil
+}
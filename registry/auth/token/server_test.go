@@ -0,0 +1,284 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// writeSigningKey writes a signing key to dir and returns its path.
+func writeSigningKey(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath
+}
+
+func newTestTokenServer(t *testing.T) (auth.AccessController, auth.Handler) {
+	dir := t.TempDir()
+	keyPath := writeSigningKey(t, dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := `users:
+  alice:
+    password: ` + string(hash) + `
+    access:
+      - type: repository
+        name: alice/*
+        actions: ["*"]
+      - type: repository
+        name: library/*
+        actions: [pull]
+`
+	usersPath := filepath.Join(dir, "users.yml")
+	if err := os.WriteFile(usersPath, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "https://registry.example.com/auth/token",
+		"issuer":  "registry.example.com",
+		"service": "registry.example.com",
+		"server": map[interface{}]interface{}{
+			"signingkey": keyPath,
+			"users":      usersPath,
+			"expiration": "1m",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to create access controller: %v", err)
+	}
+	h, ok := ac.(auth.Handler)
+	if !ok {
+		t.Fatalf("access controller with a token server is not a handler: %T", ac)
+	}
+	if h.Path() != "/auth/token" {
+		t.Fatalf("unexpected token server path: %s", h.Path())
+	}
+	return ac, h
+}
+
+func TestTokenServer(t *testing.T) {
+	ac, h := newTestTokenServer(t)
+
+	// the docker token flow requires basic authentication
+	req := httptest.NewRequest(http.MethodGet, "/auth/token?service=registry.example.com&scope=repository:alice/app:pull,push", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("unexpected response to anonymous request: %d", resp.Code)
+	}
+
+	req.SetBasicAuth("alice", "wrong")
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected response to invalid credential: %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/token?service=registry.example.com&scope=repository:alice/app:pull,push&scope=repository:library/ubuntu:pull,push&offline_token=true", nil)
+	req.SetBasicAuth("alice", "secret")
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response to token request: %d %s", resp.Code, resp.Body)
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Token == "" || tr.Token != tr.AccessToken || tr.RefreshToken == "" || tr.ExpiresIn != 60 {
+		t.Fatalf("unexpected token response: %+v", tr)
+	}
+
+	authorized := func(token, name string, actions ...string) error {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		var access []auth.Access
+		for _, action := range actions {
+			access = append(access, auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action})
+		}
+		_, err := ac.Authorized(req, access...)
+		return err
+	}
+	if err := authorized(tr.Token, "alice/app", "pull", "push"); err != nil {
+		t.Fatalf("unexpected error authorizing issued token: %v", err)
+	}
+	if err := authorized(tr.Token, "library/ubuntu", "pull"); err != nil {
+		t.Fatalf("unexpected error authorizing issued token: %v", err)
+	}
+	if err := authorized(tr.Token, "library/ubuntu", "push"); err == nil {
+		t.Fatal("expected push to library/ubuntu to be denied")
+	}
+	if err := authorized(tr.RefreshToken, "alice/app", "pull"); err == nil {
+		t.Fatal("expected refresh token to be rejected as an access token")
+	}
+
+	// the oauth2 refresh token flow
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"service":       {"registry.example.com"},
+		"refresh_token": {tr.RefreshToken},
+		"scope":         {"repository:alice/app:pull"},
+	}
+	req = httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response to refresh request: %d %s", resp.Code, resp.Body)
+	}
+	tr = tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Scope != "repository:alice/app:pull" || tr.Token != "" {
+		t.Fatalf("unexpected refresh response: %+v", tr)
+	}
+	if err := authorized(tr.AccessToken, "alice/app", "pull"); err != nil {
+		t.Fatalf("unexpected error authorizing refreshed token: %v", err)
+	}
+
+	for _, form := range []url.Values{
+		{"grant_type": {"refresh_token"}, "refresh_token": {tr.AccessToken}},
+		{"grant_type": {"password"}, "username": {"alice"}, "password": {"wrong"}},
+		{"grant_type": {"client_credentials"}},
+	} {
+		req = httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp = httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("unexpected response to invalid request %v: %d", form, resp.Code)
+		}
+	}
+}
+
+func TestTokenServerHtpasswdACL(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := writeSigningKey(t, dir)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules := `rules:
+  - users: [alice]
+    repositories: ["alice/*"]
+    actions: ["*"]
+  - users: ["*"]
+    repositories: ["library/*"]
+    actions: [pull]
+`
+	aclPath := filepath.Join(dir, "acl.yml")
+	if err := os.WriteFile(aclPath, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	options := map[string]interface{}{
+		"realm":   "https://registry.example.com/auth/token",
+		"issuer":  "registry.example.com",
+		"service": "registry.example.com",
+		"server": map[interface{}]interface{}{
+			"signingkey": keyPath,
+			"users":      filepath.Join(dir, "users.yml"),
+			"acl":        aclPath,
+		},
+	}
+	if _, err := newAccessController(options); err == nil {
+		t.Fatal("expected an acl without an htpasswd file to be rejected")
+	}
+	options["server"] = map[interface{}]interface{}{
+		"signingkey": keyPath,
+		"htpasswd":   htpasswdPath,
+		"acl":        aclPath,
+	}
+	ac, err := newAccessController(options)
+	if err != nil {
+		t.Fatalf("unable to create access controller: %v", err)
+	}
+	h := ac.(auth.Handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/token?service=registry.example.com&scope=repository:alice/app:pull,push&scope=repository:library/ubuntu:pull,push&scope=registry:catalog:*", nil)
+	req.SetBasicAuth("alice", "secret")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected response to token request: %d %s", resp.Code, resp.Body)
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token only grants the access allowed by the acl
+	for _, tc := range []struct {
+		resource auth.Resource
+		action   string
+		allowed  bool
+	}{
+		{auth.Resource{Type: "repository", Name: "alice/app"}, "push", true},
+		{auth.Resource{Type: "repository", Name: "library/ubuntu"}, "pull", true},
+		{auth.Resource{Type: "repository", Name: "library/ubuntu"}, "push", false},
+		{auth.Resource{Type: "registry", Name: "catalog"}, "*", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.Header.Set("Authorization", "Bearer "+tr.Token)
+		_, err := ac.Authorized(req, auth.Access{Resource: tc.resource, Action: tc.action})
+		if (err == nil) != tc.allowed {
+			t.Fatalf("unexpected authorization of %s %s:%s: %v", tc.action, tc.resource.Type, tc.resource.Name, err)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes([]string{"repository:foo/bar:pull,push registry:catalog:*", "repository(plugin):localhost:5000/foo:pull"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"repository:foo/bar:pull,push", "registry:catalog:*", "repository(plugin):localhost:5000/foo:pull"}
+	if len(scopes) != len(expected) {
+		t.Fatalf("unexpected scopes: %v", scopes)
+	}
+	for i, scope := range scopes {
+		if formatScope(scope) != expected[i] {
+			t.Fatalf("unexpected scope %d: %s != %s", i, formatScope(scope), expected[i])
+		}
+	}
+
+	for _, invalid := range []string{"repository", "repository:foo", ":foo:pull"} {
+		if _, err := parseScopes([]string{invalid}); err == nil {
+			t.Fatalf("expected invalid scope %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package token
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// usersFile is the format of the users file of the token server, such as:
+//
+//	users:
+//	  alice:
+//	    password: $2y$05$...
+//	    access:
+//	      - type: repository
+//	        name: alice/*
+//	        actions: ["*"]
+//	      - type: repository
+//	        name: library/*
+//	        actions: [pull]
+type usersFile struct {
+	Users map[string]userEntry `yaml:"users"`
+}
+
+// userEntry is the bcrypt hashed password of a user, and the access granted
+// to the user.
+type userEntry struct {
+	Password string        `yaml:"password"`
+	Access   []accessEntry `yaml:"access"`
+}
+
+// accessEntry grants the actions on the resources of a type, whose names
+// match a pattern. The "*" action grants all the actions.
+type accessEntry struct {
+	Type    string   `yaml:"type"`
+	Name    string   `yaml:"name"`
+	Actions []string `yaml:"actions"`
+}
+
+// fileUsers authenticates users against a users file, parsed again when it
+// changes, and grants them the access of the file.
+type fileUsers struct {
+	path    string
+	modtime time.Time
+	mu      sync.Mutex
+	users   map[string]userEntry
+}
+
+func newUsersFile(path string) *fileUsers {
+	return &fileUsers{path: path}
+}
+
+func (f *fileUsers) authenticate(username, password string) error {
+	users, err := f.load()
+	if err != nil {
+		return err
+	}
+	user, ok := users[username]
+	if !ok {
+		// timing attack paranoia
+		// nolint:errcheck
+		bcrypt.CompareHashAndPassword([]byte{}, []byte(password))
+		return auth.ErrAuthenticationFailure
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return auth.ErrAuthenticationFailure
+	}
+	return nil
+}
+
+func (f *fileUsers) exists(username string) (bool, error) {
+	users, err := f.load()
+	if err != nil {
+		return false, err
+	}
+	_, ok := users[username]
+	return ok, nil
+}
+
+// grant returns the actions requested among the actions of the access
+// entries matching each resource.
+func (f *fileUsers) grant(username string, requested []*ResourceActions) ([]*ResourceActions, error) {
+	users, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	user := users[username]
+
+	granted := []*ResourceActions{}
+	for _, ra := range requested {
+		var actions []string
+		for _, action := range ra.Actions {
+			if !slices.Contains(actions, action) && user.allows(ra.Type, ra.Name, action) {
+				actions = append(actions, action)
+			}
+		}
+		if len(actions) > 0 {
+			granted = append(granted, &ResourceActions{
+				Type:    ra.Type,
+				Class:   ra.Class,
+				Name:    ra.Name,
+				Actions: actions,
+			})
+		}
+	}
+	return granted, nil
+}
+
+func (u userEntry) allows(typ, name, action string) bool {
+	for _, entry := range u.Access {
+		if entry.Type != typ {
+			continue
+		}
+		if ok, _ := path.Match(entry.Name, name); !ok {
+			continue
+		}
+		if slices.Contains(entry.Actions, "*") || slices.Contains(entry.Actions, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the users of the file, dynamically parsing the latest list.
+func (f *fileUsers) load() (map[string]userEntry, error) {
+	fstat, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := fstat.ModTime()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.users == nil || !f.modtime.Equal(lastModified) {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return nil, err
+		}
+
+		var file usersFile
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("unable to parse token auth users file %q: %v", f.path, err)
+		}
+		for name, entry := range file.Users {
+			for _, access := range entry.Access {
+				if _, err := path.Match(access.Name, ""); err != nil {
+					return nil, fmt.Errorf("invalid name pattern %q of user %q: %v", access.Name, name, err)
+				}
+			}
+		}
+		if file.Users == nil {
+			file.Users = make(map[string]userEntry)
+		}
+		f.modtime = lastModified
+		f.users = file.Users
+	}
+	return f.users, nil
+}
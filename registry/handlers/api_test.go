@@ -22,9 +22,11 @@ import (
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
@@ -2856,3 +2858,434 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestReferrersAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/referrers")
+	subject := createRepository(env, t, imageName.Name(), "latest")
+
+	emptyConfig := []byte("{}")
+	emptyConfigDigest := digest.FromBytes(emptyConfig)
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, emptyConfigDigest, uploadURLBase, bytes.NewReader(emptyConfig))
+
+	const artifactType = "application/vnd.example.signature"
+	referrer := &ocischema.Manifest{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    v1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config: v1.Descriptor{
+			MediaType: v1.MediaTypeEmptyJSON,
+			Digest:    emptyConfigDigest,
+			Size:      int64(len(emptyConfig)),
+		},
+		Layers: []v1.Descriptor{},
+		Subject: &v1.Descriptor{
+			MediaType: schema2.MediaTypeManifest,
+			Digest:    subject,
+		},
+		Annotations: map[string]string{"org.example.signed-by": "test"},
+	}
+
+	deserialized, err := ocischema.FromStruct(*referrer)
+	checkErr(t, err, "creating referrer manifest")
+	_, payload, err := deserialized.Payload()
+	checkErr(t, err, "getting referrer payload")
+	referrerDigest := digest.FromBytes(payload)
+
+	digestRef, _ := reference.WithDigest(imageName, referrerDigest)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	resp := putManifest(t, "putting referrer", manifestURL, v1.MediaTypeImageManifest, referrer)
+	defer resp.Body.Close()
+	checkResponse(t, "putting referrer", resp, http.StatusCreated)
+	checkHeaders(t, resp, http.Header{
+		"Docker-Content-Digest": []string{referrerDigest.String()},
+		"OCI-Subject":           []string{subject.String()},
+	})
+
+	subjectRef, _ := reference.WithDigest(imageName, subject)
+	for _, testcase := range []struct {
+		description  string
+		artifactType string
+		expected     []digest.Digest
+	}{
+		{
+			description: "unfiltered",
+			expected:    []digest.Digest{referrerDigest},
+		},
+		{
+			description:  "matching artifactType",
+			artifactType: artifactType,
+			expected:     []digest.Digest{referrerDigest},
+		},
+		{
+			description:  "other artifactType",
+			artifactType: "application/vnd.example.sbom",
+			expected:     []digest.Digest{},
+		},
+	} {
+		var values []url.Values
+		if testcase.artifactType != "" {
+			values = append(values, url.Values{"artifactType": []string{testcase.artifactType}})
+		}
+		referrersURL, err := env.builder.BuildReferrersURL(subjectRef, values...)
+		checkErr(t, err, "building referrers url")
+
+		resp, err := http.Get(referrersURL)
+		checkErr(t, err, "fetching referrers")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching referrers "+testcase.description, resp, http.StatusOK)
+		checkHeaders(t, resp, http.Header{
+			"Content-Type": []string{v1.MediaTypeImageIndex},
+		})
+		if testcase.artifactType != "" {
+			checkHeaders(t, resp, http.Header{
+				"OCI-Filters-Applied": []string{"artifactType"},
+			})
+		}
+
+		var index ocischema.ImageIndex
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			t.Fatalf("error decoding referrers response: %v", err)
+		}
+		if index.Manifests == nil {
+			t.Fatalf("%s: expected manifests to be present in the response", testcase.description)
+		}
+		if len(index.Manifests) != len(testcase.expected) {
+			t.Fatalf("%s: unexpected number of referrers: %d != %d", testcase.description, len(index.Manifests), len(testcase.expected))
+		}
+		for i, desc := range index.Manifests {
+			if desc.Digest != testcase.expected[i] {
+				t.Fatalf("%s: unexpected referrer digest: %s != %s", testcase.description, desc.Digest, testcase.expected[i])
+			}
+			if desc.ArtifactType != artifactType {
+				t.Fatalf("%s: unexpected artifactType: %q != %q", testcase.description, desc.ArtifactType, artifactType)
+			}
+			if desc.Annotations["org.example.signed-by"] != "test" {
+				t.Fatalf("%s: expected annotations of the referrer, got %v", testcase.description, desc.Annotations)
+			}
+		}
+	}
+
+	// an unknown subject has no referrers
+	unknownRef, _ := reference.WithDigest(imageName, digest.FromString("unknown"))
+	referrersURL, err := env.builder.BuildReferrersURL(unknownRef)
+	checkErr(t, err, "building referrers url")
+	resp, err = http.Get(referrersURL)
+	checkErr(t, err, "fetching referrers of unknown subject")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching referrers of unknown subject", resp, http.StatusOK)
+}
+
+func TestRepositoryDelete(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	nestedName, _ := reference.WithName("foo/bar/nested")
+	createRepository(env, t, imageName.Name(), "latest")
+	createRepository(env, t, nestedName.Name(), "latest")
+
+	repositoryURL, err := env.builder.BuildRepositoryURL(imageName)
+	checkErr(t, err, "building repository url")
+
+	resp, err := httpDelete(repositoryURL)
+	checkErr(t, err, "deleting repository")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting repository", resp, http.StatusAccepted)
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest of deleted repository")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest of deleted repository", resp, http.StatusNotFound)
+
+	nestedRef, _ := reference.WithTag(nestedName, "latest")
+	nestedURL, err := env.builder.BuildManifestURL(nestedRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err = http.Get(nestedURL)
+	checkErr(t, err, "fetching manifest of nested repository")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest of nested repository", resp, http.StatusOK)
+
+	resp, err = httpDelete(repositoryURL)
+	checkErr(t, err, "deleting repository again")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting repository again", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "deleting repository again", resp, errcode.ErrorCodeNameUnknown)
+}
+
+func TestRepositoryDeleteDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	createRepository(env, t, imageName.Name(), "latest")
+
+	repositoryURL, err := env.builder.BuildRepositoryURL(imageName)
+	checkErr(t, err, "building repository url")
+
+	resp, err := httpDelete(repositoryURL)
+	checkErr(t, err, "deleting repository")
+	defer resp.Body.Close()
+	checkResponse(t, "deleting repository with delete disabled", resp, http.StatusMethodNotAllowed)
+}
+
+func TestBlobUploadQuotaExceeded(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+			"quota": configuration.Parameters{"policies": []interface{}{
+				map[interface{}]interface{}{
+					"prefix":          "team-a/",
+					"repositorylimit": 10,
+				},
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("team-a/app")
+	layer := []byte("larger than the quota")
+	layerDigest := digest.FromBytes(layer)
+
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	resp, err := doPushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+	checkErr(t, err, "pushing layer")
+	defer resp.Body.Close()
+
+	checkResponse(t, "pushing layer over quota", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing layer over quota", resp, errcode.ErrorCodeQuotaExceeded)
+
+	otherName, _ := reference.WithName("team-b/app")
+	uploadURLBase, _ = startPushLayer(t, env, otherName)
+	pushLayer(t, env.builder, otherName, layerDigest, uploadURLBase, bytes.NewReader(layer))
+}
+
+// overrideAccessController grants every access, but the override of the
+// immutable tags policy only to the requests with an override header.
+type overrideAccessController struct{}
+
+func (overrideAccessController) Authorized(r *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	for _, a := range access {
+		if a.Action == immutableTagOverrideAction && r.Header.Get("X-Override") == "" {
+			return nil, auth.ErrAccessDenied
+		}
+	}
+	return &auth.Grant{User: auth.UserInfo{Name: "admin"}}, nil
+}
+
+func TestImmutableTags(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete":   configuration.Parameters{"enabled": true},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Policy: configuration.Policy{
+			Repository: configuration.Repository{
+				ImmutableTags: []configuration.ImmutableTagRule{
+					{Repositories: []string{"foo/*"}, Tags: []string{`^v[0-9]+\.[0-9]+\.[0-9]+$`}},
+				},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	releaseDigest := createRepository(env, t, imageName.Name(), "v1.0.0")
+	latestDigest := createRepository(env, t, imageName.Name(), "latest")
+
+	manifestURL := func(ref string) string {
+		var named reference.Named
+		if dgst, err := digest.Parse(ref); err == nil {
+			named, _ = reference.WithDigest(imageName, dgst)
+		} else {
+			named, _ = reference.WithTag(imageName, ref)
+		}
+		u, err := env.builder.BuildManifestURL(named)
+		checkErr(t, err, "building manifest url")
+		return u
+	}
+	payload := func(dgst digest.Digest) []byte {
+		req, err := http.NewRequest(http.MethodGet, manifestURL(dgst.String()), nil)
+		checkErr(t, err, "building manifest request")
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		defer resp.Body.Close()
+		p, err := io.ReadAll(resp.Body)
+		checkErr(t, err, "reading manifest")
+		return p
+	}
+	do := func(method, url string, body []byte, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		checkErr(t, err, "building request")
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// a protected tag cannot be pointed at another manifest, nor deleted
+	resp := do(http.MethodPut, manifestURL("v1.0.0"), payload(latestDigest), nil)
+	checkResponse(t, "overwriting immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "overwriting immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	resp = do(http.MethodDelete, manifestURL("v1.0.0"), nil, nil)
+	checkResponse(t, "deleting immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	resp = do(http.MethodDelete, manifestURL(releaseDigest.String()), nil, nil)
+	checkResponse(t, "deleting manifest of immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting manifest of immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	repositoryURL, err := env.builder.BuildRepositoryURL(imageName)
+	checkErr(t, err, "building repository url")
+	resp = do(http.MethodDelete, repositoryURL, nil, nil)
+	checkResponse(t, "deleting repository of immutable tag", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "deleting repository of immutable tag", resp, errcode.ErrorCodeTagImmutable)
+
+	// pushing the same manifest again and changing other tags is allowed
+	resp = do(http.MethodPut, manifestURL("v1.0.0"), payload(releaseDigest), nil)
+	checkResponse(t, "pushing immutable tag again", resp, http.StatusCreated)
+	resp = do(http.MethodPut, manifestURL("latest"), payload(releaseDigest), nil)
+	checkResponse(t, "overwriting mutable tag", resp, http.StatusCreated)
+	resp = do(http.MethodPut, manifestURL("v2.0.0"), payload(latestDigest), nil)
+	checkResponse(t, "pushing new immutable tag", resp, http.StatusCreated)
+
+	// the override action of the access controller bypasses the policy
+	env.app.accessController = overrideAccessController{}
+	resp = do(http.MethodPut, manifestURL("v1.0.0"), payload(latestDigest), nil)
+	checkResponse(t, "overwriting immutable tag without override", resp, http.StatusConflict)
+	resp = do(http.MethodPut, manifestURL("v1.0.0"), payload(latestDigest), http.Header{"X-Override": {"true"}})
+	checkResponse(t, "overwriting immutable tag with override", resp, http.StatusCreated)
+	resp = do(http.MethodDelete, manifestURL("v1.0.0"), nil, http.Header{"X-Override": {"true"}})
+	checkResponse(t, "deleting immutable tag with override", resp, http.StatusAccepted)
+	resp = do(http.MethodDelete, repositoryURL, nil, nil)
+	checkResponse(t, "deleting repository of immutable tag without override", resp, http.StatusConflict)
+	resp = do(http.MethodDelete, repositoryURL, nil, http.Header{"X-Override": {"true"}})
+	checkResponse(t, "deleting repository of immutable tag with override", resp, http.StatusAccepted)
+}
+
+func TestRateLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		RateLimit: configuration.RateLimit{
+			Manifests: configuration.RateLimits{
+				RemoteAddr: configuration.RateLimitBucket{Rate: 0.01, Burst: 2},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	createRepository(env, t, imageName.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Head(manifestURL)
+		checkErr(t, err, "fetching manifest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest within the burst", resp, http.StatusOK)
+	}
+
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest over the limit", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "fetching manifest over the limit", resp, errcode.ErrorCodeTooManyRequests)
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || retryAfter <= 0 || retryAfter > 100 {
+		t.Fatalf("unexpected Retry-After header: %q", resp.Header.Get("Retry-After"))
+	}
+
+	// the proxy headers of untrusted clients are ignored
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	checkErr(t, err, "building manifest request")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest with a forged address", resp, http.StatusTooManyRequests)
+
+	// the other kinds of requests have their own budget
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
+	defer resp.Body.Close()
+	checkResponse(t, "listing tags", resp, http.StatusOK)
+}
+
+func TestRateLimitUnauthenticated(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": {
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+		RateLimit: configuration.RateLimit{
+			Manifests: configuration.RateLimits{
+				RemoteAddr: configuration.RateLimitBucket{Rate: 0.01, Burst: 1},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching manifest within the burst", resp, http.StatusUnauthorized)
+
+	// the clients are limited before being authenticated
+	resp, err = http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest over the limit", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "fetching manifest over the limit", resp, errcode.ErrorCodeTooManyRequests)
+}
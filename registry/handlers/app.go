@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
 	"math"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -32,11 +34,14 @@ import (
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/distribution/distribution/v3/registry/replication"
 	"github.com/distribution/distribution/v3/registry/storage"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/distribution/reference"
@@ -79,8 +84,21 @@ type App struct {
 		source notifications.SourceRecord
 	}
 
+	// replicator replicates the repositories pushed to other registries
+	replicator *replication.Replicator
+
 	redis redis.UniversalClient
 
+	// rateLimiter keeps the state of the rateLimits of each kind of requests
+	rateLimiter      ratelimit.Limiter
+	rateLimits       map[string]rateLimits
+	rateLimitProxies []*net.IPNet
+
+	// immutableTags are the rules of the immutable tags policy
+	immutableTags immutableTagRules
+	// immutableTagLocks serializes the pushes to the immutable tags
+	immutableTagLocks tagLocks
+
 	// isCache is true if this registry is configured as a pull through cache
 	isCache bool
 
@@ -96,7 +114,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		Config:  config,
 		Context: ctx,
 		router:  v2.RouterWithPrefix(config.HTTP.Prefix),
-		isCache: config.Proxy.RemoteURL != "",
+		isCache: config.Proxy.Enabled(),
 	}
 
 	// Register the handler dispatchers.
@@ -109,6 +127,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
+	app.register(v2.RouteNameRepository, repositoryDispatcher)
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -129,6 +149,8 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	purgeConfig := uploadPurgeDefaultConfig()
+	var gcConfig map[interface{}]interface{}
+	var retentionPolicies []storage.RetentionPolicy
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[interface{}]interface{})
@@ -136,6 +158,18 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("uploadpurging config key must contain additional keys")
 			}
 		}
+		if v, ok := mc["gc"]; ok {
+			gcConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("gc config key must contain additional keys")
+			}
+		}
+		if v, ok := mc["retention"]; ok {
+			retentionPolicies, err = storage.ParseRetentionPolicies(v)
+			if err != nil {
+				panic(err)
+			}
+		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[interface{}]interface{})
 			if !ok {
@@ -164,6 +198,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 	app.configureEvents(config)
 	app.configureRedis(config)
+	app.configureRateLimit(config)
 	app.configureLogHook(config)
 
 	options := registrymiddleware.GetRegistryOptions()
@@ -180,6 +215,15 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	// configure online garbage collection
+	gcEnabled, gcInterval, gcOpts := parseGarbageCollectConfig(gcConfig)
+	if gcEnabled {
+		gcOpts.RetentionPolicies = retentionPolicies
+		gcOpts.ProtectTag = app.protectsTag
+		gcOpts.Tracker = storage.NewLinkTracker()
+		options = append(options, storage.TrackLinks(gcOpts.Tracker))
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
@@ -190,6 +234,17 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	// configure storage quotas
+	if q, ok := config.Storage["quota"]; ok {
+		if v, ok := q["policies"]; ok {
+			policies, err := storage.ParseQuotaPolicies(v)
+			if err != nil {
+				panic(fmt.Sprintf("unable to configure storage quotas: %v", err))
+			}
+			options = append(options, storage.Quotas(policies...))
+		}
+	}
+
 	// configure tag lookup concurrency limit
 	if p := config.Storage.TagParameters(); p != nil {
 		l, ok := p["concurrencylimit"]
@@ -330,11 +385,18 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	if gcEnabled {
+		startGarbageCollector(app, app.driver, app.registry, dcontext.GetLogger(app), gcInterval, gcOpts)
+	}
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
 	}
 
+	app.configureReplication(config)
+	app.configureImmutableTags(config)
+
 	authType := config.Auth.Type()
 
 	if authType != "" && !strings.EqualFold(authType, "none") {
@@ -344,16 +406,28 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 		app.accessController = accessController
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
+
+		// serve the requests of the access controller, such as token
+		// requests, outside of the access control
+		if h, ok := accessController.(auth.Handler); ok {
+			app.router.Path(h.Path()).Handler(h)
+			dcontext.GetLogger(app).Debugf("serving %q access controller at %s", authType, h.Path())
+		}
 	}
 
 	// configure as a pull through cache
-	if config.Proxy.RemoteURL != "" {
+	if config.Proxy.Enabled() {
 		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
 		if err != nil {
 			panic(err.Error())
 		}
 		app.isCache = true
-		dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		if len(config.Proxy.Remotes) == 0 {
+			dcontext.GetLogger(app).Info("Registry configured as a proxy cache to ", config.Proxy.RemoteURL)
+		}
+		for _, remote := range config.Proxy.Remotes {
+			dcontext.GetLogger(app).Infof("Registry configured as a proxy cache of %s to %s", remote.Namespace, remote.RemoteURL)
+		}
 	}
 	var ok bool
 	app.repoRemover, ok = app.registry.(distribution.RepositoryRemover)
@@ -402,6 +476,14 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 		go health.Poll(app, updater, storageDriverCheck, interval)
 	}
 
+	// check the access controller dependencies, such as the source of the
+	// token signing keys
+	if checker, ok := app.accessController.(health.Checker); ok {
+		updater := health.NewStatusUpdater()
+		healthRegistry.Register("auth_"+app.Config.Auth.Type(), updater)
+		go health.Poll(app, updater, checker, defaultCheckInterval)
+	}
+
 	for _, fileChecker := range app.Config.Health.FileCheckers {
 		interval := fileChecker.Interval
 		if interval == 0 {
@@ -449,6 +531,11 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 
 // Shutdown close the underlying registry
 func (app *App) Shutdown() error {
+	if app.replicator != nil {
+		if err := app.replicator.Close(); err != nil {
+			return err
+		}
+	}
 	if r, ok := app.registry.(proxy.Closer); ok {
 		return r.Close()
 	}
@@ -494,14 +581,52 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 		}
 
 		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
+		endpointConfig := notifications.EndpointConfig{
 			Timeout:           endpoint.Timeout,
 			Threshold:         endpoint.Threshold,
 			Backoff:           endpoint.Backoff,
 			Headers:           endpoint.Headers,
 			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
 			Ignore:            endpoint.Ignore,
-		})
+			MaxAttempts:       endpoint.Queue.MaxAttempts,
+			Format:            endpoint.Format,
+			Secret:            endpoint.Secret,
+		}
+		filter, err := notifications.NewEventFilter(endpoint.Filter)
+		if err != nil {
+			panic(fmt.Sprintf("endpoint %s filter: %v", endpoint.Name, err))
+		}
+		endpointConfig.Filter = filter
+		switch endpoint.Format {
+		case "", notifications.FormatEnvelope, notifications.FormatCloudEvents, notifications.FormatCloudEventsBatch:
+		default:
+			panic(fmt.Sprintf("endpoint %s has an unknown format %q", endpoint.Name, endpoint.Format))
+		}
+		switch {
+		case endpoint.Queue.Directory != "" && endpoint.Queue.Storage:
+			panic(fmt.Sprintf("endpoint %s queue must be either in a directory or in the storage", endpoint.Name))
+		case endpoint.Queue.Instance != "" && !endpoint.Queue.Storage:
+			panic(fmt.Sprintf("endpoint %s queue instance requires the queue to be in the storage", endpoint.Name))
+		case endpoint.Queue.Instance != "" && !storagedriver.PathRegexp.MatchString("/"+endpoint.Queue.Instance):
+			panic(fmt.Sprintf("endpoint %s queue instance %q must only contain alphanumeric characters, periods, underscores and hyphens", endpoint.Name, endpoint.Queue.Instance))
+		case endpoint.Queue.Directory != "":
+			driver, err := filesystem.FromParameters(map[string]interface{}{"rootdirectory": endpoint.Queue.Directory})
+			if err != nil {
+				panic(fmt.Sprintf("unable to configure endpoint %s queue: %v", endpoint.Name, err))
+			}
+			endpointConfig.QueueDriver = driver
+			dcontext.GetLogger(app).Infof("queueing events of endpoint %s in %s", endpoint.Name, endpoint.Queue.Directory)
+		case endpoint.Queue.Storage:
+			endpointConfig.QueueDriver = app.driver
+			// the queues of the instances sharing the storage are apart, as
+			// each instance replays all the entries of its queue
+			endpointConfig.QueuePath = path.Join("/notifications", endpoint.Name)
+			if endpoint.Queue.Instance != "" {
+				endpointConfig.QueuePath = path.Join("/notifications", endpoint.Name, "instances", endpoint.Queue.Instance)
+			}
+			dcontext.GetLogger(app).Infof("queueing events of endpoint %s in the storage, at %s", endpoint.Name, endpointConfig.QueuePath)
+		}
+		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, endpointConfig)
 
 		sinks = append(sinks, endpoint)
 	}
@@ -530,6 +655,29 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// configureReplication adds the replication to the targets configured to the
+// event sink.
+func (app *App) configureReplication(configuration *configuration.Configuration) {
+	if len(configuration.Notifications.Replication.Targets) == 0 {
+		return
+	}
+
+	replicator, err := replication.NewReplicator(app, app.registry, configuration.Notifications.Replication)
+	if err != nil {
+		panic(fmt.Sprintf("unable to configure replication: %v", err))
+	}
+	if err := app.events.sink.(*events.Broadcaster).Add(replicator); err != nil {
+		panic(fmt.Sprintf("unable to configure replication: %v", err))
+	}
+	app.replicator = replicator
+
+	for _, target := range configuration.Notifications.Replication.Targets {
+		if !target.Disabled {
+			dcontext.GetLogger(app).Infof("configured replication target %s (%s), repositories=%v", target.Name, target.URL, target.Repositories)
+		}
+	}
+}
+
 func (app *App) configureRedis(cfg *configuration.Configuration) {
 	if len(cfg.Redis.Options.Addrs) == 0 {
 		dcontext.GetLogger(app).Infof("redis not configured")
@@ -719,6 +867,10 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			}
 		}()
 
+		if app.clientRateLimited(context, w, r) {
+			return
+		}
+
 		if err := app.authorized(w, r, context); err != nil {
 			dcontext.GetLogger(context).Warnf("error authorizing context: %v", err)
 			return
@@ -727,6 +879,10 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, userNameKey))
 
+		if app.userRateLimited(context, w, r) {
+			return
+		}
+
 		// sync up context on the request.
 		r = r.WithContext(context)
 
@@ -889,6 +1045,13 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	grant, err := app.accessController.Authorized(r.WithContext(context.Context), accessRecords...)
 	if err != nil {
+		if errors.Is(err, auth.ErrAccessDenied) {
+			if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail(accessRecords)); err != nil {
+				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
+			return err
+		}
+
 		switch err := err.(type) {
 		case auth.Challenge:
 			// Add the appropriate WWW-Auth header
@@ -1129,3 +1292,93 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		}
 	}()
 }
+
+func badGarbageCollectConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse garbage collection configuration: %s", reason))
+}
+
+// parseGarbageCollectConfig parses the storage.maintenance.gc configuration,
+// returning whether online garbage collection is enabled, the interval
+// between collections and the options to collect with.
+func parseGarbageCollectConfig(config map[interface{}]interface{}) (bool, time.Duration, storage.GCOpts) {
+	opts := storage.GCOpts{
+		Quiet:       true,
+		GracePeriod: time.Hour,
+	}
+	interval := 24 * time.Hour
+
+	if config == nil {
+		return false, interval, opts
+	}
+
+	enabled, ok := config["enabled"]
+	if !ok {
+		return false, interval, opts
+	}
+	enabledBool, ok := enabled.(bool)
+	if !ok {
+		badGarbageCollectConfig("cannot parse enabled")
+	}
+	if !enabledBool {
+		return false, interval, opts
+	}
+
+	parseDuration := func(key string, d *time.Duration) {
+		v, ok := config[key]
+		if !ok {
+			return
+		}
+		s, ok := v.(string)
+		if !ok {
+			badGarbageCollectConfig(fmt.Sprintf("%s is not a string", key))
+		}
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			badGarbageCollectConfig(fmt.Sprintf("Cannot parse %s: %s", key, err.Error()))
+		}
+		*d = parsed
+	}
+	parseBool := func(key string, b *bool) {
+		v, ok := config[key]
+		if !ok {
+			return
+		}
+		parsed, ok := v.(bool)
+		if !ok {
+			badGarbageCollectConfig(fmt.Sprintf("cannot parse %s", key))
+		}
+		*b = parsed
+	}
+
+	parseDuration("interval", &interval)
+	parseDuration("graceperiod", &opts.GracePeriod)
+	parseBool("dryrun", &opts.DryRun)
+	parseBool("removeuntagged", &opts.RemoveUntagged)
+
+	if interval <= 0 {
+		badGarbageCollectConfig("interval must be positive")
+	}
+	if opts.GracePeriod < 0 {
+		badGarbageCollectConfig("graceperiod must not be negative")
+	}
+
+	return true, interval, opts
+}
+
+// startGarbageCollector schedules a goroutine which will periodically
+// garbage collect the registry while it continues to serve requests
+func startGarbageCollector(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, log dcontext.Logger, interval time.Duration, opts storage.GCOpts) {
+	go func() {
+		for {
+			log.Infof("Starting garbage collection in %s", interval)
+			time.Sleep(interval)
+
+			start := time.Now()
+			if err := storage.MarkAndSweep(ctx, storageDriver, registry, opts); err != nil {
+				log.Errorf("garbage collection failed: %v", err)
+				continue
+			}
+			log.Infof("garbage collection completed in %s", time.Since(start))
+		}
+	}()
+}
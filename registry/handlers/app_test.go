@@ -275,3 +275,39 @@ func TestAppendAccessRecords(t *testing.T) {
 		t.Fatal("Actual access record differs from expected")
 	}
 }
+
+func TestRateLimitClientIP(t *testing.T) {
+	app := &App{Context: dcontext.Background()}
+	app.configureRateLimit(&configuration.Configuration{
+		RateLimit: configuration.RateLimit{
+			TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+			Manifests: configuration.RateLimits{
+				RemoteAddr: configuration.RateLimitBucket{Rate: 1},
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{remoteAddr: "192.0.2.1:5000", expected: "192.0.2.1"},
+		{remoteAddr: "192.0.2.1:5000", header: http.Header{"X-Forwarded-For": {"198.51.100.1"}}, expected: "192.0.2.1"},
+		{remoteAddr: "192.0.2.1:5000", header: http.Header{"X-Real-Ip": {"198.51.100.1"}}, expected: "192.0.2.1"},
+		{remoteAddr: "10.0.0.1:5000", expected: "10.0.0.1"},
+		{remoteAddr: "10.0.0.1:5000", header: http.Header{"X-Real-Ip": {"198.51.100.1"}}, expected: "198.51.100.1"},
+		{remoteAddr: "10.0.0.1:5000", header: http.Header{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}}, expected: "198.51.100.1"},
+		{remoteAddr: "10.0.0.1:5000", header: http.Header{"X-Forwarded-For": {"198.51.100.1, 192.168.1.1", "10.0.0.2"}}, expected: "198.51.100.1"},
+		{remoteAddr: "10.0.0.1:5000", header: http.Header{"X-Forwarded-For": {"invalid, 10.0.0.2"}}, expected: "10.0.0.2"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for k, v := range tc.header {
+			req.Header[k] = v
+		}
+		if ip := app.rateLimitClientIP(req); ip != tc.expected {
+			t.Errorf("expected client %s of %s with %v, got %s", tc.expected, tc.remoteAddr, tc.header, ip)
+		}
+	}
+}
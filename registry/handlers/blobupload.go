@@ -86,6 +86,8 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 			}
 		} else if err == distribution.ErrUnsupported {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnsupported)
+		} else if eqe, ok := err.(distribution.ErrQuotaExceeded); ok {
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeQuotaExceeded.WithDetail(eqe))
 		} else {
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -222,6 +224,8 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		switch err := err.(type) {
 		case distribution.ErrBlobInvalidDigest:
 			buh.Errors = append(buh.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		case distribution.ErrQuotaExceeded:
+			buh.Errors = append(buh.Errors, errcode.ErrorCodeQuotaExceeded.WithDetail(err))
 		case errcode.Error:
 			buh.Errors = append(buh.Errors, err)
 		default:
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// immutableTagOverrideAction is the action on a repository allowing the
+// immutable tags policy to be overridden, such as to fix a tag pushed by
+// mistake.
+const immutableTagOverrideAction = "override"
+
+// immutableTagRule protects the tags matching its patterns in the
+// repositories matching its patterns.
+type immutableTagRule struct {
+	repositories []string
+	tags         []*regexp.Regexp
+}
+
+// immutableTagRules are the rules of an immutable tags policy.
+type immutableTagRules []immutableTagRule
+
+// configureImmutableTags compiles the immutable tags policy.
+func (app *App) configureImmutableTags(config *configuration.Configuration) {
+	rules, err := parseImmutableTags(config)
+	if err != nil {
+		panic(err.Error())
+	}
+	app.immutableTags = rules
+}
+
+// ImmutableTags returns a function returning true for the tags protected by
+// the immutable tags policy of the configuration, such as to keep them from
+// the retention policies of garbage collection.
+func ImmutableTags(config *configuration.Configuration) (func(repository, tag string) bool, error) {
+	rules, err := parseImmutableTags(config)
+	if err != nil {
+		return nil, err
+	}
+	return rules.protectsTag, nil
+}
+
+func parseImmutableTags(config *configuration.Configuration) (immutableTagRules, error) {
+	var rules immutableTagRules
+	for _, rule := range config.Policy.Repository.ImmutableTags {
+		var r immutableTagRule
+		for _, pattern := range rule.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid immutable tags repository pattern %q: %v", pattern, err)
+			}
+			r.repositories = append(r.repositories, pattern)
+		}
+		for _, pattern := range rule.Tags {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid immutable tags tag pattern %q: %v", pattern, err)
+			}
+			r.tags = append(r.tags, re)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// tagLocks serializes the pushes to the immutable tags, so that concurrent
+// pushes cannot all find a tag missing then create it. The pushes are only
+// serialized within a registry instance.
+type tagLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tagLock
+}
+
+type tagLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks the tag of the repository, returning the function unlocking it.
+func (l *tagLocks) lock(repository, tag string) func() {
+	key := repository + ":" + tag
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*tagLock)
+	}
+	tl, ok := l.locks[key]
+	if !ok {
+		tl = &tagLock{}
+		l.locks[key] = tl
+	}
+	tl.waiters++
+	l.mu.Unlock()
+
+	tl.Lock()
+	return func() {
+		tl.Unlock()
+
+		l.mu.Lock()
+		tl.waiters--
+		if tl.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// protectsRepository returns true if a rule protects tags in the repository.
+func (app *App) protectsRepository(repository string) bool {
+	for _, rule := range app.immutableTags {
+		if rule.matchRepository(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectsTag returns true if the tag of the repository is immutable.
+func (app *App) protectsTag(repository, tag string) bool {
+	return app.immutableTags.protectsTag(repository, tag)
+}
+
+// protectsTag returns true if a rule protects the tag of the repository.
+func (rules immutableTagRules) protectsTag(repository, tag string) bool {
+	for _, rule := range rules {
+		if rule.matchRepository(repository) && rule.matchTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule immutableTagRule) matchRepository(repository string) bool {
+	if len(rule.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range rule.repositories {
+		for name := repository; ; name = path.Dir(name) {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			if !strings.Contains(name, "/") {
+				break
+			}
+		}
+	}
+	return false
+}
+
+func (rule immutableTagRule) matchTag(tag string) bool {
+	if len(rule.tags) == 0 {
+		return true
+	}
+	for _, re := range rule.tags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImmutableTag returns a TAG_IMMUTABLE error unless the request is
+// authorized to override the immutable tags policy on the repository.
+func checkImmutableTag(ctx *Context, r *http.Request, tag string) error {
+	repository := ctx.Repository.Named().Name()
+	if ctx.App.accessController != nil {
+		override := auth.Access{
+			Resource: auth.Resource{Type: "repository", Name: repository},
+			Action:   immutableTagOverrideAction,
+		}
+		if _, err := ctx.App.accessController.Authorized(r.WithContext(ctx), override); err == nil {
+			dcontext.GetLogger(ctx).Warnf("overriding immutable tag %s:%s", repository, tag)
+			return nil
+		}
+	}
+	return errcode.ErrorCodeTagImmutable.WithDetail(map[string]string{"repository": repository, "tag": tag})
+}
@@ -288,6 +288,26 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if imh.Tag != "" && imh.App.protectsTag(imh.Repository.Named().Name(), imh.Tag) {
+		// the tag is locked until the manifest is put, as it may be missing
+		defer imh.App.immutableTagLocks.lock(imh.Repository.Named().Name(), imh.Tag)()
+
+		existing, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+		switch err.(type) {
+		case nil:
+			if existing.Digest != desc.Digest {
+				if err := checkImmutableTag(imh.Context, r, imh.Tag); err != nil {
+					imh.Errors = append(imh.Errors, err)
+					return
+				}
+			}
+		case distribution.ErrTagUnknown:
+		default:
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -318,6 +338,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					}
 				}
 			}
+		case distribution.ErrQuotaExceeded:
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeQuotaExceeded.WithDetail(err))
 		case errcode.Error:
 			imh.Errors = append(imh.Errors, err)
 		default:
@@ -354,6 +376,9 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	if subject := referrersSubject(manifest); subject != nil {
+		w.Header().Set("OCI-Subject", subject.Digest.String())
+	}
 	w.WriteHeader(http.StatusCreated)
 
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
@@ -439,6 +464,14 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 	if imh.Tag != "" {
 		dcontext.GetLogger(imh).Debug("DeleteImageTag")
 		tagService := imh.Repository.Tags(imh.Context)
+		if imh.App.protectsTag(imh.Repository.Named().Name(), imh.Tag) {
+			if _, err := tagService.Get(imh, imh.Tag); err == nil {
+				if err := checkImmutableTag(imh.Context, r, imh.Tag); err != nil {
+					imh.Errors = append(imh.Errors, err)
+					return
+				}
+			}
+		}
 		if err := tagService.Untag(imh.Context, imh.Tag); err != nil {
 			switch err.(type) {
 			case distribution.ErrTagUnknown, driver.PathNotFoundError:
@@ -458,6 +491,24 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// deleting a manifest removes its tags, which may be immutable
+	if repository := imh.Repository.Named().Name(); imh.App.protectsRepository(repository) {
+		tags, err := imh.Repository.Tags(imh).Lookup(imh, v1.Descriptor{Digest: imh.Digest})
+		if err != nil {
+			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		for _, tag := range tags {
+			if imh.App.protectsTag(repository, tag) {
+				if err := checkImmutableTag(imh.Context, r, tag); err != nil {
+					imh.Errors = append(imh.Errors, err)
+					return
+				}
+				break
+			}
+		}
+	}
+
 	err = manifests.Delete(imh, imh.Digest)
 	if err != nil {
 		switch err {
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+// Kinds of rate limited requests.
+const (
+	rateLimitManifests = "manifests"
+	rateLimitBlobs     = "blobs"
+	rateLimitUploads   = "uploads"
+)
+
+// rateLimits are the limits of a kind of requests, keyed by the client
+// address, the authenticated user and the repository. A zero rate disables a
+// limit.
+type rateLimits struct {
+	remoteAddr ratelimit.Limit
+	user       ratelimit.Limit
+	repository ratelimit.Limit
+}
+
+// configureRateLimit configures the rate limits of the requests, keeping the
+// state in memory or in redis.
+func (app *App) configureRateLimit(config *configuration.Configuration) {
+	limits := make(map[string]rateLimits)
+	for kind, cfg := range map[string]configuration.RateLimits{
+		rateLimitManifests: config.RateLimit.Manifests,
+		rateLimitBlobs:     config.RateLimit.Blobs,
+		rateLimitUploads:   config.RateLimit.Uploads,
+	} {
+		l := rateLimits{
+			remoteAddr: rateLimit(kind, "remoteaddr", cfg.RemoteAddr),
+			user:       rateLimit(kind, "user", cfg.User),
+			repository: rateLimit(kind, "repository", cfg.Repository),
+		}
+		if l != (rateLimits{}) {
+			limits[kind] = l
+		}
+	}
+	if len(limits) == 0 {
+		return
+	}
+
+	var proxies []*net.IPNet
+	for _, proxy := range config.RateLimit.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("invalid rate limit trusted proxy %q: %v", proxy, err))
+		}
+		proxies = append(proxies, network)
+	}
+
+	if config.RateLimit.Redis {
+		if app.redis == nil {
+			panic("redis configuration required to share the rate limits")
+		}
+		app.rateLimiter = ratelimit.NewRedisLimiter(app.redis, "ratelimit:")
+	} else {
+		app.rateLimiter = ratelimit.NewMemoryLimiter()
+	}
+	app.rateLimits = limits
+	app.rateLimitProxies = proxies
+	dcontext.GetLogger(app).Infof("configured rate limits, redis=%t", config.RateLimit.Redis)
+}
+
+// rateLimit returns the limit of the bucket, defaulting the burst to the
+// requests of a second.
+func rateLimit(kind, key string, bucket configuration.RateLimitBucket) ratelimit.Limit {
+	if bucket.Rate < 0 || bucket.Burst < 0 {
+		panic(fmt.Sprintf("invalid %s %s rate limit: rate and burst must not be negative", kind, key))
+	}
+	if bucket.Rate == 0 {
+		return ratelimit.Limit{}
+	}
+	burst := bucket.Burst
+	if burst == 0 {
+		burst = int(math.Ceil(bucket.Rate))
+	}
+	return ratelimit.Limit{Rate: bucket.Rate, Burst: burst}
+}
+
+// rateLimitClientIP returns the IP address of the client of r. The
+// X-Forwarded-For and X-Real-Ip headers are only honoured on the requests of
+// the trusted proxies, as any client may set them, skipping the addresses
+// appended by the trusted proxies.
+func (app *App) rateLimitClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !app.trustedProxy(ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		addrs := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if net.ParseIP(addr) == nil {
+				break
+			}
+			ip = addr
+			if !app.trustedProxy(addr) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := r.Header.Get("X-Real-Ip"); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// trustedProxy returns true if the address is a trusted proxy.
+func (app *App) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range app.rateLimitProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKind returns the kind of rate limited request of r, if any.
+func rateLimitKind(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+
+	switch route.GetName() {
+	case v2.RouteNameManifest:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return rateLimitManifests
+		case http.MethodPut:
+			return rateLimitUploads
+		}
+	case v2.RouteNameBlob:
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return rateLimitBlobs
+		}
+	case v2.RouteNameBlobUpload, v2.RouteNameBlobUploadChunk:
+		return rateLimitUploads
+	}
+	return ""
+}
+
+// rateLimitBucket is a bucket of the rate limits of a request.
+type rateLimitBucket struct {
+	key   string
+	value string
+	limit ratelimit.Limit
+}
+
+// clientRateLimited takes a token from the remoteaddr bucket of the request,
+// before it is authorized so that unauthenticated clients are limited too.
+func (app *App) clientRateLimited(ctx *Context, w http.ResponseWriter, r *http.Request) bool {
+	kind := rateLimitKind(r)
+	limits, ok := app.rateLimits[kind]
+	if !ok {
+		return false
+	}
+
+	return app.rateLimited(ctx, w, kind, rateLimitBucket{key: "remoteaddr", value: app.rateLimitClientIP(r), limit: limits.remoteAddr})
+}
+
+// userRateLimited takes a token from the user and repository buckets of the
+// authorized request.
+func (app *App) userRateLimited(ctx *Context, w http.ResponseWriter, r *http.Request) bool {
+	kind := rateLimitKind(r)
+	limits, ok := app.rateLimits[kind]
+	if !ok {
+		return false
+	}
+
+	return app.rateLimited(ctx, w, kind,
+		rateLimitBucket{key: "user", value: dcontext.GetStringValue(ctx, userNameKey), limit: limits.user},
+		rateLimitBucket{key: "repository", value: getName(ctx), limit: limits.repository},
+	)
+}
+
+// rateLimited takes a token from each bucket, returning true with a
+// TOOMANYREQUESTS error and the Retry-After header set if one is empty. The
+// requests are allowed if the state of the limits is unavailable.
+func (app *App) rateLimited(ctx *Context, w http.ResponseWriter, kind string, buckets ...rateLimitBucket) bool {
+	for _, bucket := range buckets {
+		if bucket.limit.Rate == 0 || bucket.value == "" {
+			continue
+		}
+
+		allowed, delay, err := app.rateLimiter.Allow(ctx, kind+":"+bucket.key+":"+bucket.value, bucket.limit)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("error checking %s rate limit: %v", bucket.key, err)
+			continue
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail(map[string]string{
+				"limit": kind + " per " + bucket.key,
+			}))
+			return true
+		}
+	}
+	return false
+}
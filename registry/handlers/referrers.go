@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrersDispatcher constructs the referrers handler api endpoint.
+func referrersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, errcode.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	referrersHandler := &referrersHandler{
+		Context: ctx,
+		Digest:  dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(referrersHandler.GetReferrers),
+	}
+}
+
+// referrersHandler handles requests for the referrers of a manifest.
+type referrersHandler struct {
+	*Context
+
+	// Digest is the digest of the subject manifest.
+	Digest digest.Digest
+}
+
+// GetReferrers returns an image index listing the manifests whose subject is
+// the requested digest, optionally filtered by artifact type.
+func (rh *referrersHandler) GetReferrers(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("GetReferrers")
+
+	manifests, err := rh.Repository.Manifests(rh)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	referrers, ok := manifests.(distribution.ReferrerEnumerator)
+	if !ok {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	artifactType := r.URL.Query().Get("artifactType")
+
+	descriptors := []v1.Descriptor{}
+	err = referrers.Referrers(rh, rh.Digest, func(desc v1.Descriptor) error {
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			return nil
+		}
+		descriptors = append(descriptors, desc)
+		return nil
+	})
+	if err != nil {
+		switch err {
+		case distribution.ErrUnsupported:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		default:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	index, err := ocischema.FromDescriptors(descriptors, nil)
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	mediaType, payload, err := index.Payload()
+	if err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Length", fmt.Sprint(len(payload)))
+
+	if _, err := w.Write(payload); err != nil {
+		dcontext.GetLogger(rh).Errorf("error writing referrers response: %v", err)
+	}
+}
+
+// referrersSubject returns the subject declared by the manifest, if any.
+func referrersSubject(manifest distribution.Manifest) *v1.Descriptor {
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return m.Subject
+	case *ocischema.DeserializedImageIndex:
+		return m.Subject
+	}
+	return nil
+}
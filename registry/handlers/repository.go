@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/gorilla/handlers"
+)
+
+// repositoryDispatcher constructs the repository handler api endpoint.
+func repositoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	repositoryHandler := &repositoryHandler{
+		Context: ctx,
+	}
+
+	rhandler := handlers.MethodHandler{}
+
+	if !ctx.readOnly {
+		rhandler[http.MethodDelete] = http.HandlerFunc(repositoryHandler.DeleteRepository)
+	}
+
+	return rhandler
+}
+
+// repositoryHandler handles requests for an entire repository.
+type repositoryHandler struct {
+	*Context
+}
+
+// DeleteRepository removes the manifests, tags and layer links of the
+// repository. Blobs are left for garbage collection.
+func (rh *repositoryHandler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("DeleteRepository")
+
+	if rh.App.isCache || rh.App.repoRemover == nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	// deleting a repository removes its tags, which may be immutable
+	if repository := rh.Repository.Named().Name(); rh.App.protectsRepository(repository) {
+		tags, err := rh.Repository.Tags(rh).All(rh)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+				rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+		}
+		for _, tag := range tags {
+			if rh.App.protectsTag(repository, tag) {
+				if err := checkImmutableTag(rh.Context, r, tag); err != nil {
+					rh.Errors = append(rh.Errors, err)
+					return
+				}
+				break
+			}
+		}
+	}
+
+	err := rh.RepositoryRemover.Remove(rh, rh.Repository.Named())
+	if err == distribution.ErrUnsupported {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeNameUnknown.WithDetail(err))
+		default:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/reference"
 )
 
@@ -24,14 +26,16 @@ type proxyBlobStore struct {
 	ttl            *time.Duration
 	repositoryName reference.Named
 	authChallenger authChallenger
+	upstream       string
+	spoolDir       string
 }
 
 var _ distribution.BlobStore = &proxyBlobStore{}
 
-// inflight tracks currently downloading blobs
-var inflight = make(map[digest.Digest]struct{})
+// inflight tracks the blobs being fetched from upstream, by digest
+var inflight = make(map[digest.Digest]*blobFetch)
 
-// mu protects inflight
+// mu protects inflight and the stores of the fetches
 var mu sync.Mutex
 
 func setResponseHeaders(h http.Header, length int64, mediaType string, digest digest.Digest) {
@@ -41,32 +45,6 @@ func setResponseHeaders(h http.Header, length int64, mediaType string, digest di
 	h.Set("Etag", digest.String())
 }
 
-func (pbs *proxyBlobStore) copyContent(ctx context.Context, dgst digest.Digest, writer io.Writer, h http.Header) (v1.Descriptor, error) {
-	desc, err := pbs.remoteStore.Stat(ctx, dgst)
-	if err != nil {
-		return v1.Descriptor{}, err
-	}
-
-	setResponseHeaders(h, desc.Size, desc.MediaType, dgst)
-
-	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
-	if err != nil {
-		return v1.Descriptor{}, err
-	}
-
-	defer remoteReader.Close()
-
-	_, err = io.CopyN(writer, remoteReader, desc.Size)
-	if err != nil {
-		return v1.Descriptor{}, err
-	}
-
-	proxyMetrics.BlobPull(uint64(desc.Size))
-	proxyMetrics.BlobPush(uint64(desc.Size), false)
-
-	return desc, nil
-}
-
 func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) (bool, error) {
 	localDesc, err := pbs.localStore.Stat(ctx, dgst)
 	if err != nil {
@@ -75,7 +53,7 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 		return false, nil
 	}
 
-	proxyMetrics.BlobPush(uint64(localDesc.Size), true)
+	proxyMetrics.BlobPush(pbs.upstream, uint64(localDesc.Size), true)
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
@@ -94,57 +72,163 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 		return err
 	}
 
-	mu.Lock()
-	_, ok := inflight[dgst]
-	if ok {
-		// If the blob has been serving in other requests.
-		// Will return the blob from the remote store directly.
-		// TODO Maybe we could reuse the these blobs are serving remotely and caching locally.
-		mu.Unlock()
-		_, err := pbs.copyContent(ctx, dgst, w, w.Header())
+	// the blob is looked up upstream for each request, as the fetch in
+	// progress may be for another repository
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil {
 		return err
 	}
-	inflight[dgst] = struct{}{}
-	mu.Unlock()
 
-	defer func() {
+	// the request starting the fetch of a whole blob is streamed to as it is
+	// fetched, the others are served from the spool of the fetch
+	var client *clientWriter
+	if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+		client = &clientWriter{w: w, desc: desc}
+	}
+	f, streamed := pbs.sharedFetch(ctx, desc, client)
+	defer f.release()
+
+	var n int64
+	if streamed {
+		n, err = f.stream(ctx, client)
+		if err == nil {
+			// the request starting the fetch returns once the blob is
+			// stored locally, so the requests following it are served
+			// from local storage
+			f.wait()
+		}
+	} else {
+		n, err = f.serve(ctx, w, r)
+	}
+	if err != nil {
+		return err
+	}
+
+	proxyMetrics.BlobPush(pbs.upstream, uint64(n), false)
+	return nil
+}
+
+// sharedFetch returns the upstream fetch of the blob, acquired for the
+// request. Concurrent requests for the blob share a single upstream fetch,
+// which stores the blob locally while serving them. If the request starts
+// the fetch, the blob is streamed to the client, if any, and streamed is
+// true.
+func (pbs *proxyBlobStore) sharedFetch(ctx context.Context, desc v1.Descriptor, client *clientWriter) (f *blobFetch, streamed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, ok := inflight[desc.Digest]
+	if !ok {
+		f = newBlobFetch()
+		f.client = client
+		inflight[desc.Digest] = f
+		go pbs.fetch(context.WithoutCancel(ctx), desc, f)
+	}
+	f.stores = append(f.stores, pbs)
+	f.acquire()
+	return f, !ok && client != nil
+}
+
+// fetch fetches the blob from upstream to the spool of f and to local
+// storage, linking it in the repositories of the requests served by f. The
+// fetch is not bound to any of the requests it serves.
+func (pbs *proxyBlobStore) fetch(ctx context.Context, desc v1.Descriptor, f *blobFetch) {
+	// done stops the requests from joining the fetch, returning the stores
+	// of the requests which joined it
+	done := func() []*proxyBlobStore {
 		mu.Lock()
-		delete(inflight, dgst)
-		mu.Unlock()
+		defer mu.Unlock()
+		if inflight[desc.Digest] == f {
+			delete(inflight, desc.Digest)
+		}
+		return f.stores
+	}
+	defer func() {
+		done()
+		f.finish()
 	}()
 
+	dgst := desc.Digest
+	spool, err := os.CreateTemp(pbs.spoolDir, "registry-proxy-blob-")
+	if err != nil {
+		f.complete(err)
+		return
+	}
+	f.start(desc, spool)
+
+	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
+	if err != nil {
+		f.complete(err)
+		return
+	}
+	defer remoteReader.Close()
+
 	bw, err := pbs.localStore.Create(ctx)
 	if err != nil {
-		return err
+		f.complete(err)
+		return
 	}
 
-	// Serving client and storing locally over same fetching request.
-	// This can prevent a redundant blob fetching.
-	multiWriter := io.MultiWriter(w, bw)
-	desc, err := pbs.copyContent(ctx, dgst, multiWriter, w.Header())
+	w := io.MultiWriter(f, bw)
+	if f.client != nil {
+		w = io.MultiWriter(f, bw, f.client)
+	}
+	_, err = io.CopyN(w, remoteReader, desc.Size)
 	if err != nil {
-		return err
+		// nolint:errcheck
+		bw.Cancel(ctx)
+		f.complete(err)
+		return
 	}
+	f.complete(nil)
+	proxyMetrics.BlobPull(pbs.upstream, uint64(desc.Size))
 
 	_, err = bw.Commit(ctx, desc)
 	if err != nil {
-		return err
+		dcontext.GetLogger(ctx).Errorf("Error committing blob %s: %s", dgst, err)
+		return
 	}
+	pbs.schedule(ctx, dgst)
 
-	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
+	// the blob is linked in the other repositories it was served for
+	linked := map[string]bool{pbs.repositoryName.Name(): true}
+	for _, store := range done() {
+		if linked[store.repositoryName.Name()] {
+			continue
+		}
+		linked[store.repositoryName.Name()] = true
+		store.mount(ctx, pbs.repositoryName, dgst)
+	}
+}
+
+// mount links the blob fetched for the repository from.
+func (pbs *proxyBlobStore) mount(ctx context.Context, from reference.Named, dgst digest.Digest) {
+	source, err := reference.WithDigest(from, dgst)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
-		return err
+		return
 	}
-
-	if pbs.scheduler != nil && pbs.ttl != nil {
-		if err := pbs.scheduler.AddBlob(blobRef, *pbs.ttl); err != nil {
-			dcontext.GetLogger(ctx).Errorf("Error adding blob: %s", err)
-			return err
-		}
+	_, err = pbs.localStore.Create(ctx, storage.WithMountFrom(source))
+	if _, ok := err.(distribution.ErrBlobMounted); !ok {
+		dcontext.GetLogger(ctx).Errorf("Error linking blob %s in %s: %v", dgst, pbs.repositoryName, err)
+		return
 	}
+	pbs.schedule(ctx, dgst)
+}
 
-	return nil
+// schedule schedules the expiry of the blob stored for the repository.
+func (pbs *proxyBlobStore) schedule(ctx context.Context, dgst digest.Digest) {
+	if pbs.scheduler == nil || pbs.ttl == nil {
+		return
+	}
+	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
+		return
+	}
+	if err := pbs.scheduler.AddBlob(blobRef, *pbs.ttl); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error adding blob: %s", err)
+	}
 }
 
 func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (v1.Descriptor, error) {
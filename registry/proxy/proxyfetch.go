@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blobFetch is an upstream fetch of a blob, shared by all the requests for
+// the blob while it is in progress. The content is spooled to a temporary
+// file as it is fetched, and each request is served from the spool.
+type blobFetch struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	desc    v1.Descriptor
+	started bool  // desc and spool are set
+	written int64 // bytes spooled
+	done    bool  // all the content is spooled
+	err     error
+
+	spool *os.File
+
+	// client is the client of the request starting the fetch, which the
+	// blob is streamed to
+	client *clientWriter
+	// completed is closed once all the content is spooled
+	completed chan struct{}
+	// stores are the blob stores of the requests served by the fetch
+	stores []*proxyBlobStore
+
+	// refs counts the requests being served from the spool, which is
+	// removed once the fetch is finished and refs drops to zero.
+	refs     int
+	finished bool
+}
+
+func newBlobFetch() *blobFetch {
+	f := &blobFetch{completed: make(chan struct{})}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// start records the descriptor of the blob and the spool it is fetched to.
+func (f *blobFetch) start(desc v1.Descriptor, spool *os.File) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.desc = desc
+	f.spool = spool
+	f.started = true
+	f.cond.Broadcast()
+}
+
+// Write spools p, making it available to the requests.
+func (f *blobFetch) Write(p []byte) (int, error) {
+	n, err := f.spool.Write(p)
+
+	f.mu.Lock()
+	f.written += int64(n)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	return n, err
+}
+
+// complete records the fetch as successful, or failed if err is set.
+func (f *blobFetch) complete(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.done = true
+	f.err = err
+	f.cond.Broadcast()
+	close(f.completed)
+}
+
+// acquire registers a request served by the fetch.
+func (f *blobFetch) acquire() {
+	f.mu.Lock()
+	f.refs++
+	f.mu.Unlock()
+}
+
+// release unregisters a request served by the fetch.
+func (f *blobFetch) release() {
+	f.mu.Lock()
+	f.refs--
+	remove := f.refs == 0 && f.finished
+	f.mu.Unlock()
+
+	if remove {
+		f.removeSpool()
+	}
+}
+
+// finish records that no further requests will be served by the fetch.
+func (f *blobFetch) finish() {
+	f.mu.Lock()
+	f.finished = true
+	remove := f.refs == 0
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	if remove {
+		f.removeSpool()
+	}
+}
+
+// wait waits for the fetch to finish, once the blob is stored locally or
+// failed to be.
+func (f *blobFetch) wait() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for !f.finished {
+		f.cond.Wait()
+	}
+}
+
+// stream waits for the fetch streaming the blob to the client c, returning
+// the number of bytes written.
+func (f *blobFetch) stream(ctx context.Context, c *clientWriter) (int64, error) {
+	select {
+	case <-f.completed:
+	case <-ctx.Done():
+		written, _ := c.detach(false)
+		return written, ctx.Err()
+	}
+
+	f.mu.Lock()
+	err := f.err
+	f.mu.Unlock()
+
+	written, cerr := c.detach(err == nil)
+	if err != nil {
+		return written, err
+	}
+	return written, cerr
+}
+
+func (f *blobFetch) removeSpool() {
+	if f.spool == nil {
+		return
+	}
+	f.spool.Close()
+	os.Remove(f.spool.Name())
+}
+
+// descriptor waits for the fetch to start and returns the descriptor of
+// the blob.
+func (f *blobFetch) descriptor() (v1.Descriptor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for !f.started && f.err == nil {
+		f.cond.Wait()
+	}
+	if f.err != nil {
+		return v1.Descriptor{}, f.err
+	}
+	return f.desc, nil
+}
+
+// available waits for the content at offset to be spooled and returns the
+// number of bytes spooled.
+func (f *blobFetch) available(offset int64) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for f.written <= offset && !f.done {
+		f.cond.Wait()
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.written <= offset {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return f.written, nil
+}
+
+// serve writes the blob, or the range of it requested, to w as it is
+// fetched. It returns the number of bytes written.
+func (f *blobFetch) serve(ctx context.Context, w http.ResponseWriter, r *http.Request) (int64, error) {
+	desc, err := f.descriptor()
+	if err != nil {
+		return 0, err
+	}
+
+	start, end := int64(0), desc.Size-1
+	status := http.StatusOK
+	// only single byte ranges are supported, other ranges are ignored
+	rangeHeader := r.Header.Get("Range")
+	if strings.HasPrefix(rangeHeader, "bytes=") && !strings.Contains(rangeHeader, ",") && desc.Size > 0 {
+		var ok bool
+		start, end, ok = parseRange(rangeHeader, desc.Size)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", desc.Size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return 0, nil
+		}
+		status = http.StatusPartialContent
+	}
+
+	setResponseHeaders(w.Header(), end-start+1, desc.MediaType, desc.Digest)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, desc.Size))
+	}
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return 0, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	var written int64
+	for offset := start; offset <= end; {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		spooled, err := f.available(offset)
+		if err != nil {
+			return written, err
+		}
+
+		n := int64(len(buf))
+		if spooled-offset < n {
+			n = spooled - offset
+		}
+		if end+1-offset < n {
+			n = end + 1 - offset
+		}
+		nr, err := f.spool.ReadAt(buf[:n], offset)
+		if nr > 0 {
+			nw, err := w.Write(buf[:nr])
+			written += int64(nw)
+			if err != nil {
+				return written, err
+			}
+		}
+		if err != nil && err != io.EOF {
+			return written, err
+		}
+		offset += int64(nr)
+	}
+	return written, nil
+}
+
+// clientWriter writes the blob fetched to the client of a request, along
+// with the response headers. The errors of the client do not fail the fetch:
+// the client is dropped.
+type clientWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter // nil once detached or failed
+	desc    v1.Descriptor
+	started bool // the response headers are written
+	written int64
+	err     error
+}
+
+func (c *clientWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.w == nil {
+		return len(p), nil
+	}
+	c.writeHeader()
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	if err != nil {
+		c.err = err
+		c.w = nil
+	}
+	return len(p), nil
+}
+
+func (c *clientWriter) writeHeader() {
+	if c.started {
+		return
+	}
+	setResponseHeaders(c.w.Header(), c.desc.Size, c.desc.MediaType, c.desc.Digest)
+	c.w.Header().Set("Accept-Ranges", "bytes")
+	c.w.WriteHeader(http.StatusOK)
+	c.started = true
+}
+
+// detach stops writing to the client, once all the content is written if
+// complete is true, returning the number of bytes written.
+func (c *clientWriter) detach(complete bool) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if complete && c.w != nil {
+		// the headers of an empty blob are written on completion
+		c.writeHeader()
+	}
+	c.w = nil
+	return c.written, c.err
+}
+
+// parseRange parses a Range header holding a single byte range, returning
+// the first and last byte requested. ok is false if the range is invalid or
+// cannot be satisfied.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// suffix range: the last bytes of the blob
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
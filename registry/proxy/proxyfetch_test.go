@@ -0,0 +1,409 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// slowUpstream is a remote registry serving a single blob and manifest,
+// which holds the content back until released and counts the fetches.
+type slowUpstream struct {
+	*httptest.Server
+
+	blob     []byte
+	manifest distribution.Manifest
+	release  chan struct{}
+
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+func newSlowUpstream(t *testing.T, name string) *slowUpstream {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config: v1.Descriptor{
+			MediaType: schema2.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(blob),
+			Size:      int64(len(blob)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &slowUpstream{
+		blob:     blob,
+		manifest: m,
+		release:  make(chan struct{}),
+		fetches:  make(map[string]int),
+	}
+	blobPath := "/v2/" + name + "/blobs/" + digest.FromBytes(blob).String()
+	manifestPath := "/v2/" + name + "/manifests/" + digest.FromBytes(payload).String()
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content []byte
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+			return
+		case blobPath:
+			content = blob
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case manifestPath:
+			content = payload
+			w.Header().Set("Content-Type", schema2.MediaTypeManifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(content).String())
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		u.mu.Lock()
+		u.fetches[r.URL.Path]++
+		u.mu.Unlock()
+		<-u.release
+		w.Write(content)
+	}))
+	return u
+}
+
+func (u *slowUpstream) fetched(p string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.fetches[p]
+}
+
+func newSlowUpstreamRepository(t *testing.T, name string) (*slowUpstream, distribution.Repository, distribution.Repository) {
+	ctx := context.Background()
+	nameRef, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := newSlowUpstream(t, name)
+	t.Cleanup(upstream.Close)
+	remoteRepo, err := client.NewRepository(nameRef, upstream.URL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New(), storage.DisableDigestResumption)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	localRepo, err := localRegistry.Repository(ctx, nameRef)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	return upstream, remoteRepo, localRepo
+}
+
+func TestProxyBlobFetchCoalesced(t *testing.T) {
+	ctx := context.Background()
+	name := "foo/coalesced"
+	upstream, remoteRepo, localRepo := newSlowUpstreamRepository(t, name)
+	nameRef := localRepo.Named()
+
+	pbs := &proxyBlobStore{
+		localStore:     localRepo.Blobs(ctx),
+		remoteStore:    remoteRepo.Blobs(ctx),
+		repositoryName: nameRef,
+		authChallenger: &mockChallenger{},
+	}
+	dgst := digest.FromBytes(upstream.blob)
+
+	numClients := 20
+	responses := make([]*httptest.ResponseRecorder, numClients)
+	var wg sync.WaitGroup
+	for i := 0; i < numClients; i++ {
+		responses[i] = httptest.NewRecorder()
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 1 {
+			r.Header.Set("Range", "bytes=100-199")
+		}
+
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			if err := pbs.ServeBlob(ctx, w, r, dgst); err != nil {
+				t.Error(err)
+			}
+		}(responses[i])
+	}
+
+	// hold the content back until all the requests are waiting for it
+	for {
+		mu.Lock()
+		f := inflight[dgst]
+		mu.Unlock()
+		if f != nil {
+			f.mu.Lock()
+			refs := f.refs
+			f.mu.Unlock()
+			if refs == numClients {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(upstream.release)
+	wg.Wait()
+
+	if n := upstream.fetched("/v2/" + name + "/blobs/" + dgst.String()); n != 1 {
+		t.Fatalf("expected a single upstream fetch, got %d", n)
+	}
+	for i, w := range responses {
+		resp := w.Result()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if resp.StatusCode != http.StatusOK || !bytes.Equal(body, upstream.blob) {
+				t.Fatalf("unexpected response %d: status %d, %d bytes", i, resp.StatusCode, len(body))
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, upstream.blob[100:200]) {
+			t.Fatalf("unexpected range response %d: status %d, %d bytes", i, resp.StatusCode, len(body))
+		}
+		if cr := resp.Header.Get("Content-Range"); cr != "bytes 100-199/"+strconv.Itoa(len(upstream.blob)) {
+			t.Fatalf("unexpected Content-Range %q", cr)
+		}
+	}
+
+	if _, err := localRepo.Blobs(ctx).Stat(ctx, dgst); err != nil {
+		t.Fatalf("blob not stored locally: %v", err)
+	}
+}
+
+func TestProxyManifestFetchCoalesced(t *testing.T) {
+	ctx := context.Background()
+	name := "foo/coalesced"
+	upstream, remoteRepo, localRepo := newSlowUpstreamRepository(t, name)
+
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pms := proxyManifestStore{
+		ctx:             ctx,
+		localManifests:  localManifests,
+		remoteManifests: remoteManifests,
+		repositoryName:  localRepo.Named(),
+		authChallenger:  &mockChallenger{},
+	}
+	_, payload, err := upstream.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(payload)
+
+	numClients := 10
+	var wg sync.WaitGroup
+	for i := 0; i < numClients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := pms.Get(ctx, dgst)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, p, _ := m.Payload(); !bytes.Equal(p, payload) {
+				t.Errorf("unexpected manifest payload")
+			}
+		}()
+	}
+
+	// hold the content back until the other requests joined the fetch
+	ref, err := reference.WithDigest(localRepo.Named(), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for waiters := 0; waiters < numClients-1; time.Sleep(time.Millisecond) {
+		manifestsMu.Lock()
+		if f, ok := inflightManifests[ref.String()]; ok {
+			waiters = f.waiters
+		}
+		manifestsMu.Unlock()
+	}
+	close(upstream.release)
+	wg.Wait()
+
+	if n := upstream.fetched("/v2/" + name + "/manifests/" + dgst.String()); n != 1 {
+		t.Fatalf("expected a single upstream fetch, got %d", n)
+	}
+	if _, err := localManifests.Get(ctx, dgst); err != nil {
+		t.Fatalf("manifest not stored locally: %v", err)
+	}
+}
+
+func TestProxyBlobFetchCoalescedAcrossRepositories(t *testing.T) {
+	ctx := context.Background()
+	name := "foo/coalesced"
+	upstream, remoteRepo, _ := newSlowUpstreamRepository(t, name)
+
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New(), storage.DisableDigestResumption)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	dgst := digest.FromBytes(upstream.blob)
+
+	// the repositories are served by the same upstream repository, so that
+	// they hold the same blob
+	var stores []*proxyBlobStore
+	for _, repoName := range []string{"foo/first", "foo/second"} {
+		nameRef, err := reference.WithName(repoName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		localRepo, err := localRegistry.Repository(ctx, nameRef)
+		if err != nil {
+			t.Fatalf("unexpected error getting repo: %v", err)
+		}
+		stores = append(stores, &proxyBlobStore{
+			localStore:     localRepo.Blobs(ctx),
+			remoteStore:    remoteRepo.Blobs(ctx),
+			repositoryName: nameRef,
+			authChallenger: &mockChallenger{},
+			spoolDir:       t.TempDir(),
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, pbs := range stores {
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(pbs *proxyBlobStore) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			if err := pbs.ServeBlob(ctx, w, r, dgst); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(w.Body.Bytes(), upstream.blob) {
+				t.Errorf("unexpected blob served for %s", pbs.repositoryName)
+			}
+		}(pbs)
+	}
+
+	for {
+		mu.Lock()
+		f := inflight[dgst]
+		mu.Unlock()
+		if f != nil {
+			f.mu.Lock()
+			refs := f.refs
+			f.mu.Unlock()
+			if refs == len(stores) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(upstream.release)
+	wg.Wait()
+
+	if n := upstream.fetched("/v2/" + name + "/blobs/" + dgst.String()); n != 1 {
+		t.Fatalf("expected a single upstream fetch, got %d", n)
+	}
+	// the blob is linked in each repository once the fetch is finished
+	for _, pbs := range stores {
+		for i := 0; ; i++ {
+			_, err := pbs.localStore.Stat(ctx, dgst)
+			if err == nil {
+				break
+			}
+			if i == 100 {
+				t.Fatalf("blob not linked in %s: %v", pbs.repositoryName, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestProxyManifestFetchWaiterCanceled(t *testing.T) {
+	ctx := context.Background()
+	name := "foo/coalesced"
+	upstream, remoteRepo, localRepo := newSlowUpstreamRepository(t, name)
+
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pms := proxyManifestStore{
+		ctx:             ctx,
+		localManifests:  localManifests,
+		remoteManifests: remoteManifests,
+		repositoryName:  localRepo.Named(),
+		authChallenger:  &mockChallenger{},
+	}
+	_, payload, err := upstream.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(payload)
+
+	// the fetch must complete before the test returns, so that later tests
+	// fetching the same manifest do not join it
+	fetched := make(chan struct{})
+	go func() {
+		defer close(fetched)
+		pms.Get(ctx, dgst) // nolint:errcheck
+	}()
+	defer func() {
+		close(upstream.release)
+		<-fetched
+	}()
+	for upstream.fetched("/v2/"+name+"/manifests/"+dgst.String()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a request waiting for the fetch in progress returns once canceled
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := pms.Get(waitCtx, dgst); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
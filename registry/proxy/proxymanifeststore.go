@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/opencontainers/go-digest"
@@ -20,6 +21,7 @@ type proxyManifestStore struct {
 	scheduler       *scheduler.TTLExpirationScheduler
 	ttl             *time.Duration
 	authChallenger  authChallenger
+	upstream        string
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
@@ -48,7 +50,7 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 
-		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
+		manifest, err = pms.fetch(ctx, dgst, options...)
 		if err != nil {
 			return nil, err
 		}
@@ -60,35 +62,93 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 		return nil, err
 	}
 
-	proxyMetrics.ManifestPush(uint64(len(payload)), !fromRemote)
-	if fromRemote {
-		proxyMetrics.ManifestPull(uint64(len(payload)))
+	proxyMetrics.ManifestPush(pms.upstream, uint64(len(payload)), !fromRemote)
 
-		_, err = pms.localManifests.Put(ctx, manifest)
-		if err != nil {
-			return nil, err
-		}
+	return manifest, err
+}
 
-		// Schedule the manifest blob for removal
-		repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
-		if err != nil {
-			dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
-			return nil, err
-		}
+// manifestFetch is an upstream fetch of a manifest, shared by all the
+// requests for the manifest while it is in progress.
+type manifestFetch struct {
+	done     chan struct{}
+	manifest distribution.Manifest
+	err      error
 
-		if pms.scheduler != nil && pms.ttl != nil {
-			if err := pms.scheduler.AddManifest(repoBlob, *pms.ttl); err != nil {
-				dcontext.GetLogger(ctx).Errorf("Error adding manifest: %s", err)
-				return nil, err
-			}
+	// waiters is the number of requests which joined the fetch, protected
+	// by manifestsMu
+	waiters int
+}
+
+// inflightManifests tracks the manifests being fetched from upstream, by
+// reference
+var inflightManifests = make(map[string]*manifestFetch)
+
+// manifestsMu protects inflightManifests
+var manifestsMu sync.Mutex
+
+// fetch fetches the manifest from upstream and stores it locally. Concurrent
+// fetches of the same manifest share a single upstream request.
+func (pms proxyManifestStore) fetch(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	repoBlob, err := reference.WithDigest(pms.repositoryName, dgst)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
+		return nil, err
+	}
+
+	manifestsMu.Lock()
+	f, ok := inflightManifests[repoBlob.String()]
+	if ok {
+		f.waiters++
+		manifestsMu.Unlock()
+		select {
+		case <-f.done:
+			return f.manifest, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
+	}
+	f = &manifestFetch{done: make(chan struct{})}
+	inflightManifests[repoBlob.String()] = f
+	manifestsMu.Unlock()
+
+	defer func() {
+		manifestsMu.Lock()
+		delete(inflightManifests, repoBlob.String())
+		manifestsMu.Unlock()
+		close(f.done)
+	}()
+
+	// the fetch is not bound to the request which started it
+	f.manifest, f.err = pms.fetchRemote(context.WithoutCancel(ctx), repoBlob, options...)
+	return f.manifest, f.err
+}
 
-		// Ensure the manifest blob is cleaned up
-		// pms.scheduler.AddBlob(blobRef, repositoryTTL)
+func (pms proxyManifestStore) fetchRemote(ctx context.Context, repoBlob reference.Canonical, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	manifest, err := pms.remoteManifests.Get(ctx, repoBlob.Digest(), options...)
+	if err != nil {
+		return nil, err
+	}
 
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
 	}
+	proxyMetrics.ManifestPull(pms.upstream, uint64(len(payload)))
 
-	return manifest, err
+	_, err = pms.localManifests.Put(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Schedule the manifest blob for removal
+	if pms.scheduler != nil && pms.ttl != nil {
+		if err := pms.scheduler.AddManifest(repoBlob, *pms.ttl); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error adding manifest: %s", err)
+			return nil, err
+		}
+	}
+
+	return manifest, nil
 }
 
 func (pms proxyManifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
@@ -10,15 +10,17 @@ import (
 
 var (
 	// requests is the number of total incoming proxy request received for blob/manifest
-	requests = prometheus.ProxyNamespace.NewLabeledCounter("requests", "The number of total incoming proxy request received", "type")
+	requests = prometheus.ProxyNamespace.NewLabeledCounter("requests", "The number of total incoming proxy request received", "type", "upstream")
 	// hits is the number of total proxy request hits for blob/manifest
-	hits = prometheus.ProxyNamespace.NewLabeledCounter("hits", "The number of total proxy request hits", "type")
+	hits = prometheus.ProxyNamespace.NewLabeledCounter("hits", "The number of total proxy request hits", "type", "upstream")
 	// hits is the number of total proxy request misses for blob/manifest
-	misses = prometheus.ProxyNamespace.NewLabeledCounter("misses", "The number of total proxy request misses", "type")
+	misses = prometheus.ProxyNamespace.NewLabeledCounter("misses", "The number of total proxy request misses", "type", "upstream")
 	// pulledBytes is the size of total bytes pulled from the upstream for blob/manifest
-	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type")
+	pulledBytes = prometheus.ProxyNamespace.NewLabeledCounter("pulled_bytes", "The size of total bytes pulled from the upstream", "type", "upstream")
 	// pushedBytes is the size of total bytes pushed to the client for blob/manifest
-	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type")
+	pushedBytes = prometheus.ProxyNamespace.NewLabeledCounter("pushed_bytes", "The size of total bytes pushed to the client", "type", "upstream")
+	// staleServed is the number of tags resolved from the cache while the upstream was unreachable
+	staleServed = prometheus.ProxyNamespace.NewLabeledCounter("stale_served", "The number of tags resolved from the cache while the upstream was unreachable", "type", "upstream")
 )
 
 // Metrics is used to hold metric counters
@@ -62,62 +64,76 @@ func init() {
 	}))
 
 	metrics.Register(prometheus.ProxyNamespace)
-	initPrometheusMetrics("blob")
-	initPrometheusMetrics("manifest")
 }
 
-func initPrometheusMetrics(value string) {
-	requests.WithValues(value).Inc(0)
-	hits.WithValues(value).Inc(0)
-	misses.WithValues(value).Inc(0)
-	pulledBytes.WithValues(value).Inc(0)
-	pushedBytes.WithValues(value).Inc(0)
+// initPrometheusMetrics initializes the metrics of the upstream
+func initPrometheusMetrics(upstream string) {
+	for _, value := range []string{"blob", "manifest"} {
+		requests.WithValues(value, upstream).Inc(0)
+		hits.WithValues(value, upstream).Inc(0)
+		misses.WithValues(value, upstream).Inc(0)
+		pulledBytes.WithValues(value, upstream).Inc(0)
+		pushedBytes.WithValues(value, upstream).Inc(0)
+	}
+	staleServed.WithValues("tag", upstream).Inc(0)
 }
 
 // BlobPull tracks metrics about blobs pulled into the cache
-func (pmc *proxyMetricsCollector) BlobPull(bytesPulled uint64) {
-	atomic.AddUint64(&pmc.blobMetrics.Misses, 1)
+func (pmc *proxyMetricsCollector) BlobPull(upstream string, bytesPulled uint64) {
 	atomic.AddUint64(&pmc.blobMetrics.BytesPulled, bytesPulled)
 
-	misses.WithValues("blob").Inc(1)
-	pulledBytes.WithValues("blob").Inc(float64(bytesPulled))
+	pulledBytes.WithValues("blob", upstream).Inc(float64(bytesPulled))
 }
 
-// BlobPush tracks metrics about blobs pushed to clients
-func (pmc *proxyMetricsCollector) BlobPush(bytesPushed uint64, isHit bool) {
+// BlobPush tracks metrics about blobs pushed to clients. Pushes which are not
+// hits are served from an upstream fetch, possibly shared with other pushes.
+func (pmc *proxyMetricsCollector) BlobPush(upstream string, bytesPushed uint64, isHit bool) {
 	atomic.AddUint64(&pmc.blobMetrics.Requests, 1)
 	atomic.AddUint64(&pmc.blobMetrics.BytesPushed, bytesPushed)
 
-	requests.WithValues("blob").Inc(1)
-	pushedBytes.WithValues("blob").Inc(float64(bytesPushed))
+	requests.WithValues("blob", upstream).Inc(1)
+	pushedBytes.WithValues("blob", upstream).Inc(float64(bytesPushed))
 
 	if isHit {
 		atomic.AddUint64(&pmc.blobMetrics.Hits, 1)
 
-		hits.WithValues("blob").Inc(1)
+		hits.WithValues("blob", upstream).Inc(1)
+	} else {
+		atomic.AddUint64(&pmc.blobMetrics.Misses, 1)
+
+		misses.WithValues("blob", upstream).Inc(1)
 	}
 }
 
+// TagStale tracks tags resolved from the cache while the upstream was unreachable
+func (pmc *proxyMetricsCollector) TagStale(upstream string) {
+	staleServed.WithValues("tag", upstream).Inc(1)
+}
+
 // ManifestPull tracks metrics related to Manifests pulled into the cache
-func (pmc *proxyMetricsCollector) ManifestPull(bytesPulled uint64) {
-	atomic.AddUint64(&pmc.manifestMetrics.Misses, 1)
+func (pmc *proxyMetricsCollector) ManifestPull(upstream string, bytesPulled uint64) {
 	atomic.AddUint64(&pmc.manifestMetrics.BytesPulled, bytesPulled)
 
-	misses.WithValues("manifest").Inc(1)
-	pulledBytes.WithValues("manifest").Inc(float64(bytesPulled))
+	pulledBytes.WithValues("manifest", upstream).Inc(float64(bytesPulled))
 }
 
-// ManifestPush tracks metrics about manifests pushed to clients
-func (pmc *proxyMetricsCollector) ManifestPush(bytesPushed uint64, isHit bool) {
+// ManifestPush tracks metrics about manifests pushed to clients. Pushes which
+// are not hits are served from an upstream fetch, possibly shared with other
+// pushes.
+func (pmc *proxyMetricsCollector) ManifestPush(upstream string, bytesPushed uint64, isHit bool) {
 	atomic.AddUint64(&pmc.manifestMetrics.Requests, 1)
 	atomic.AddUint64(&pmc.manifestMetrics.BytesPushed, bytesPushed)
 
-	requests.WithValues("manifest").Inc(1)
-	pushedBytes.WithValues("manifest").Inc(float64(bytesPushed))
+	requests.WithValues("manifest", upstream).Inc(1)
+	pushedBytes.WithValues("manifest", upstream).Inc(float64(bytesPushed))
 
 	if isHit {
 		atomic.AddUint64(&pmc.manifestMetrics.Hits, 1)
 
-		hits.WithValues("manifest").Inc(1)
+		hits.WithValues("manifest", upstream).Inc(1)
+	} else {
+		atomic.AddUint64(&pmc.manifestMetrics.Misses, 1)
+
+		misses.WithValues("manifest", upstream).Inc(1)
 	}
 }
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+)
+
+// prefetchConcurrency is the number of blobs of a manifest pulled in parallel
+const prefetchConcurrency = 4
+
+// Prefetcher pulls content into a pull through cache ahead of the clients
+type Prefetcher interface {
+	// Prefetch pulls the references into the cache: each manifest, the
+	// children of each index and each blob. A "*" in the repository name of
+	// a reference matches the repositories already cached.
+	Prefetch(ctx context.Context, references []string) error
+}
+
+var _ Prefetcher = &proxyingRegistry{}
+
+// Prefetch pulls the references into the cache, and registers the content
+// pulled, or already cached, for expiry. The references are all pulled even
+// if some fail, and the errors returned together.
+func (pr *proxyingRegistry) Prefetch(ctx context.Context, references []string) error {
+	var errs []error
+	for _, ref := range references {
+		name, tag, dgst, err := parsePrefetchReference(ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		names := []string{name}
+		if strings.Contains(name, "*") {
+			names, err = pr.matchRepositories(ctx, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", ref, err))
+				continue
+			}
+		}
+
+		for _, name := range names {
+			if err := pr.prefetch(ctx, name, tag, dgst); err != nil {
+				dcontext.GetLogger(ctx).Errorf("Error prefetching %s: %s", ref, err)
+				errs = append(errs, fmt.Errorf("%s: %v", ref, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// parsePrefetchReference splits a reference into its repository name, and
+// its tag or digest. The tag defaults to "latest".
+func parsePrefetchReference(ref string) (name, tag string, dgst digest.Digest, err error) {
+	name = ref
+	if n, d, ok := strings.Cut(ref, "@"); ok {
+		dgst, err = digest.Parse(d)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid prefetch reference %q: %v", ref, err)
+		}
+		return n, "", dgst, nil
+	}
+
+	tag = "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	if _, err := path.Match(name, ""); err != nil {
+		return "", "", "", fmt.Errorf("invalid prefetch reference %q: %v", ref, err)
+	}
+	return name, tag, "", nil
+}
+
+// matchRepositories returns the cached repositories matching pattern
+func (pr *proxyingRegistry) matchRepositories(ctx context.Context, pattern string) ([]string, error) {
+	enumerator, ok := pr.embedded.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to list the cached repositories")
+	}
+
+	var names []string
+	err := enumerator.Enumerate(ctx, func(name string) error {
+		if ok, _ := path.Match(pattern, name); ok {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// prefetch pulls the manifest tagged tag, or dgst if set, in the repository
+func (pr *proxyingRegistry) prefetch(ctx context.Context, name, tag string, dgst digest.Digest) error {
+	named, err := reference.WithName(name)
+	if err != nil {
+		return err
+	}
+	repo, err := pr.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	repository := repo.(*proxiedRepository)
+
+	if dgst == "" {
+		desc, err := repository.tags.Get(ctx, tag)
+		if err != nil {
+			return err
+		}
+		dgst = desc.Digest
+	}
+	return pr.prefetchManifest(ctx, repository, dgst)
+}
+
+// prefetchManifest pulls the manifest with its children or blobs
+func (pr *proxyingRegistry) prefetchManifest(ctx context.Context, repository *proxiedRepository, dgst digest.Digest) error {
+	manifest, err := repository.manifests.(*proxyManifestStore).prefetch(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("failed to prefetch manifest %s: %v", dgst, err)
+	}
+
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		for _, child := range manifest.References() {
+			if err := pr.prefetchManifest(ctx, repository, child.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(prefetchConcurrency)
+	for _, desc := range manifest.References() {
+		g.Go(func() error {
+			if err := repository.blobStore.(*proxyBlobStore).prefetch(gctx, desc.Digest); err != nil {
+				return fmt.Errorf("failed to prefetch blob %s: %v", desc.Digest, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// startPrefetch pulls the references in the background, then again at each
+// interval if set, until the registry is closed.
+func (pr *proxyingRegistry) startPrefetch(ctx context.Context, references []string, interval time.Duration) {
+	go func() {
+		for {
+			if err := pr.Prefetch(ctx, references); err != nil {
+				dcontext.GetLogger(ctx).Errorf("Error prefetching: %s", err)
+			}
+			if interval <= 0 {
+				return
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-pr.done:
+				return
+			}
+		}
+	}()
+}
+
+// prefetch pulls the manifest into the cache. If the manifest is already
+// cached, its expiry is pushed back.
+func (pms proxyManifestStore) prefetch(ctx context.Context, dgst digest.Digest) (distribution.Manifest, error) {
+	manifest, err := pms.localManifests.Get(ctx, dgst)
+	if err != nil {
+		return pms.Get(ctx, dgst)
+	}
+
+	if pms.scheduler != nil && pms.ttl != nil {
+		repoManifest, err := reference.WithDigest(pms.repositoryName, dgst)
+		if err != nil {
+			return nil, err
+		}
+		if err := pms.scheduler.AddManifest(repoManifest, *pms.ttl); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// prefetch pulls the blob into the cache. If the blob is already cached, its
+// expiry is pushed back.
+func (pbs *proxyBlobStore) prefetch(ctx context.Context, dgst digest.Digest) error {
+	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := pbs.localStore.Stat(ctx, dgst); err == nil {
+		if pbs.scheduler != nil && pbs.ttl != nil {
+			return pbs.scheduler.AddBlob(blobRef, *pbs.ttl)
+		}
+		return nil
+	}
+
+	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	f, _ := pbs.sharedFetch(ctx, desc, nil)
+	defer f.release()
+
+	f.wait()
+	if f.err != nil {
+		return f.err
+	}
+	if _, err := pbs.localStore.Stat(ctx, dgst); err != nil {
+		return fmt.Errorf("blob not stored: %v", err)
+	}
+	return nil
+}
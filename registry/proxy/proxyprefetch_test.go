@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// staticUpstream is a remote registry serving an index tagged v1, its child
+// manifest and the blobs of the child.
+type staticUpstream struct {
+	*httptest.Server
+
+	index    digest.Digest
+	manifest digest.Digest
+	blobs    []digest.Digest
+}
+
+func newStaticUpstream(t *testing.T, name string) *staticUpstream {
+	type content struct {
+		mediaType string
+		payload   []byte
+	}
+	paths := make(map[string]content)
+	u := &staticUpstream{}
+
+	for _, blob := range [][]byte{[]byte(`{"architecture": "amd64"}`), []byte("layer")} {
+		dgst := digest.FromBytes(blob)
+		paths["/v2/"+name+"/blobs/"+dgst.String()] = content{"application/octet-stream", blob}
+		u.blobs = append(u.blobs, dgst)
+	}
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: schema2.MediaTypeManifest,
+		Config:    v1.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: u.blobs[0], Size: 25},
+		Layers:    []v1.Descriptor{{MediaType: schema2.MediaTypeLayer, Digest: u.blobs[1], Size: 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.manifest = digest.FromBytes(payload)
+	paths["/v2/"+name+"/manifests/"+u.manifest.String()] = content{mediaType, payload}
+
+	index, err := ocischema.FromDescriptors([]v1.Descriptor{{MediaType: mediaType, Digest: u.manifest, Size: int64(len(payload))}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, err = index.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.index = digest.FromBytes(payload)
+	paths["/v2/"+name+"/manifests/"+u.index.String()] = content{mediaType, payload}
+	paths["/v2/"+name+"/manifests/v1"] = content{mediaType, payload}
+
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		c, ok := paths[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", c.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(c.payload)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(c.payload).String())
+		if r.Method != http.MethodHead {
+			w.Write(c.payload)
+		}
+	}))
+	return u
+}
+
+func TestProxyPrefetch(t *testing.T) {
+	ctx := context.Background()
+	name := "library/app"
+	upstream := newStaticUpstream(t, name)
+	defer upstream.Close()
+
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New(), storage.DisableDigestResumption)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	ttl := time.Hour
+	ns, err := NewRegistryPullThroughCache(ctx, localRegistry, inmemory.New(), configuration.Proxy{
+		Remotes: []configuration.ProxyRemote{{Namespace: "*", RemoteURL: upstream.URL, TTL: &ttl}},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+	defer ns.(Closer).Close()
+	pr := ns.(*proxyingRegistry)
+
+	if err := pr.Prefetch(ctx, []string{name + ":v1", "library/missing"}); err == nil {
+		t.Fatalf("expected prefetch of a missing reference to fail")
+	}
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo, err := localRegistry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCached := func() {
+		t.Helper()
+		manifests, err := localRepo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dgst := range []digest.Digest{upstream.index, upstream.manifest} {
+			if ok, err := manifests.Exists(ctx, dgst); err != nil || !ok {
+				t.Fatalf("manifest %s not prefetched: %v", dgst, err)
+			}
+		}
+		for _, dgst := range upstream.blobs {
+			if _, err := localRepo.Blobs(ctx).Stat(ctx, dgst); err != nil {
+				t.Fatalf("blob %s not prefetched: %v", dgst, err)
+			}
+		}
+	}
+	checkCached()
+
+	// a pattern matches the repositories already cached
+	names, err := pr.matchRepositories(ctx, "library/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != name {
+		t.Fatalf("unexpected repositories matched: %v", names)
+	}
+	if err := pr.Prefetch(ctx, []string{"library/*:v1", "other/*"}); err != nil {
+		t.Fatalf("unexpected error prefetching cached repositories: %v", err)
+	}
+	checkCached()
+
+	for _, ref := range []string{"library/[app", "library/app@sha256:invalid"} {
+		if err := pr.Prefetch(ctx, []string{ref}); err == nil {
+			t.Errorf("expected invalid reference %s to be rejected", ref)
+		}
+	}
+}
+
+func TestParsePrefetchReference(t *testing.T) {
+	dgst := digest.FromString("manifest")
+	for _, tc := range []struct {
+		ref  string
+		name string
+		tag  string
+		dgst digest.Digest
+	}{
+		{ref: "library/golang:1.23", name: "library/golang", tag: "1.23"},
+		{ref: "myorg/*:latest", name: "myorg/*", tag: "latest"},
+		{ref: "myorg/app", name: "myorg/app", tag: "latest"},
+		{ref: "localhost:5000/app", name: "localhost:5000/app", tag: "latest"},
+		{ref: "myorg/app@" + dgst.String(), name: "myorg/app", dgst: dgst},
+	} {
+		name, tag, d, err := parsePrefetchReference(tc.ref)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.ref, err)
+		}
+		if name != tc.name || tag != tc.tag || d != tc.dgst {
+			t.Errorf("%s: got %s %s %s", tc.ref, name, tag, d)
+		}
+	}
+}
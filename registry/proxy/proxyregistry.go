@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,26 +26,51 @@ import (
 
 var repositoryTTL = 24 * 7 * time.Hour
 
-// proxyingRegistry fetches content from a remote registry and caches it locally
+// proxyingRegistry fetches content from remote registries and caches it locally
 type proxyingRegistry struct {
-	embedded       distribution.Namespace // provides local registry functionality
-	scheduler      *scheduler.TTLExpirationScheduler
+	embedded  distribution.Namespace // provides local registry functionality
+	scheduler *scheduler.TTLExpirationScheduler
+	remotes   []*remote
+
+	// spoolDir is the directory the blobs being fetched are spooled to
+	spoolDir string
+
+	// done is closed when the registry is closed
+	done chan struct{}
+}
+
+// remote is a remote registry serving the repositories of a namespace
+type remote struct {
+	// namespace is the prefix of the repositories served by the remote. The
+	// empty namespace matches all repositories.
+	namespace string
+
+	// rewrite replaces the namespace in the names of the remote repositories
+	rewrite string
+
 	ttl            *time.Duration
+	maxStale       time.Duration
 	remoteURL      url.URL
 	authChallenger authChallenger
 	basicAuth      auth.CredentialStore
 }
 
-// NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+// newRemote configures the remote registry described by config
+func newRemote(config configuration.ProxyRemote) (*remote, error) {
+	var namespace string
+	switch {
+	case config.Namespace == "*":
+	case strings.HasSuffix(config.Namespace, "/*") && len(config.Namespace) > 2:
+		namespace = strings.TrimSuffix(config.Namespace, "*")
+	default:
+		return nil, fmt.Errorf("invalid proxy namespace %q: must be \"*\" or end with \"/*\"", config.Namespace)
+	}
+
 	remoteURL, err := url.Parse(config.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
 
-	v := storage.NewVacuum(ctx, driver)
-
-	var s *scheduler.TTLExpirationScheduler
 	var ttl *time.Duration
 	if config.TTL == nil {
 		// Default TTL is 7 days
@@ -55,7 +82,77 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		ttl = nil
 	}
 
-	if ttl != nil {
+	cs, b, err := func() (auth.CredentialStore, auth.CredentialStore, error) {
+		switch {
+		case config.Exec != nil:
+			cs, err := configureExecAuth(*config.Exec)
+			return cs, cs, err
+		default:
+			return configureAuth(config.Username, config.Password, config.RemoteURL)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	var maxStale time.Duration
+	if config.MaxStale != nil {
+		maxStale = *config.MaxStale
+	}
+
+	return &remote{
+		namespace: namespace,
+		rewrite:   config.Rewrite,
+		ttl:       ttl,
+		maxStale:  maxStale,
+		remoteURL: *remoteURL,
+		authChallenger: &remoteAuthChallenger{
+			remoteURL: *remoteURL,
+			cm:        challenge.NewSimpleManager(),
+			cs:        cs,
+		},
+		basicAuth: b,
+	}, nil
+}
+
+// NewRegistryPullThroughCache creates a registry acting as a pull through cache
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+	remoteConfigs := config.Remotes
+	if len(remoteConfigs) == 0 {
+		remoteConfigs = []configuration.ProxyRemote{{
+			Namespace: "*",
+			RemoteURL: config.RemoteURL,
+			Username:  config.Username,
+			Password:  config.Password,
+			Exec:      config.Exec,
+			TTL:       config.TTL,
+			MaxStale:  config.MaxStale,
+		}}
+	}
+
+	remotes := make([]*remote, 0, len(remoteConfigs))
+	namespaces := make(map[string]struct{})
+	expires := false
+	for _, remoteConfig := range remoteConfigs {
+		r, err := newRemote(remoteConfig)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := namespaces[r.namespace]; ok {
+			return nil, fmt.Errorf("duplicate proxy namespace %q", remoteConfig.Namespace)
+		}
+		namespaces[r.namespace] = struct{}{}
+		if r.ttl != nil {
+			expires = true
+		}
+		initPrometheusMetrics(r.remoteURL.Host)
+		remotes = append(remotes, r)
+	}
+
+	v := storage.NewVacuum(ctx, driver)
+
+	var s *scheduler.TTLExpirationScheduler
+	if expires {
 		s = scheduler.New(ctx, driver, "/scheduler-state.json")
 		s.OnBlobExpire(func(ref reference.Reference) error {
 			var r reference.Canonical
@@ -108,37 +205,29 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			return nil
 		})
 
-		err = s.Start()
+		err := s.Start()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cs, b, err := func() (auth.CredentialStore, auth.CredentialStore, error) {
-		switch {
-		case config.Exec != nil:
-			cs, err := configureExecAuth(*config.Exec)
-			return cs, cs, err
-		default:
-			return configureAuth(config.Username, config.Password, config.RemoteURL)
+	if config.SpoolDirectory != "" {
+		if err := os.MkdirAll(config.SpoolDirectory, 0o700); err != nil {
+			return nil, fmt.Errorf("unable to create proxy spool directory: %v", err)
 		}
-	}()
-	if err != nil {
-		return nil, err
 	}
 
-	return &proxyingRegistry{
+	pr := &proxyingRegistry{
 		embedded:  registry,
 		scheduler: s,
-		ttl:       ttl,
-		remoteURL: *remoteURL,
-		authChallenger: &remoteAuthChallenger{
-			remoteURL: *remoteURL,
-			cm:        challenge.NewSimpleManager(),
-			cs:        cs,
-		},
-		basicAuth: b,
-	}, nil
+		remotes:   remotes,
+		spoolDir:  config.SpoolDirectory,
+		done:      make(chan struct{}),
+	}
+	if len(config.Prefetch) > 0 {
+		pr.startPrefetch(ctx, config.Prefetch, config.PrefetchInterval)
+	}
+	return pr, nil
 }
 
 func (pr *proxyingRegistry) Scope() distribution.Scope {
@@ -149,15 +238,40 @@ func (pr *proxyingRegistry) Repositories(ctx context.Context, repos []string, la
 	return pr.embedded.Repositories(ctx, repos, last)
 }
 
+// remoteFor returns the remote with the longest namespace matching the
+// repository name, or nil if none does.
+func (pr *proxyingRegistry) remoteFor(name string) *remote {
+	var match *remote
+	for _, r := range pr.remotes {
+		if !strings.HasPrefix(name, r.namespace) {
+			continue
+		}
+		if match == nil || len(r.namespace) > len(match.namespace) {
+			match = r
+		}
+	}
+	return match
+}
+
 func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
-	c := pr.authChallenger
+	r := pr.remoteFor(name.Name())
+	if r == nil {
+		return nil, distribution.ErrRepositoryUnknown{Name: name.Name()}
+	}
+
+	remoteName, err := reference.WithName(r.rewrite + strings.TrimPrefix(name.Name(), r.namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	c := r.authChallenger
 
 	tkopts := auth.TokenHandlerOptions{
 		Transport:   http.DefaultTransport,
 		Credentials: c.credentialStore(),
 		Scopes: []auth.Scope{
 			auth.RepositoryScope{
-				Repository: name.Name(),
+				Repository: remoteName.Name(),
 				Actions:    []string{"pull"},
 			},
 		},
@@ -167,7 +281,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 	tr := transport.NewTransport(http.DefaultTransport,
 		auth.NewAuthorizer(c.challengeManager(),
 			auth.NewTokenHandlerWithOptions(tkopts),
-			auth.NewBasicHandler(pr.basicAuth)))
+			auth.NewBasicHandler(r.basicAuth)))
 
 	localRepo, err := pr.embedded.Repository(ctx, name)
 	if err != nil {
@@ -178,7 +292,7 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, pr.remoteURL.String(), tr)
+	remoteRepo, err := client.NewRepository(remoteName, r.remoteURL.String(), tr)
 	if err != nil {
 		return nil, err
 	}
@@ -193,9 +307,11 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			localStore:     localRepo.Blobs(ctx),
 			remoteStore:    remoteRepo.Blobs(ctx),
 			scheduler:      pr.scheduler,
-			ttl:            pr.ttl,
+			ttl:            r.ttl,
 			repositoryName: name,
-			authChallenger: pr.authChallenger,
+			authChallenger: r.authChallenger,
+			upstream:       r.remoteURL.Host,
+			spoolDir:       pr.spoolDir,
 		},
 		manifests: &proxyManifestStore{
 			repositoryName:  name,
@@ -203,14 +319,17 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 			remoteManifests: remoteManifests,
 			ctx:             ctx,
 			scheduler:       pr.scheduler,
-			ttl:             pr.ttl,
-			authChallenger:  pr.authChallenger,
+			ttl:             r.ttl,
+			authChallenger:  r.authChallenger,
+			upstream:        r.remoteURL.Host,
 		},
 		name: name,
 		tags: &proxyTagService{
 			localTags:      localRepo.Tags(ctx),
 			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
+			authChallenger: r.authChallenger,
+			maxStale:       r.maxStale,
+			upstream:       r.remoteURL.Host,
 		},
 	}, nil
 }
@@ -229,6 +348,10 @@ type Closer interface {
 }
 
 func (pr *proxyingRegistry) Close() error {
+	close(pr.done)
+	if pr.scheduler == nil {
+		return nil
+	}
 	return pr.scheduler.Stop()
 }
 
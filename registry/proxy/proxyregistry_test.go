@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+)
+
+// recordingUpstream is a remote registry recording the paths requested
+type recordingUpstream struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newRecordingUpstream() *recordingUpstream {
+	u := &recordingUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.paths = append(u.paths, r.URL.Path)
+		u.mu.Unlock()
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	return u
+}
+
+func (u *recordingUpstream) requested(p string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, requested := range u.paths {
+		if requested == p {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProxyRemotesRouting(t *testing.T) {
+	ctx := context.Background()
+	hub := newRecordingUpstream()
+	defer hub.Close()
+	ghcr := newRecordingUpstream()
+	defer ghcr.Close()
+	fallback := newRecordingUpstream()
+	defer fallback.Close()
+
+	d := inmemory.New()
+	localRegistry, err := storage.NewRegistry(ctx, d, storage.EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	noTTL := time.Duration(0)
+	ns, err := NewRegistryPullThroughCache(ctx, localRegistry, d, configuration.Proxy{
+		Remotes: []configuration.ProxyRemote{
+			{Namespace: "docker.io/*", Rewrite: "library/", RemoteURL: hub.URL, TTL: &noTTL},
+			{Namespace: "ghcr/*", RemoteURL: ghcr.URL, TTL: &noTTL},
+			{Namespace: "ghcr/special/*", RemoteURL: fallback.URL, TTL: &noTTL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating pull through cache: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		upstream *recordingUpstream
+		path     string
+	}{
+		{name: "docker.io/alpine", upstream: hub, path: "/v2/library/alpine/manifests/latest"},
+		{name: "ghcr/org/image", upstream: ghcr, path: "/v2/org/image/manifests/latest"},
+		{name: "ghcr/special/image", upstream: fallback, path: "/v2/image/manifests/latest"},
+	} {
+		named, err := reference.WithName(tc.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := ns.Repository(ctx, named)
+		if err != nil {
+			t.Fatalf("%s: error getting repository: %v", tc.name, err)
+		}
+		if _, err := repo.Tags(ctx).Get(ctx, "latest"); err == nil {
+			t.Fatalf("%s: expected tag lookup to fail", tc.name)
+		}
+		if !tc.upstream.requested(tc.path) {
+			t.Errorf("%s: expected upstream request for %s, got %v", tc.name, tc.path, tc.upstream.paths)
+		}
+
+		u, err := url.Parse(tc.upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upstream := repo.Blobs(ctx).(*proxyBlobStore).upstream; upstream != u.Host {
+			t.Errorf("%s: expected upstream label %s, got %s", tc.name, u.Host, upstream)
+		}
+	}
+
+	named, err := reference.WithName("quay/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Repository(ctx, named); err == nil {
+		t.Fatalf("expected repository outside the configured namespaces to be unknown")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestProxyRemotesInvalidNamespace(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	localRegistry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	for _, remotes := range [][]configuration.ProxyRemote{
+		{{Namespace: "docker.io", RemoteURL: "http://127.0.0.1"}},
+		{{Namespace: "/*", RemoteURL: "http://127.0.0.1"}},
+		{{Namespace: "*", RemoteURL: "http://127.0.0.1"}, {Namespace: "*", RemoteURL: "http://127.0.0.1"}},
+	} {
+		for i := range remotes {
+			remotes[i].Exec = &configuration.ExecConfig{Command: "true"}
+		}
+		if _, err := NewRegistryPullThroughCache(ctx, localRegistry, d, configuration.Proxy{Remotes: remotes}); err == nil {
+			t.Errorf("expected remotes %v to be rejected", remotes)
+		}
+	}
+}
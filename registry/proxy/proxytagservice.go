@@ -2,8 +2,14 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -12,17 +18,29 @@ type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+
+	// maxStale bounds the age of the local tags returned when the remote
+	// is unavailable. Zero means no bound.
+	maxStale time.Duration
+	upstream string
 }
 
 var _ distribution.TagService = proxyTagService{}
 
+// tagModTimer is implemented by the tag services reporting when a tag was
+// last updated.
+type tagModTimer interface {
+	ModTime(ctx context.Context, tag string) (time.Time, error)
+}
+
 // Get attempts to get the most recent digest for the tag by checking the remote
 // tag service first and then caching it locally.  If the remote is unavailable
-// the local association is returned
+// the local association is returned, unless it is older than maxStale.
 func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, error) {
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
-		desc, err := pt.remoteTags.Get(ctx, tag)
+		var desc v1.Descriptor
+		desc, err = pt.remoteTags.Get(ctx, tag)
 		if err == nil {
 			err := pt.localTags.Tag(ctx, tag, desc)
 			if err != nil {
@@ -31,14 +49,58 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (v1.Descriptor, e
 			return desc, nil
 		}
 	}
+	remoteErr := err
 
 	desc, err := pt.localTags.Get(ctx, tag)
 	if err != nil {
 		return v1.Descriptor{}, err
 	}
+
+	if mt, ok := pt.localTags.(tagModTimer); ok && pt.maxStale > 0 {
+		modTime, err := mt.ModTime(ctx, tag)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		if time.Since(modTime) > pt.maxStale {
+			return v1.Descriptor{}, remoteErr
+		}
+	}
+
+	if upstreamUnavailable(remoteErr) {
+		dcontext.GetLogger(ctx).Warnf("Resolved tag %s from the cache: %v", tag, remoteErr)
+		proxyMetrics.TagStale(pt.upstream)
+	}
 	return desc, nil
 }
 
+// upstreamUnavailable returns true if err reports that the remote could not
+// be reached or could not serve the request, as with a 5xx or a 429 response,
+// rather than an answer of the remote such as an unknown tag.
+func upstreamUnavailable(err error) bool {
+	var statusErr *client.UnexpectedHTTPStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	var responseErr *client.UnexpectedHTTPResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode == http.StatusTooManyRequests
+	}
+	var errs errcode.Errors
+	if errors.As(err, &errs) {
+		for _, err := range errs {
+			if upstreamUnavailable(err) {
+				return true
+			}
+		}
+		return false
+	}
+	var coder errcode.ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode() == errcode.ErrorCodeTooManyRequests
+	}
+	return !errors.As(err, &distribution.ErrTagUnknown{})
+}
+
 func (pt proxyTagService) Tag(ctx context.Context, tag string, desc v1.Descriptor) error {
 	return distribution.ErrUnsupported
 }
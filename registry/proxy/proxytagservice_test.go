@@ -2,12 +2,20 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"reflect"
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/internal/client/auth"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -176,3 +184,97 @@ func TestGet(t *testing.T) {
 		t.Fatalf("Expected 4 auth challenge calls, got %#v", proxyTags.authChallenger)
 	}
 }
+
+// modTimeTagStore is a tag store reporting when its tags were last updated
+type modTimeTagStore struct {
+	*mockTagStore
+	modTime time.Time
+}
+
+func (m *modTimeTagStore) ModTime(ctx context.Context, tag string) (time.Time, error) {
+	if _, err := m.Get(ctx, tag); err != nil {
+		return time.Time{}, err
+	}
+	return m.modTime, nil
+}
+
+// unreachableChallenger fails to reach the remote
+type unreachableChallenger struct{}
+
+func (unreachableChallenger) tryEstablishChallenges(context.Context) error {
+	return errors.New("remote unreachable")
+}
+
+func (unreachableChallenger) credentialStore() auth.CredentialStore {
+	return nil
+}
+
+func (unreachableChallenger) challengeManager() challenge.Manager {
+	return nil
+}
+
+func TestGetStale(t *testing.T) {
+	ctx := context.Background()
+	localDesc := v1.Descriptor{Size: 42}
+	local := &modTimeTagStore{
+		mockTagStore: &mockTagStore{mapping: map[string]v1.Descriptor{"latest": localDesc}},
+		modTime:      time.Now().Add(-time.Hour),
+	}
+	proxyTags := &proxyTagService{
+		localTags:      local,
+		remoteTags:     &mockTagStore{mapping: map[string]v1.Descriptor{}},
+		authChallenger: unreachableChallenger{},
+	}
+
+	// without a bound, the local tag is returned
+	d, err := proxyTags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, localDesc) {
+		t.Fatalf("unexpected descriptor: %v", d)
+	}
+
+	// within the bound, the local tag is returned
+	proxyTags.maxStale = 2 * time.Hour
+	d, err = proxyTags.Get(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, localDesc) {
+		t.Fatalf("unexpected descriptor: %v", d)
+	}
+
+	// past the bound, the remote error is returned
+	proxyTags.maxStale = time.Minute
+	if _, err := proxyTags.Get(ctx, "latest"); err == nil || err.Error() != "remote unreachable" {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+
+	// unknown tags are never resolved
+	if _, err := proxyTags.Get(ctx, "unknown"); !errors.As(err, &distribution.ErrTagUnknown{}) {
+		t.Fatalf("expected unknown tag error, got %v", err)
+	}
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		err         error
+		unavailable bool
+	}{
+		{errors.New("remote unreachable"), true},
+		{&client.UnexpectedHTTPStatusError{Status: "503 Service Unavailable"}, true},
+		{errcode.ErrorCodeTooManyRequests.WithMessage("slow down"), true},
+		{errcode.Errors{errcode.ErrorCodeTooManyRequests.WithMessage("slow down")}, true},
+		{&client.UnexpectedHTTPResponseError{ParseErr: client.ErrNoErrorsInBody, StatusCode: http.StatusTooManyRequests}, true},
+		{distribution.ErrTagUnknown{Tag: "latest"}, false},
+		{errcode.Errors{v2.ErrorCodeManifestUnknown.WithDetail("latest")}, false},
+		{errcode.ErrorCodeUnknown.WithMessage(""), false},
+		{errcode.ErrorCodeDenied.WithMessage("denied"), false},
+		{&client.UnexpectedHTTPResponseError{ParseErr: client.ErrNoErrorsInBody, StatusCode: http.StatusNotFound}, false},
+	} {
+		if got := upstreamUnavailable(tc.err); got != tc.unavailable {
+			t.Errorf("upstreamUnavailable(%v) = %v, expected %v", tc.err, got, tc.unavailable)
+		}
+	}
+}
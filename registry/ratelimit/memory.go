@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is the interval between the removals of the idle buckets of
+// the memory limiter.
+const sweepInterval = time.Minute
+
+// memoryLimiter keeps the buckets in memory, removing the buckets refilled
+// since their last use periodically.
+type memoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter returns a limiter keeping its state in memory.
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{
+		buckets:   make(map[string]*rate.Limiter),
+		lastSweep: time.Now(),
+	}
+}
+
+func (ml *memoryLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if now.Sub(ml.lastSweep) >= sweepInterval {
+		ml.sweep(now)
+	}
+
+	b, ok := ml.buckets[key]
+	if !ok || b.Limit() != rate.Limit(limit.Rate) || b.Burst() != limit.Burst {
+		b = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		ml.buckets[key] = b
+	}
+
+	r := b.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Duration(float64(time.Second) / limit.Rate), nil
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay, nil
+	}
+	return true, 0, nil
+}
+
+// sweep removes the buckets refilled since their last use, which a new bucket
+// replaces.
+func (ml *memoryLimiter) sweep(now time.Time) {
+	for key, b := range ml.buckets {
+		if b.TokensAt(now) >= float64(b.Burst()) {
+			delete(ml.buckets, key)
+		}
+	}
+	ml.lastSweep = now
+}
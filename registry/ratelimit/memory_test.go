@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewMemoryLimiter()
+	limit := Limit{Rate: 1, Burst: 3}
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := limiter.Allow(ctx, "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("expected event %d within the burst to be allowed", i)
+		}
+	}
+
+	allowed, delay, err := limiter.Allow(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("expected event over the burst to be denied")
+	}
+	if delay <= 0 || delay > time.Second {
+		t.Fatalf("unexpected delay: %s", delay)
+	}
+
+	// the buckets are independent
+	if allowed, _, _ := limiter.Allow(ctx, "other", limit); !allowed {
+		t.Fatal("expected event of another key to be allowed")
+	}
+
+	// refilled buckets are swept
+	ml := limiter.(*memoryLimiter)
+	ml.sweep(time.Now().Add(time.Duration(limit.Burst) * time.Second))
+	if len(ml.buckets) != 0 {
+		t.Fatalf("expected refilled buckets to be swept: %d left", len(ml.buckets))
+	}
+}
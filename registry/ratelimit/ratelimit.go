@@ -0,0 +1,23 @@
+// Package ratelimit provides token bucket rate limiters, keeping their state
+// in memory or sharing it between the registry replicas through redis.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit is the rate of a token bucket, refilled with Rate tokens per second
+// up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter limits the rate of the events of each key.
+type Limiter interface {
+	// Allow takes a token from the bucket of the key, returning true if the
+	// event is allowed. Otherwise, it returns the delay after which a token
+	// is available.
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
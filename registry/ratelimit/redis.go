@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript takes a token from the bucket stored in the hash at
+// KEYS[1], refilled with ARGV[1] tokens per second up to ARGV[2] tokens, at
+// the time ARGV[3] in microseconds. It returns 1 and 0 if a token was taken,
+// or 0 and the delay in microseconds after which a token is available.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000000)
+	ts = now
+end
+
+local allowed = 0
+local delay = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	delay = math.ceil((1 - tokens) * 1000000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, delay}
+`)
+
+// redisLimiter keeps the buckets in redis, so that the registry replicas
+// sharing the redis instance enforce a single budget.
+type redisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisLimiter returns a limiter keeping its state in redis, under keys
+// with the prefix.
+func NewRedisLimiter(client redis.UniversalClient, prefix string) Limiter {
+	return &redisLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (rl *redisLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	// the time of the registry is used, as the script cannot read the
+	// time of redis before writing
+	now := time.Now().UnixMicro()
+	res, err := tokenBucketScript.Run(ctx, rl.client, []string{rl.prefix + key}, limit.Rate, limit.Burst, now).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 || res[0] == 1 {
+		return true, 0, nil
+	}
+	return false, time.Duration(res[1]) * time.Microsecond, nil
+}
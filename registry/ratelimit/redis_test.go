@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var redisAddr string
+
+func init() {
+	flag.StringVar(&redisAddr, "test.registry.ratelimit.redis.addr", "", "configure the address of a test instance of redis")
+}
+
+// TestRedisLimiter exercises a live redis instance using the limiter
+// implementation.
+func TestRedisLimiter(t *testing.T) {
+	if redisAddr == "" {
+		// fallback to an environment variable
+		redisAddr = os.Getenv("TEST_REGISTRY_RATELIMIT_REDIS_ADDR")
+	}
+
+	if redisAddr == "" {
+		// skip if still not set
+		t.Skip("please set -test.registry.ratelimit.redis.addr to test the rate limiter against redis")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisAddr})
+	ctx := context.Background()
+	if err := client.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	limit := Limit{Rate: 1, Burst: 2}
+	// two limiters share the buckets, as two replicas would
+	limiters := []Limiter{NewRedisLimiter(client, "ratelimit:"), NewRedisLimiter(client, "ratelimit:")}
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := limiters[i%2].Allow(ctx, "client", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !allowed {
+			t.Fatalf("expected event %d within the burst to be allowed", i)
+		}
+	}
+	allowed, delay, err := limiters[0].Allow(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed || delay <= 0 {
+		t.Fatalf("expected event over the burst to be denied: %v, %s", allowed, delay)
+	}
+}
@@ -0,0 +1,19 @@
+package replication
+
+import (
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// replicationsCounter counts the replications succeeded, failed and dropped
+	replicationsCounter = prometheus.ReplicationNamespace.NewLabeledCounter("replications", "The number of replications", "type", "target")
+	// pendingGauge measures the replications pending in queue
+	pendingGauge = prometheus.ReplicationNamespace.NewLabeledGauge("pending", "The gauge of replications pending in queue", metrics.Total, "target")
+	// lagTimer measures the time between an event and its replication
+	lagTimer = prometheus.ReplicationNamespace.NewLabeledTimer("lag", "The number of seconds between an event and its replication", "target")
+)
+
+func init() {
+	metrics.Register(prometheus.ReplicationNamespace)
+}
@@ -0,0 +1,190 @@
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// job is a replication of a manifest, a tag or a deletion to a target
+type job struct {
+	// Seq orders the jobs of a queue
+	Seq uint64 `json:"seq"`
+
+	// Action is the action of the event replicated, push or delete
+	Action string `json:"action"`
+
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest,omitempty"`
+	Tag        string        `json:"tag,omitempty"`
+
+	// Timestamp is the time of the event replicated, from which the
+	// replication lag is measured
+	Timestamp time.Time `json:"timestamp"`
+
+	// Attempts counts the failed attempts to replicate the job
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// queue is the ordered queue of the jobs pending for a target. If dir is set,
+// each job is stored in a file of dir until it is removed, so that the jobs
+// pending survive restarts.
+type queue struct {
+	dir string
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []*job
+	seq    uint64
+	closed bool
+}
+
+// openQueue loads the jobs stored in dir, creating it if needed. An empty dir
+// keeps the jobs in memory only.
+func openQueue(dir string) (*queue, error) {
+	q := &queue{dir: dir}
+	q.cond = sync.NewCond(&q.mu)
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create replication queue: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replication queue: %v", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if _, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64); err != nil {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read replication queue: %v", err)
+		}
+		var j job
+		if err := json.Unmarshal(content, &j); err != nil {
+			return nil, fmt.Errorf("invalid replication job %s: %v", name, err)
+		}
+		q.jobs = append(q.jobs, &j)
+		if j.Seq > q.seq {
+			q.seq = j.Seq
+		}
+	}
+	sort.Slice(q.jobs, func(i, k int) bool { return q.jobs[i].Seq < q.jobs[k].Seq })
+	return q, nil
+}
+
+func (q *queue) path(j *job) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.json", j.Seq))
+}
+
+// store writes the job to its file, replacing it atomically
+func (q *queue) store(j *job) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := q.path(j) + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(j))
+}
+
+// push appends the job to the queue
+func (q *queue) push(j *job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return fmt.Errorf("replication queue closed")
+	}
+
+	q.seq++
+	j.Seq = q.seq
+	if err := q.store(j); err != nil {
+		q.seq--
+		return fmt.Errorf("failed to store replication job: %v", err)
+	}
+	q.jobs = append(q.jobs, j)
+	q.cond.Signal()
+	return nil
+}
+
+// peek waits for a job and returns the first one, or nil once the queue is
+// closed.
+func (q *queue) peek() *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil
+	}
+	return q.jobs[0]
+}
+
+// retry records a failed attempt of the first job
+func (q *queue) retry(j *job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j.Attempts++
+	return q.store(j)
+}
+
+// remove removes the first job of the queue
+func (q *queue) remove(j *job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) == 0 || q.jobs[0] != j {
+		return fmt.Errorf("replication job %d is not first in queue", j.Seq)
+	}
+	q.jobs = q.jobs[1:]
+	if q.dir == "" {
+		return nil
+	}
+	if err := os.Remove(q.path(j)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// len returns the number of jobs pending
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.jobs)
+}
+
+// close wakes up the waiters of the queue. The jobs pending are kept in dir.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
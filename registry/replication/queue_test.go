@@ -0,0 +1,85 @@
+package replication
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestQueuePersistence(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		if err := q.push(&job{Action: actionPush, Repository: "foo/bar", Digest: digest.FromString(tag), Tag: tag, Timestamp: time.Now()}); err != nil {
+			t.Fatalf("unexpected error pushing job: %v", err)
+		}
+	}
+	first := q.peek()
+	if err := q.retry(first); err != nil {
+		t.Fatal(err)
+	}
+	q.close()
+	if j := q.peek(); j != nil {
+		t.Fatalf("expected closed queue to return no job, got %v", j)
+	}
+
+	// the jobs survive the queue, in order
+	q, err = openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.len() != 3 {
+		t.Fatalf("expected 3 jobs after reopening, got %d", q.len())
+	}
+	j := q.peek()
+	if j.Tag != "v1" || j.Attempts != 1 {
+		t.Fatalf("unexpected first job: %+v", j)
+	}
+	if err := q.remove(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(&job{Action: actionDelete, Repository: "foo/bar", Tag: "v4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = openQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for q.len() > 0 {
+		j := q.peek()
+		tags = append(tags, j.Tag)
+		if err := q.remove(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tags) != 3 || tags[0] != "v2" || tags[1] != "v3" || tags[2] != "v4" {
+		t.Fatalf("unexpected jobs after reopening: %v", tags)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected empty queue directory, got %d entries", len(entries))
+	}
+}
+
+func TestQueueInvalidJob(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openQueue(dir); err == nil {
+		t.Fatalf("expected invalid job to be rejected")
+	}
+}
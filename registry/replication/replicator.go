@@ -0,0 +1,161 @@
+// Package replication replicates the repositories pushed to the registry to
+// other registries, driven by the notification events of the registry.
+package replication
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/notifications"
+	events "github.com/docker/go-events"
+)
+
+const (
+	actionPush   = notifications.EventActionPush
+	actionDelete = notifications.EventActionDelete
+)
+
+// Replicator is a sink of notification events replicating the manifests
+// pushed, the tags and manifests deleted to the targets matching their
+// repository. The replications are queued for each target, and retried until
+// they succeed.
+type Replicator struct {
+	targets       []*target
+	manifestTypes map[string]bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+var _ events.Sink = &Replicator{}
+
+// NewReplicator returns a running replicator of the content of registry to
+// the targets of the configuration.
+func NewReplicator(ctx context.Context, registry distribution.Namespace, config configuration.Replication) (*Replicator, error) {
+	r := &Replicator{
+		manifestTypes: make(map[string]bool),
+	}
+	for _, mediaType := range distribution.ManifestMediaTypes() {
+		r.manifestTypes[mediaType] = true
+	}
+
+	names := make(map[string]bool)
+	for _, targetConfig := range config.Targets {
+		if targetConfig.Disabled {
+			dcontext.GetLogger(ctx).Infof("replication target %s disabled, skipping", targetConfig.Name)
+			continue
+		}
+		if targetConfig.Name == "" || names[targetConfig.Name] {
+			return nil, fmt.Errorf("replication targets must have unique names, got %q", targetConfig.Name)
+		}
+		names[targetConfig.Name] = true
+
+		var dir string
+		if config.QueueDirectory != "" {
+			dir = filepath.Join(config.QueueDirectory, targetConfig.Name)
+		}
+		q, err := openQueue(dir)
+		if err != nil {
+			return nil, err
+		}
+		t, err := newTarget(registry, targetConfig, q)
+		if err != nil {
+			return nil, err
+		}
+		r.targets = append(r.targets, t)
+	}
+
+	ctx, r.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	for _, t := range r.targets {
+		r.wg.Add(1)
+		go func(t *target) {
+			defer r.wg.Done()
+			t.run(ctx)
+		}(t)
+	}
+	return r, nil
+}
+
+// Write queues the replication of the event to the targets matching its
+// repository. Blob events are ignored, as the blobs are replicated with the
+// manifests referencing them.
+func (r *Replicator) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok {
+		return fmt.Errorf("replicator: unexpected event type %T", event)
+	}
+
+	j := &job{
+		Action:     e.Action,
+		Repository: e.Target.Repository,
+		Digest:     e.Target.Digest,
+		Tag:        e.Target.Tag,
+		Timestamp:  e.Timestamp,
+	}
+	switch e.Action {
+	case actionPush:
+		if !r.manifestTypes[e.Target.MediaType] {
+			return nil
+		}
+	case actionDelete:
+		// deletions of repositories are not replicated, nor those of blobs
+		// which the targets do not know as manifests
+		if j.Digest == "" && j.Tag == "" {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return notifications.ErrSinkClosed
+	}
+
+	var errs []error
+	for _, t := range r.targets {
+		if !t.matches(j.Repository) {
+			continue
+		}
+		tj := *j
+		if err := t.enqueue(&tj); err != nil {
+			errs = append(errs, fmt.Errorf("replication target %s: %v", t.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("replicator: %v", errs)
+	}
+	return nil
+}
+
+// Close stops the replications. The replications pending are kept in the
+// queues, and resumed by the next replicator.
+func (r *Replicator) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return fmt.Errorf("replicator: already closed")
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	for _, t := range r.targets {
+		t.close()
+	}
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Replicator) String() string {
+	return fmt.Sprintf("replicator{%d targets}", len(r.targets))
+}
@@ -0,0 +1,380 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	blobPath     = regexp.MustCompile(`^/v2/(.+)/blobs/([^/]+)$`)
+	uploadPath   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/(.*)$`)
+	manifestPath = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+)
+
+// targetRegistry is a minimal registry receiving the replications
+type targetRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte // by repository@digest
+	uploads   map[string][]byte // by uuid
+	manifests map[string]string // digest by repository:tag and repository@digest
+	mounts    int
+	failPuts  int // manifest puts failing before the next succeeds
+}
+
+func newTargetRegistry() *targetRegistry {
+	tr := &targetRegistry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string][]byte),
+		manifests: make(map[string]string),
+	}
+	tr.Server = httptest.NewServer(http.HandlerFunc(tr.serveHTTP))
+	return tr
+}
+
+func (tr *targetRegistry) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	unknown := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errors": [{"code": %q}]}`, code)
+	}
+
+	switch {
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+
+	case uploadPath.MatchString(r.URL.Path):
+		m := uploadPath.FindStringSubmatch(r.URL.Path)
+		name, uuid := m[1], m[2]
+		switch r.Method {
+		case http.MethodPost:
+			if from, mount := r.URL.Query().Get("from"), r.URL.Query().Get("mount"); mount != "" {
+				if content, ok := tr.blobs[from+"@"+mount]; ok {
+					tr.blobs[name+"@"+mount] = content
+					tr.mounts++
+					w.Header().Set("Location", "/v2/"+name+"/blobs/"+mount)
+					w.WriteHeader(http.StatusCreated)
+					return
+				}
+			}
+			uuid = strconv.Itoa(len(tr.uploads) + 1)
+			tr.uploads[uuid] = nil
+			w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+uuid)
+			w.Header().Set("Docker-Upload-UUID", uuid)
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPatch, http.MethodPut:
+			content, _ := io.ReadAll(r.Body)
+			tr.uploads[uuid] = append(tr.uploads[uuid], content...)
+			if r.Method == http.MethodPut {
+				tr.blobs[name+"@"+r.URL.Query().Get("digest")] = tr.uploads[uuid]
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+			end := len(tr.uploads[uuid]) - 1
+			if end < 0 {
+				end = 0
+			}
+			w.Header().Set("Location", "/v2/"+name+"/blobs/uploads/"+uuid)
+			w.Header().Set("Docker-Upload-UUID", uuid)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case blobPath.MatchString(r.URL.Path):
+		m := blobPath.FindStringSubmatch(r.URL.Path)
+		content, ok := tr.blobs[m[1]+"@"+m[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Docker-Content-Digest", m[2])
+		w.WriteHeader(http.StatusOK)
+
+	case manifestPath.MatchString(r.URL.Path):
+		m := manifestPath.FindStringSubmatch(r.URL.Path)
+		name, ref := m[1], m[2]
+		key := name + ":" + ref
+		if _, err := digest.Parse(ref); err == nil {
+			key = name + "@" + ref
+		}
+		switch r.Method {
+		case http.MethodPut:
+			if tr.failPuts > 0 {
+				tr.failPuts--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			payload, _ := io.ReadAll(r.Body)
+			dgst := digest.FromBytes(payload)
+			tr.manifests[key] = dgst.String()
+			tr.manifests[name+"@"+dgst.String()] = dgst.String()
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if _, ok := tr.manifests[key]; !ok {
+				unknown("MANIFEST_UNKNOWN")
+				return
+			}
+			delete(tr.manifests, key)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// waitFor waits for the condition on the target registry to hold
+func (tr *targetRegistry) waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		tr.mu.Lock()
+		ok := condition()
+		tr.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func pushEvent(repository string, dgst digest.Digest, mediaType, tag string) notifications.Event {
+	var e notifications.Event
+	e.Action = notifications.EventActionPush
+	e.Timestamp = time.Now()
+	e.Target.Repository = repository
+	e.Target.Digest = dgst
+	e.Target.MediaType = mediaType
+	e.Target.Tag = tag
+	return e
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	target := newTargetRegistry()
+	defer target.Close()
+	other := newTargetRegistry()
+	defer other.Close()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	// the same image in two repositories
+	layers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var image distribution.Manifest
+	var imageDigest digest.Digest
+	for _, name := range []string{"team/app", "team/copy"} {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var digests []digest.Digest
+		for dgst, rs := range layers {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			digests = append(digests, dgst)
+		}
+		if err := testutil.UploadBlobs(repo, layers); err != nil {
+			t.Fatal(err)
+		}
+		image, err = testutil.MakeSchema2Manifest(repo, digests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imageDigest, err = manifests.Put(ctx, image, distribution.WithTag("v1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replicator, err := NewReplicator(ctx, registry, configuration.Replication{
+		QueueDirectory: t.TempDir(),
+		Targets: []configuration.ReplicationTarget{
+			{Name: "team", URL: target.URL, Repositories: []string{"team/*"}, Backoff: 10 * time.Millisecond},
+			{Name: "other", URL: other.URL, Repositories: []string{"other/*"}},
+			{Name: "disabled", URL: other.URL, Disabled: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("error creating replicator: %v", err)
+	}
+	defer replicator.Close()
+
+	// the first attempt to push the manifest fails
+	target.mu.Lock()
+	target.failPuts = 1
+	target.mu.Unlock()
+
+	for _, e := range []notifications.Event{
+		pushEvent("team/app", getLayer(layers), "application/octet-stream", ""),
+		pushEvent("team/app", imageDigest, schema2.MediaTypeManifest, "v1"),
+	} {
+		if err := replicator.Write(e); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+	target.waitFor(t, "team/app:v1 replication", func() bool {
+		return target.manifests["team/app:v1"] == imageDigest.String()
+	})
+	for dgst := range layers {
+		if _, ok := target.blobs["team/app@"+dgst.String()]; !ok {
+			t.Fatalf("layer %s not replicated", dgst)
+		}
+	}
+
+	// the layers replicated are mounted in other repositories
+	if err := replicator.Write(pushEvent("team/copy", imageDigest, schema2.MediaTypeManifest, "")); err != nil {
+		t.Fatal(err)
+	}
+	target.waitFor(t, "team/copy replication", func() bool {
+		return target.manifests["team/copy@"+imageDigest.String()] != ""
+	})
+	target.mu.Lock()
+	mounts := target.mounts
+	target.mu.Unlock()
+	if mounts != len(layers)+1 {
+		t.Fatalf("expected %d blobs mounted, got %d", len(layers)+1, mounts)
+	}
+
+	// deletions of tags and manifests are replicated, unknown ones ignored
+	var deleteTag, deleteUnknown, deleteManifest notifications.Event
+	deleteTag.Action = notifications.EventActionDelete
+	deleteTag.Target.Repository = "team/app"
+	deleteTag.Target.Tag = "v1"
+	deleteUnknown.Action = notifications.EventActionDelete
+	deleteUnknown.Target.Repository = "team/app"
+	deleteUnknown.Target.Digest = digest.FromString("unknown")
+	deleteManifest.Action = notifications.EventActionDelete
+	deleteManifest.Target.Repository = "team/copy"
+	deleteManifest.Target.Digest = imageDigest
+	for _, e := range []notifications.Event{deleteTag, deleteUnknown, deleteManifest} {
+		if err := replicator.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	target.waitFor(t, "deletions replication", func() bool {
+		_, tagged := target.manifests["team/app:v1"]
+		_, stored := target.manifests["team/copy@"+imageDigest.String()]
+		return !tagged && !stored
+	})
+
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	if len(other.manifests) != 0 || len(other.blobs) != 0 {
+		t.Fatalf("unexpected replication to a target not matching the repository")
+	}
+}
+
+func TestReplicatorResume(t *testing.T) {
+	ctx := context.Background()
+	target := newTargetRegistry()
+	defer target.Close()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	named, err := reference.WithName("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := testutil.MakeSchema2Manifest(repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDigest, err := manifests.Put(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replication is pending while the target is unreachable
+	queueDir := t.TempDir()
+	config := configuration.Replication{
+		QueueDirectory: queueDir,
+		Targets:        []configuration.ReplicationTarget{{Name: "team", URL: "http://127.0.0.1:1", Backoff: time.Hour}},
+	}
+	replicator, err := NewReplicator(ctx, registry, config)
+	if err != nil {
+		t.Fatalf("error creating replicator: %v", err)
+	}
+	if err := replicator.Write(pushEvent("team/app", imageDigest, schema2.MediaTypeManifest, "v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicator.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicator.Write(pushEvent("team/app", imageDigest, schema2.MediaTypeManifest, "v1")); err != notifications.ErrSinkClosed {
+		t.Fatalf("expected write to closed replicator to fail, got %v", err)
+	}
+
+	// the next replicator resumes it
+	config.Targets[0].URL = target.URL
+	replicator, err = NewReplicator(ctx, registry, config)
+	if err != nil {
+		t.Fatalf("error creating replicator: %v", err)
+	}
+	defer replicator.Close()
+	target.waitFor(t, "team/app:v1 replication", func() bool {
+		return target.manifests["team/app:v1"] == imageDigest.String()
+	})
+}
+
+func getLayer(layers map[digest.Digest]io.ReadSeeker) digest.Digest {
+	for dgst := range layers {
+		return dgst
+	}
+	return ""
+}
@@ -0,0 +1,414 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/internal/client"
+	"github.com/distribution/distribution/v3/internal/client/auth"
+	"github.com/distribution/distribution/v3/internal/client/auth/challenge"
+	"github.com/distribution/distribution/v3/internal/client/transport"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	defaultBackoff    = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+
+	// maxMounts bounds the blobs remembered as replicated to a target
+	maxMounts = 10000
+)
+
+// errContentUnknown is returned when the content replicated is no longer in
+// the registry. The replication is dropped, as the deletion of the content is
+// replicated by its own event.
+var errContentUnknown = errors.New("content unknown")
+
+// target is a registry the repositories are replicated to. Its jobs are
+// replicated in order, each one retried until it succeeds.
+type target struct {
+	name         string
+	url          string
+	repositories []string
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+
+	registry distribution.Namespace
+	queue    *queue
+
+	cm    challenge.Manager
+	creds auth.CredentialStore
+
+	// mounts maps the blobs replicated to the target to the repository they
+	// were replicated to, to mount them in other repositories
+	mu     sync.Mutex
+	mounts map[digest.Digest]reference.Named
+
+	done chan struct{}
+}
+
+func newTarget(registry distribution.Namespace, config configuration.ReplicationTarget, q *queue) (*target, error) {
+	if _, err := url.Parse(config.URL); err != nil {
+		return nil, fmt.Errorf("invalid replication target %s url: %v", config.Name, err)
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid replication target %s repository pattern %q: %v", config.Name, pattern, err)
+		}
+	}
+
+	t := &target{
+		name:         config.Name,
+		url:          config.URL,
+		repositories: config.Repositories,
+		backoff:      config.Backoff,
+		maxBackoff:   config.MaxBackoff,
+		maxAttempts:  config.MaxAttempts,
+		registry:     registry,
+		queue:        q,
+		cm:           challenge.NewSimpleManager(),
+		creds:        userpass{username: config.Username, password: config.Password},
+		mounts:       make(map[digest.Digest]reference.Named),
+		done:         make(chan struct{}),
+	}
+	if t.backoff <= 0 {
+		t.backoff = defaultBackoff
+	}
+	if t.maxBackoff <= 0 {
+		t.maxBackoff = defaultMaxBackoff
+	}
+	pendingGauge.WithValues(t.name).Set(float64(q.len()))
+	return t, nil
+}
+
+// matches returns true if the repository is replicated to the target
+func (t *target) matches(repository string) bool {
+	if len(t.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range t.repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue adds the job to the queue of the target
+func (t *target) enqueue(j *job) error {
+	if err := t.queue.push(j); err != nil {
+		return err
+	}
+	pendingGauge.WithValues(t.name).Inc(1)
+	return nil
+}
+
+// run replicates the jobs of the queue until the target is closed
+func (t *target) run(ctx context.Context) {
+	backoff := t.backoff
+	for {
+		j := t.queue.peek()
+		if j == nil {
+			return
+		}
+
+		err := t.replicate(ctx, j)
+		switch {
+		case err == nil:
+			replicationsCounter.WithValues("Successes", t.name).Inc(1)
+			lagTimer.WithValues(t.name).UpdateSince(j.Timestamp)
+		case errors.Is(err, errContentUnknown):
+			dcontext.GetLogger(ctx).Infof("replication of %s to %s skipped: %v", describe(j), t.name, err)
+			replicationsCounter.WithValues("Skipped", t.name).Inc(1)
+		case t.maxAttempts > 0 && j.Attempts+1 >= t.maxAttempts:
+			dcontext.GetLogger(ctx).Errorf("replication of %s to %s dropped after %d attempts: %v", describe(j), t.name, j.Attempts+1, err)
+			replicationsCounter.WithValues("Dropped", t.name).Inc(1)
+		default:
+			dcontext.GetLogger(ctx).Warnf("replication of %s to %s failed, retrying in %s: %v", describe(j), t.name, backoff, err)
+			replicationsCounter.WithValues("Failures", t.name).Inc(1)
+			if err := t.queue.retry(j); err != nil {
+				dcontext.GetLogger(ctx).Errorf("error storing replication of %s to %s: %v", describe(j), t.name, err)
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-t.done:
+				return
+			}
+			backoff *= 2
+			if backoff > t.maxBackoff {
+				backoff = t.maxBackoff
+			}
+			continue
+		}
+
+		backoff = t.backoff
+		if err := t.queue.remove(j); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error removing replication of %s to %s: %v", describe(j), t.name, err)
+		}
+		pendingGauge.WithValues(t.name).Dec(1)
+	}
+}
+
+// close stops the replication to the target. The jobs pending are kept in
+// the queue.
+func (t *target) close() {
+	close(t.done)
+	t.queue.close()
+}
+
+func describe(j *job) string {
+	ref := j.Repository
+	if j.Tag != "" {
+		ref += ":" + j.Tag
+	}
+	if j.Digest != "" {
+		ref += "@" + j.Digest.String()
+	}
+	return j.Action + " " + ref
+}
+
+// replicate replicates the job to the target
+func (t *target) replicate(ctx context.Context, j *job) error {
+	named, err := reference.WithName(j.Repository)
+	if err != nil {
+		return err
+	}
+	remote, err := t.repository(ctx, named)
+	if err != nil {
+		return err
+	}
+
+	if j.Action == actionDelete {
+		if j.Digest == "" {
+			err = remote.Tags(ctx).Untag(ctx, j.Tag)
+		} else {
+			var manifests distribution.ManifestService
+			manifests, err = remote.Manifests(ctx)
+			if err == nil {
+				err = manifests.Delete(ctx, j.Digest)
+			}
+		}
+		if isUnknown(err) {
+			return nil
+		}
+		return err
+	}
+
+	local, err := t.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	return t.pushManifest(ctx, local, remote, j.Digest, j.Tag)
+}
+
+// pushManifest pushes the manifest to the remote repository, after the
+// manifests of an index or the blobs of an image.
+func (t *target) pushManifest(ctx context.Context, local, remote distribution.Repository, dgst digest.Digest, tag string) error {
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	manifest, err := localManifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return fmt.Errorf("manifest %s: %w", dgst, errContentUnknown)
+		}
+		return err
+	}
+
+	switch manifest.(type) {
+	case *manifestlist.DeserializedManifestList, *ocischema.DeserializedImageIndex:
+		for _, child := range manifest.References() {
+			if err := t.pushManifest(ctx, local, remote, child.Digest, ""); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, desc := range manifest.References() {
+			if err := t.pushBlob(ctx, local, remote, desc); err != nil {
+				return err
+			}
+		}
+	}
+
+	remoteManifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	var options []distribution.ManifestServiceOption
+	if tag != "" {
+		options = append(options, distribution.WithTag(tag))
+	}
+	_, err = remoteManifests.Put(ctx, manifest, options...)
+	return err
+}
+
+// pushBlob pushes the blob to the remote repository unless it is there. A
+// blob replicated to another repository of the target is mounted from it.
+func (t *target) pushBlob(ctx context.Context, local, remote distribution.Repository, desc v1.Descriptor) error {
+	blobs := remote.Blobs(ctx)
+	if _, err := blobs.Stat(ctx, desc.Digest); err == nil {
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	var options []distribution.BlobCreateOption
+	t.mu.Lock()
+	from, ok := t.mounts[desc.Digest]
+	t.mu.Unlock()
+	if ok && from.Name() != remote.Named().Name() {
+		canonical, err := reference.WithDigest(from, desc.Digest)
+		if err != nil {
+			return err
+		}
+		options = append(options, client.WithMountFrom(canonical))
+	}
+
+	bw, err := blobs.Create(ctx, options...)
+	if err != nil {
+		if _, ok := err.(distribution.ErrBlobMounted); ok {
+			return nil
+		}
+		return err
+	}
+
+	rc, err := local.Blobs(ctx).Open(ctx, desc.Digest)
+	if err != nil {
+		// nolint:errcheck
+		bw.Cancel(ctx)
+		if err == distribution.ErrBlobUnknown {
+			return fmt.Errorf("blob %s: %w", desc.Digest, errContentUnknown)
+		}
+		return err
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(bw, rc); err != nil {
+		// nolint:errcheck
+		bw.Cancel(ctx)
+		return err
+	}
+	if _, err := bw.Commit(ctx, desc); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if len(t.mounts) >= maxMounts {
+		t.mounts = make(map[digest.Digest]reference.Named)
+	}
+	t.mounts[desc.Digest] = remote.Named()
+	t.mu.Unlock()
+	return nil
+}
+
+// repository returns the repository of the target, authorized to push
+func (t *target) repository(ctx context.Context, named reference.Named) (distribution.Repository, error) {
+	if err := t.establishChallenges(); err != nil {
+		return nil, err
+	}
+
+	tokenHandler := auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+		Transport:   http.DefaultTransport,
+		Credentials: t.creds,
+		Scopes: []auth.Scope{
+			auth.RepositoryScope{
+				Repository: named.Name(),
+				Actions:    []string{"pull", "push"},
+			},
+		},
+		Logger: dcontext.GetLogger(ctx),
+	})
+	tr := transport.NewTransport(http.DefaultTransport,
+		auth.NewAuthorizer(t.cm, tokenHandler, auth.NewBasicHandler(t.creds)))
+
+	return client.NewRepository(named, t.url, tr)
+}
+
+// establishChallenges gets the authentication challenges of the target
+// unless they are known
+func (t *target) establishChallenges() error {
+	u, err := url.Parse(t.url)
+	if err != nil {
+		return err
+	}
+	u.Path = "/v2/"
+	challenges, err := t.cm.GetChallenges(*u)
+	if err != nil {
+		return err
+	}
+	if len(challenges) > 0 {
+		return nil
+	}
+
+	resp, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return t.cm.AddResponse(resp)
+}
+
+// isUnknown returns true if the error reports the content unknown to the
+// target
+func isUnknown(err error) bool {
+	var errs errcode.Errors
+	if !errors.As(err, &errs) {
+		var e errcode.Error
+		if !errors.As(err, &e) {
+			return false
+		}
+		errs = errcode.Errors{e}
+	}
+	for _, err := range errs {
+		var code errcode.ErrorCode
+		switch e := err.(type) {
+		case errcode.Error:
+			code = e.Code
+		case errcode.ErrorCode:
+			code = e
+		default:
+			return false
+		}
+		switch code {
+		case v2.ErrorCodeManifestUnknown, v2.ErrorCodeNameUnknown, v2.ErrorCodeBlobUnknown:
+		default:
+			return false
+		}
+	}
+	return len(errs) > 0
+}
+
+type userpass struct {
+	username string
+	password string
+}
+
+func (u userpass) Basic(_ *url.URL) (string, string) {
+	return u.username, u.password
+}
+
+func (u userpass) RefreshToken(_ *url.URL, service string) string {
+	return ""
+}
+
+func (u userpass) SetRefreshToken(_ *url.URL, service, token string) {
+}
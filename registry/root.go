@@ -5,6 +5,8 @@ import (
 	"os"
 
 	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/proxy"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	"github.com/distribution/distribution/v3/version"
@@ -19,6 +21,16 @@ func init() {
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
 	GCCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence output")
+	RootCmd.AddCommand(FsckCmd)
+	FsckCmd.Flags().BoolVarP(&verifyBlobs, "verify-blobs", "b", false, "re-hash blob data and report blobs not matching their digest")
+	FsckCmd.Flags().BoolVarP(&repair, "repair", "r", false, "remove dangling layer links, manifest revision links and tags")
+	FsckCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence progress output")
+	RootCmd.AddCommand(MigrateCmd)
+	MigrateCmd.Flags().IntVarP(&concurrency, "concurrency", "c", storage.DefaultConcurrencyLimit, "number of files copied in parallel")
+	MigrateCmd.Flags().StringVar(&checkpointFile, "checkpoint", "", "file recording the progress of the migration, to resume it or run it incrementally")
+	MigrateCmd.Flags().BoolVarP(&incremental, "incremental", "i", false, "only copy the files modified since the last migration completed with the checkpoint")
+	MigrateCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "silence output")
+	RootCmd.AddCommand(ProxyPrefetchCmd)
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -47,7 +59,7 @@ var (
 var GCCmd = &cobra.Command{
 	Use:   "garbage-collect <config>",
 	Short: "`garbage-collect` deletes layers not referenced by any manifests",
-	Long:  "`garbage-collect` deletes layers not referenced by any manifests",
+	Long:  "`garbage-collect` deletes layers not referenced by any manifests and tags expired by the configured retention policies",
 	Run: func(cmd *cobra.Command, args []string) {
 		config, err := resolveConfiguration(args)
 		if err != nil {
@@ -70,16 +82,48 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver)
+		var options []storage.RegistryOption
+		if q, ok := config.Storage["quota"]; ok {
+			if v, ok := q["policies"]; ok {
+				policies, err := storage.ParseQuotaPolicies(v)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to parse quota policies: %v", err)
+					os.Exit(1)
+				}
+				// release the quota of the layer links removed by the collection
+				options = append(options, storage.Quotas(policies...))
+			}
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, options...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
 			os.Exit(1)
 		}
 
+		var retentionPolicies []storage.RetentionPolicy
+		if mc, ok := config.Storage["maintenance"]; ok {
+			if v, ok := mc["retention"]; ok {
+				retentionPolicies, err = storage.ParseRetentionPolicies(v)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to parse retention policies: %v", err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		protectTag, err := handlers.ImmutableTags(config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse immutable tags policy: %v", err)
+			os.Exit(1)
+		}
+
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
-			DryRun:         dryRun,
-			RemoveUntagged: removeUntagged,
-			Quiet:          quiet,
+			DryRun:            dryRun,
+			RemoveUntagged:    removeUntagged,
+			Quiet:             quiet,
+			RetentionPolicies: retentionPolicies,
+			ProtectTag:        protectTag,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -87,3 +131,185 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+var (
+	verifyBlobs bool
+	repair      bool
+)
+
+// FsckCmd is the cobra command that corresponds to the fsck subcommand
+var FsckCmd = &cobra.Command{
+	Use:   "fsck <config>",
+	Short: "`fsck` verifies the integrity of the registry storage",
+	Long:  "`fsck` verifies the integrity of the registry storage, reporting corrupt blobs, dangling links and tags, and missing manifest references",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		problems, err := storage.Fsck(ctx, driver, registry, storage.FsckOpts{
+			VerifyBlobs: verifyBlobs,
+			Repair:      repair,
+			Quiet:       quiet,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check storage: %v", err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			if !problem.Repaired {
+				os.Exit(2)
+			}
+		}
+	},
+}
+
+var (
+	concurrency    int
+	checkpointFile string
+	incremental    bool
+)
+
+// MigrateCmd is the cobra command that corresponds to the migrate subcommand
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate <src-config> <dst-config>",
+	Short: "`migrate` copies the registry data between storage drivers",
+	Long:  "`migrate` copies the blobs, repositories, manifest revisions and tags from the storage of the source configuration to the storage of the destination configuration",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		srcConfig, err := resolveConfiguration(args[:1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "source configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		dstConfig, err := resolveConfiguration(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "destination configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, srcConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		src, err := factory.Create(ctx, srcConfig.Storage.Type(), srcConfig.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct source %s driver: %v", srcConfig.Storage.Type(), err)
+			os.Exit(1)
+		}
+		dst, err := factory.Create(ctx, dstConfig.Storage.Type(), dstConfig.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct destination %s driver: %v", dstConfig.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		err = storage.Migrate(ctx, src, dst, storage.MigrateOpts{
+			Concurrency: concurrency,
+			Checkpoint:  checkpointFile,
+			Incremental: incremental,
+			Quiet:       quiet,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ProxyPrefetchCmd is the cobra command that corresponds to the proxy-prefetch subcommand
+var ProxyPrefetchCmd = &cobra.Command{
+	Use:   "proxy-prefetch <config> [reference...]",
+	Short: "`proxy-prefetch` pulls content into a pull through cache",
+	Long:  "`proxy-prefetch` pulls the manifests, index children and blobs of the references, or of the proxy prefetch references of the configuration, into the storage of a pull through cache",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args[:1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			// nolint:errcheck
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if !config.Proxy.Enabled() {
+			fmt.Fprintf(os.Stderr, "configuration error: the registry is not configured as a pull through cache\n")
+			os.Exit(1)
+		}
+		references := args[1:]
+		if len(references) == 0 {
+			references = config.Proxy.Prefetch
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(ctx, config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		var options []storage.RegistryOption
+		if d, ok := config.Storage["delete"]; ok {
+			if deleteEnabled, ok := d["enabled"].(bool); ok && deleteEnabled {
+				options = append(options, storage.EnableDelete)
+			}
+		}
+		registry, err := storage.NewRegistry(ctx, driver, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		// the references are pulled by the command rather than in the background
+		config.Proxy.Prefetch = nil
+		cache, err := proxy.NewRegistryPullThroughCache(ctx, registry, driver, config.Proxy)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct pull through cache: %v", err)
+			os.Exit(1)
+		}
+
+		err = cache.(proxy.Prefetcher).Prefetch(ctx, references)
+		// the expiry of the content pulled is saved on close
+		if closeErr := cache.(proxy.Closer).Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to prefetch: %v", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -19,6 +19,7 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+	tracker *LinkTracker // tracker, if set, observes links for garbage collection.
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -62,6 +63,8 @@ func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekC
 // only be used for small objects, such as manifests. This implemented as a convenience for other Put implementations
 func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (v1.Descriptor, error) {
 	dgst := digest.FromBytes(p)
+	bs.tracker.record(dgst)
+
 	desc, err := bs.statter.Stat(ctx, dgst)
 	if err == nil {
 		// content already present
@@ -133,6 +136,8 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 // link links the path to the provided digest by writing the digest into the
 // target file. Caller must ensure that the blob actually exists.
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
+	bs.tracker.record(dgst)
+
 	// The contents of the "link" file are the exact string contents of the
 	// digest, which is specified in that package.
 	return bs.driver.PutContent(ctx, path, []byte(dgst))
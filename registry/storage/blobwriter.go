@@ -292,6 +292,10 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc v1.Descriptor) (v1.
 // identified by dgst. The layer should be validated before commencing the
 // move.
 func (bw *blobWriter) moveBlob(ctx context.Context, desc v1.Descriptor) error {
+	// Record the blob before checking for its existence, so that a concurrent
+	// garbage collection cannot sweep existing content about to be linked.
+	bw.blobStore.tracker.record(desc.Digest)
+
 	blobPath, err := pathFor(blobDataPathSpec{
 		digest: desc.Digest,
 	})
@@ -342,6 +346,16 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc v1.Descriptor) error {
 
 	// TODO(stevvooe): We should also write the mediatype when executing this move.
 
+	// For the drivers whose Move copies then deletes the object, a copy saves
+	// the deletion of the upload data, which removeResources deletes with the
+	// rest of the upload directory. The other drivers move atomically.
+	if copier, ok := bw.blobStore.driver.(storagedriver.Copier); ok && copier.MoveCopies() {
+		err := copier.Copy(ctx, bw.path, blobPath)
+		if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+			return err
+		}
+	}
+
 	return bw.blobStore.driver.Move(ctx, bw.path, blobPath)
 }
 
@@ -7,6 +7,7 @@ import (
 	"path"
 	"strings"
 
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 )
@@ -81,14 +82,39 @@ func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error)
 	return err
 }
 
-// Remove removes a repository from storage
+// Remove removes the manifests, tags, layer links and uploads of a
+// repository from storage. Repositories nested under name are left in place
+// and unreferenced blobs are left to garbage collection.
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
+	if !reg.deleteEnabled {
+		return distribution.ErrUnsupported
+	}
+
+	if err := reg.quotas.releaseRepository(ctx, name.Name()); err != nil {
+		return err
+	}
+
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return err
 	}
 	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+
+	found := false
+	for _, dir := range []string{"_manifests", "_layers", "_uploads", "_usage"} {
+		if err := reg.driver.Delete(ctx, path.Join(repoDir, dir)); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return distribution.ErrRepositoryUnknown{Name: name.Name()}
+	}
+	return nil
 }
 
 // lessPath returns true if one path a is less than path b.
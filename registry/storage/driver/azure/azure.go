@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -38,6 +39,9 @@ var ErrCorruptedData = errors.New("corrupted data found in the uploaded data")
 const (
 	driverName   = "azure"
 	maxChunkSize = 4 * 1024 * 1024
+
+	// maxBatchSize is the maximum number of sub-requests in a blob batch
+	maxBatchSize = 256
 )
 
 type azureDriverFactory struct{}
@@ -342,6 +346,15 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the original
 // object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.Copy(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	_, err := d.client.NewBlobClient(d.blobName(sourcePath)).Delete(ctx, nil)
+	return err
+}
+
+// Copy copies an object stored at sourcePath to destPath.
+func (d *driver) Copy(ctx context.Context, sourcePath string, destPath string) error {
 	srcBlobRef := d.client.NewBlobClient(d.blobName(sourcePath))
 	sourceBlobURL := srcBlobRef.URL()
 
@@ -380,9 +393,9 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		copyStatus = *props.CopyStatus
 		if copyStatus == blob.CopyStatusTypeAborted || copyStatus == blob.CopyStatusTypeFailed {
 			if props.CopyStatusDescription != nil {
-				return fmt.Errorf("failed to move blob: %s", *props.CopyStatusDescription)
+				return fmt.Errorf("failed to copy blob: %s", *props.CopyStatusDescription)
 			}
-			return fmt.Errorf("failed to move blob with copy id %s", *props.CopyID)
+			return fmt.Errorf("failed to copy blob with copy id %s", *props.CopyID)
 		}
 
 		if copyStatus == blob.CopyStatusTypePending {
@@ -391,8 +404,13 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		retryCount++
 	}
 
-	_, err = d.client.NewBlobClient(d.blobName(sourcePath)).Delete(ctx, nil)
-	return err
+	return nil
+}
+
+// MoveCopies returns true, as Move copies the blob before deleting the
+// original one.
+func (d *driver) MoveCopies() bool {
+	return true
 }
 
 // Delete recursively deletes all objects stored at "path" and its subpaths.
@@ -425,6 +443,39 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// DeleteFiles deletes the objects stored at the paths, in batches of up to
+// maxBatchSize blobs.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	var errs []error
+	for batch := range slices.Chunk(paths, maxBatchSize) {
+		bb, err := d.client.NewBatchBuilder()
+		if err != nil {
+			return err
+		}
+		for _, path := range batch {
+			if err := bb.Delete(d.blobName(path), nil); err != nil {
+				return err
+			}
+		}
+		resp, err := d.client.SubmitBatch(ctx, bb, nil)
+		if err != nil {
+			return err
+		}
+		for _, item := range resp.Responses {
+			if item.Error != nil && !is404(item.Error) {
+				errs = append(errs, item.Error)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return storagedriver.Errors{
+			DriverName: driverName,
+			Errs:       errs,
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a publicly accessible URL for the blob stored at given path
 // for specified duration by making use of Azure Storage Shared Access Signatures (SAS).
 // See https://msdn.microsoft.com/en-us/library/azure/ee395415.aspx for more info.
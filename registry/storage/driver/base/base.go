@@ -288,6 +288,76 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 	return err
 }
 
+// Copy wraps Copy of the underlying storage driver, if it implements
+// storagedriver.Copier.
+func (base *Base) Copy(ctx context.Context, sourcePath string, destPath string) error {
+	attrs := []attribute.KeyValue{
+		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+		attribute.String(tracing.AttributePrefix+"storage.source.path", sourcePath),
+		attribute.String(tracing.AttributePrefix+"storage.dest.path", destPath),
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"Copy",
+		trace.WithAttributes(attrs...))
+
+	defer span.End()
+
+	copier, ok := base.StorageDriver.(storagedriver.Copier)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+
+	if !storagedriver.PathRegexp.MatchString(sourcePath) {
+		return storagedriver.InvalidPathError{Path: sourcePath, DriverName: base.StorageDriver.Name()}
+	} else if !storagedriver.PathRegexp.MatchString(destPath) {
+		return storagedriver.InvalidPathError{Path: destPath, DriverName: base.StorageDriver.Name()}
+	}
+
+	start := time.Now()
+	err := base.setDriverName(copier.Copy(ctx, sourcePath, destPath))
+	storageAction.WithValues(base.Name(), "Copy").UpdateSince(start)
+	return err
+}
+
+// MoveCopies wraps MoveCopies of the underlying storage driver, if it
+// implements storagedriver.Copier.
+func (base *Base) MoveCopies() bool {
+	copier, ok := base.StorageDriver.(storagedriver.Copier)
+	return ok && copier.MoveCopies()
+}
+
+// DeleteFiles wraps DeleteFiles of the underlying storage driver, if it
+// implements storagedriver.BatchDeleter.
+func (base *Base) DeleteFiles(ctx context.Context, paths []string) error {
+	attrs := []attribute.KeyValue{
+		attribute.String(tracing.AttributePrefix+"storage.driver.name", base.Name()),
+		attribute.Int(tracing.AttributePrefix+"storage.paths", len(paths)),
+	}
+	ctx, span := tracer.Start(
+		ctx,
+		"DeleteFiles",
+		trace.WithAttributes(attrs...))
+
+	defer span.End()
+
+	deleter, ok := base.StorageDriver.(storagedriver.BatchDeleter)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{DriverName: base.StorageDriver.Name()}
+	}
+
+	for _, path := range paths {
+		if !storagedriver.PathRegexp.MatchString(path) {
+			return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
+		}
+	}
+
+	start := time.Now()
+	err := base.setDriverName(deleter.DeleteFiles(ctx, paths))
+	storageAction.WithValues(base.Name(), "DeleteFiles").UpdateSince(start)
+	return err
+}
+
 // RedirectURL wraps RedirectURL of the underlying storage driver.
 func (base *Base) RedirectURL(r *http.Request, path string) (string, error) {
 	attrs := []attribute.KeyValue{
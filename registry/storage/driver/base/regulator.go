@@ -173,6 +173,41 @@ func (r *regulator) Delete(ctx context.Context, path string) error {
 	return r.StorageDriver.Delete(ctx, path)
 }
 
+// Copy copies an object stored at sourcePath to destPath, if the underlying
+// driver implements storagedriver.Copier.
+func (r *regulator) Copy(ctx context.Context, sourcePath string, destPath string) error {
+	copier, ok := r.StorageDriver.(storagedriver.Copier)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{}
+	}
+
+	r.enter()
+	defer r.exit()
+
+	return copier.Copy(ctx, sourcePath, destPath)
+}
+
+// MoveCopies reports whether Move copies the object before deleting its
+// source, if the underlying driver implements storagedriver.Copier.
+func (r *regulator) MoveCopies() bool {
+	copier, ok := r.StorageDriver.(storagedriver.Copier)
+	return ok && copier.MoveCopies()
+}
+
+// DeleteFiles deletes the objects stored at the paths, if the underlying
+// driver implements storagedriver.BatchDeleter.
+func (r *regulator) DeleteFiles(ctx context.Context, paths []string) error {
+	deleter, ok := r.StorageDriver.(storagedriver.BatchDeleter)
+	if !ok {
+		return storagedriver.ErrUnsupportedMethod{}
+	}
+
+	r.enter()
+	defer r.exit()
+
+	return deleter.DeleteFiles(ctx, paths)
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at
 // the given path.
 func (r *regulator) RedirectURL(req *http.Request, path string) (string, error) {
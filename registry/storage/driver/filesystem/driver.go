@@ -286,6 +286,87 @@ func (d *driver) Delete(ctx context.Context, subPath string) error {
 	return err
 }
 
+// Copy copies an object stored at sourcePath to destPath. The copy is made by
+// the kernel, which shares the data of the files on the filesystems
+// supporting it, into a temporary file renamed to destPath once complete.
+func (d *driver) Copy(ctx context.Context, sourcePath string, destPath string) error {
+	source, err := os.Open(d.fullPath(sourcePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return storagedriver.PathNotFoundError{Path: sourcePath}
+		}
+		return err
+	}
+	defer source.Close()
+
+	fi, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	dest := d.fullPath(destPath)
+	if dest == source.Name() {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(dest), 0o777); err != nil {
+		return err
+	}
+
+	// the copy is written aside then renamed into place, so that the
+	// destination never holds partial content
+	fp, err := os.CreateTemp(path.Dir(dest), "."+path.Base(dest)+".copy-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fp, source); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := fp.Chmod(fi.Mode().Perm()); err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		os.Remove(fp.Name())
+		return err
+	}
+	if err := os.Rename(fp.Name(), dest); err != nil {
+		os.Remove(fp.Name())
+		return err
+	}
+	return nil
+}
+
+// MoveCopies returns false, as Move renames the file.
+func (d *driver) MoveCopies() bool {
+	return false
+}
+
+// DeleteFiles deletes the files stored at the paths, along with their
+// directory when left empty.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	for _, subPath := range paths {
+		fullPath := d.fullPath(subPath)
+		if err := os.Remove(fullPath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		// removing a directory fails unless it is empty
+		if dir := path.Dir(fullPath); dir != path.Clean(d.rootDirectory) {
+			os.Remove(dir)
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
 func (d *driver) RedirectURL(*http.Request, string) (string, error) {
 	return "", nil
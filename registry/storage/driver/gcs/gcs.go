@@ -37,6 +37,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
@@ -55,6 +56,8 @@ const (
 	blobContentType          = "application/octet-stream"
 
 	maxTries = 5
+
+	maxDeleteConcurrency = 10
 )
 
 var rangeHeader = regexp.MustCompile(`^bytes=([0-9])+-([0-9]+)$`)
@@ -706,6 +709,20 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the
 // original object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.Copy(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	err := d.bucket.Object(d.pathToKey(sourcePath)).Delete(ctx)
+	// if deleting the file fails, log the error, but do not fail; the file was successfully copied,
+	// and the original should eventually be cleaned when purging the uploads folder.
+	if err != nil {
+		logrus.Infof("error deleting %v: %v", sourcePath, err)
+	}
+	return nil
+}
+
+// Copy copies an object stored at sourcePath to destPath.
+func (d *driver) Copy(ctx context.Context, sourcePath string, destPath string) error {
 	srcKey, dstKey := d.pathToKey(sourcePath), d.pathToKey(destPath)
 	src := d.bucket.Object(srcKey)
 	_, err := d.bucket.Object(dstKey).CopierFrom(src).Run(ctx)
@@ -716,17 +733,17 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 				return storagedriver.PathNotFoundError{Path: srcKey}
 			}
 		}
-		return fmt.Errorf("move %q to %q: %v", srcKey, dstKey, err)
-	}
-	err = src.Delete(ctx)
-	// if deleting the file fails, log the error, but do not fail; the file was successfully copied,
-	// and the original should eventually be cleaned when purging the uploads folder.
-	if err != nil {
-		logrus.Infof("error deleting %v: %v", sourcePath, err)
+		return fmt.Errorf("copy %q to %q: %v", srcKey, dstKey, err)
 	}
 	return nil
 }
 
+// MoveCopies returns true, as Move copies the object before deleting the
+// original one.
+func (d *driver) MoveCopies() bool {
+	return true
+}
+
 // listAll recursively lists all names of objects stored at "prefix" and its subpaths.
 func (d *driver) listAll(ctx context.Context, prefix string) ([]string, error) {
 	objects := d.bucket.Objects(ctx, &storage.Query{
@@ -791,6 +808,23 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	return err
 }
 
+// DeleteFiles deletes the objects stored at the paths, running up to
+// maxDeleteConcurrency deletes at once, as GCS has no bulk delete.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxDeleteConcurrency)
+	for _, path := range paths {
+		g.Go(func() error {
+			err := d.bucket.Object(d.pathToKey(path)).Delete(gctx)
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				return nil
+			}
+			return err
+		})
+	}
+	return g.Wait()
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at
 // the given path, possibly using the given options.
 func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"sync"
 	"time"
 
@@ -239,6 +240,64 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	}
 }
 
+// Copy copies an object stored at sourcePath to destPath.
+func (d *driver) Copy(ctx context.Context, sourcePath string, destPath string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	normalizedSrc := normalize(sourcePath)
+	found := d.root.find(normalizedSrc)
+	src, ok := found.(*file)
+	if !ok || found.path() != normalizedSrc {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	dst, err := d.root.mkfile(normalize(destPath))
+	if err != nil {
+		return fmt.Errorf("not a file")
+	}
+	if dst == src {
+		return nil
+	}
+
+	dst.truncate()
+	if _, err := dst.WriteAt(src.data, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MoveCopies returns false, as Move renames the object in memory.
+func (d *driver) MoveCopies() bool {
+	return false
+}
+
+// DeleteFiles deletes the objects stored at the paths, along with their
+// directory when left empty.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, p := range paths {
+		normalized := normalize(p)
+		found := d.root.find(normalized)
+		if _, ok := found.(*file); !ok || found.path() != normalized {
+			continue
+		}
+		if err := d.root.delete(normalized); err != nil {
+			return err
+		}
+
+		dirname := normalize(path.Dir(normalized))
+		if parent, ok := d.root.find(dirname).(*dir); ok && parent != d.root && parent.path() == dirname && len(parent.children) == 0 {
+			if err := d.root.delete(dirname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
 func (d *driver) RedirectURL(*http.Request, string) (string, error) {
 	return "", nil
@@ -0,0 +1,461 @@
+// Package middleware provides a storage middleware caching the blob data read
+// from the wrapped driver on the local disk.
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/docker/go-metrics"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	if err := storagemiddleware.Register("diskcache", newDiskCacheStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register diskcache storage middleware: %v", err)
+	}
+}
+
+const (
+	// blobsRoot is the path under which the blobs are stored by their
+	// digest. Their data is immutable, so it can be cached.
+	blobsRoot = "/docker/registry/v2/blobs/"
+
+	// tempPrefix is the prefix of the files being written in the cache
+	// directory.
+	tempPrefix = "tmp-"
+
+	defaultMaxSize = 10 << 30
+)
+
+var (
+	// diskCacheRequestCount is the number of blob data reads.
+	diskCacheRequestCount = prometheus.StorageNamespace.NewCounter("diskcache_requests", "The number of blob data reads from the disk cache")
+	// diskCacheHitCount is the number of blob data reads served locally.
+	diskCacheHitCount = prometheus.StorageNamespace.NewCounter("diskcache_hits", "The number of blob data reads served by the disk cache")
+	// diskCacheEvictionCount is the number of blob data evicted.
+	diskCacheEvictionCount = prometheus.StorageNamespace.NewCounter("diskcache_evictions", "The number of blob data evicted from the disk cache")
+	// diskCacheSize is the size of the cached blob data.
+	diskCacheSize = prometheus.StorageNamespace.NewGauge("diskcache_size", "The size of the blob data in the disk cache", metrics.Bytes)
+)
+
+// diskCacheStorageMiddleware serves the blob data from the local disk,
+// caching the data read from the wrapped driver up to a size, evicting the
+// least recently used data first.
+type diskCacheStorageMiddleware struct {
+	storagedriver.StorageDriver
+	root    string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+var _ storagedriver.StorageDriver = &diskCacheStorageMiddleware{}
+
+// entry is the cached data of a path.
+type entry struct {
+	path string
+	size int64
+}
+
+func getStringOption(key string, options map[string]interface{}) (string, error) {
+	o, ok := options[key]
+	if !ok {
+		return "", nil
+	}
+	s, ok := o.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func getIntOption(key string, options map[string]interface{}) (int64, error) {
+	o, ok := options[key]
+	if !ok {
+		return 0, nil
+	}
+	switch v := o.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer", key)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+}
+
+func newDiskCacheStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	root, err := getStringOption("path", options)
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		return nil, fmt.Errorf("no path provided")
+	}
+	maxSize, err := getIntOption("maxsize", options)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize < 0 {
+		return nil, fmt.Errorf("maxsize must not be negative")
+	}
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %v", err)
+	}
+	d := &diskCacheStorageMiddleware{
+		StorageDriver: sd,
+		root:          root,
+		maxSize:       maxSize,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+	if err := d.load(); err != nil {
+		return nil, fmt.Errorf("unable to load cache directory: %v", err)
+	}
+	dcontext.GetLogger(ctx).Infof("disk cache loaded from %s: %d bytes of %d", root, d.size, maxSize)
+	return d, nil
+}
+
+// cacheable returns true if the data at the path is immutable.
+func cacheable(path string) bool {
+	return strings.HasPrefix(path, blobsRoot) && strings.HasSuffix(path, "/data")
+}
+
+// localPath returns the path of the cached data of the path.
+func (d *diskCacheStorageMiddleware) localPath(path string) string {
+	return filepath.Join(d.root, filepath.FromSlash(path))
+}
+
+// load indexes the data cached by a previous run, in the order of their
+// modification, and removes the files left being written.
+func (d *diskCacheStorageMiddleware) load() error {
+	type file struct {
+		entry
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(d.root, func(local string, de fs.DirEntry, err error) error {
+		if err != nil || de.IsDir() {
+			return err
+		}
+		if filepath.Dir(local) == filepath.Clean(d.root) && strings.HasPrefix(de.Name(), tempPrefix) {
+			return os.Remove(local)
+		}
+		rel, err := filepath.Rel(d.root, local)
+		if err != nil {
+			return err
+		}
+		path := "/" + filepath.ToSlash(rel)
+		if !cacheable(path) {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{entry: entry{path: path, size: fi.Size()}, modTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range files {
+		d.entries[f.path] = d.lru.PushFront(&f.entry)
+		d.size += f.size
+	}
+	d.evict()
+	return nil
+}
+
+// open returns the cached data of the path, if any.
+func (d *diskCacheStorageMiddleware) open(path string) (*os.File, bool) {
+	d.mu.Lock()
+	e, ok := d.entries[path]
+	if ok {
+		d.lru.MoveToFront(e)
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	f, err := os.Open(d.localPath(path))
+	if err != nil {
+		d.remove(path)
+		return nil, false
+	}
+	return f, true
+}
+
+// add moves the temporary file holding the data of the path in the cache.
+func (d *diskCacheStorageMiddleware) add(tmp, path string, size int64) error {
+	if size > d.maxSize {
+		return os.Remove(tmp)
+	}
+	local := d.localPath(path)
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.Rename(tmp, local); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if e, ok := d.entries[path]; ok {
+		d.size -= e.Value.(*entry).size
+		d.lru.Remove(e)
+	}
+	d.entries[path] = d.lru.PushFront(&entry{path: path, size: size})
+	d.size += size
+	d.evict()
+	return nil
+}
+
+// store caches the content of the path.
+func (d *diskCacheStorageMiddleware) store(path string, content []byte) error {
+	if int64(len(content)) > d.maxSize {
+		return nil
+	}
+	f, err := os.CreateTemp(d.root, tempPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return d.add(f.Name(), path, int64(len(content)))
+}
+
+// evict removes the least recently used data until the cache fits in its
+// size. It must be called with the lock held.
+func (d *diskCacheStorageMiddleware) evict() {
+	for d.size > d.maxSize {
+		e := d.lru.Back()
+		d.removeEntry(e)
+		diskCacheEvictionCount.Inc(1)
+	}
+	diskCacheSize.Set(float64(d.size))
+}
+
+// removeEntry removes the cached data of the entry. It must be called with
+// the lock held.
+func (d *diskCacheStorageMiddleware) removeEntry(e *list.Element) {
+	ent := e.Value.(*entry)
+	d.lru.Remove(e)
+	delete(d.entries, ent.path)
+	d.size -= ent.size
+	os.Remove(d.localPath(ent.path))
+}
+
+// remove removes the cached data of the path and of its subpaths.
+func (d *diskCacheStorageMiddleware) remove(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[path]; ok {
+		d.removeEntry(e)
+	}
+	if strings.HasPrefix(blobsRoot, path) || strings.HasPrefix(path, blobsRoot) {
+		prefix := strings.TrimSuffix(path, "/") + "/"
+		for p, e := range d.entries {
+			if strings.HasPrefix(p, prefix) {
+				d.removeEntry(e)
+			}
+		}
+	}
+	diskCacheSize.Set(float64(d.size))
+}
+
+func (d *diskCacheStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if !cacheable(path) {
+		return d.StorageDriver.GetContent(ctx, path)
+	}
+
+	diskCacheRequestCount.Inc(1)
+	if f, ok := d.open(path); ok {
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err == nil {
+			diskCacheHitCount.Inc(1)
+			return content, nil
+		}
+	}
+
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.store(path, content); err != nil {
+		dcontext.GetLogger(ctx).Errorf("unable to cache %s: %v", path, err)
+	}
+	return content, nil
+}
+
+func (d *diskCacheStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !cacheable(path) || offset < 0 {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	diskCacheRequestCount.Inc(1)
+	if f, ok := d.open(path); ok {
+		fi, err := f.Stat()
+		if err == nil && offset > fi.Size() {
+			f.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+		}
+		if err == nil {
+			_, err = f.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			diskCacheHitCount.Inc(1)
+			return f, nil
+		}
+		f.Close()
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || offset > 0 {
+		// only the data read entirely is cached
+		return rc, err
+	}
+	f, err := os.CreateTemp(d.root, tempPrefix)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("unable to cache %s: %v", path, err)
+		return rc, nil
+	}
+	return &cachingReader{ReadCloser: rc, ctx: ctx, driver: d, path: path, file: f}, nil
+}
+
+func (d *diskCacheStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	defer d.remove(path)
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (d *diskCacheStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	d.remove(path)
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+func (d *diskCacheStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	defer d.remove(sourcePath)
+	defer d.remove(destPath)
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (d *diskCacheStorageMiddleware) Delete(ctx context.Context, path string) error {
+	defer d.remove(path)
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+// RedirectURL disables the redirects to the blob data, which the clients
+// would otherwise fetch from the wrapped driver instead of the cache.
+func (d *diskCacheStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	if cacheable(path) {
+		return "", nil
+	}
+	return d.StorageDriver.RedirectURL(r, path)
+}
+
+// cachingReader copies the data read from the wrapped driver to a temporary
+// file, added to the cache once the data is read entirely.
+type cachingReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	driver *diskCacheStorageMiddleware
+	path   string
+	file   *os.File
+	size   int64
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.file == nil {
+		return n, err
+	}
+
+	if n > 0 {
+		r.size += int64(n)
+		if r.size > r.driver.maxSize {
+			r.abort()
+			return n, err
+		}
+		if _, werr := r.file.Write(p[:n]); werr != nil {
+			dcontext.GetLogger(r.ctx).Errorf("unable to cache %s: %v", r.path, werr)
+			r.abort()
+			return n, err
+		}
+	}
+	if err == io.EOF {
+		name := r.file.Name()
+		cerr := r.file.Close()
+		r.file = nil
+		if cerr == nil {
+			cerr = r.driver.add(name, r.path, r.size)
+		} else {
+			os.Remove(name)
+		}
+		if cerr != nil {
+			dcontext.GetLogger(r.ctx).Errorf("unable to cache %s: %v", r.path, cerr)
+		}
+	}
+	return n, err
+}
+
+// abort removes the temporary file.
+func (r *cachingReader) abort() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+	r.file = nil
+}
+
+func (r *cachingReader) Close() error {
+	if r.file != nil {
+		r.abort()
+	}
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/stretchr/testify/require"
+)
+
+func blobPath(hex string) string {
+	return blobsRoot + "sha256/" + hex[:2] + "/" + hex + "/data"
+}
+
+func newDiskCache(t *testing.T, sd storagedriver.StorageDriver, root string, maxSize int) *diskCacheStorageMiddleware {
+	d, err := newDiskCacheStorageMiddleware(context.Background(), sd, map[string]interface{}{
+		"path":    root,
+		"maxsize": maxSize,
+	})
+	require.NoError(t, err)
+	return d.(*diskCacheStorageMiddleware)
+}
+
+func TestNoConfig(t *testing.T) {
+	_, err := newDiskCacheStorageMiddleware(context.Background(), nil, map[string]interface{}{})
+	require.ErrorContains(t, err, "no path provided")
+
+	_, err = newDiskCacheStorageMiddleware(context.Background(), nil, map[string]interface{}{
+		"path":    t.TempDir(),
+		"maxsize": "large",
+	})
+	require.ErrorContains(t, err, "maxsize must be an integer")
+}
+
+func TestCachedBlobs(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newDiskCache(t, backend, t.TempDir(), 1024)
+
+	blob := blobPath("aaaa")
+	link := "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+	require.NoError(t, backend.PutContent(ctx, blob, []byte("blob data")))
+	require.NoError(t, backend.PutContent(ctx, link, []byte("sha256:aaaa")))
+
+	r, err := d.Reader(ctx, blob, 0)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "blob data", string(content))
+
+	_, err = d.GetContent(ctx, link)
+	require.NoError(t, err)
+
+	// the blob data is served locally, the link files by the backend
+	require.NoError(t, backend.Delete(ctx, "/docker"))
+
+	content, err = d.GetContent(ctx, blob)
+	require.NoError(t, err)
+	require.Equal(t, "blob data", string(content))
+
+	r, err = d.Reader(ctx, blob, 5)
+	require.NoError(t, err)
+	content, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "data", string(content))
+
+	_, err = d.Reader(ctx, blob, 10)
+	require.ErrorAs(t, err, new(storagedriver.InvalidOffsetError))
+
+	_, err = d.GetContent(ctx, link)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+
+	// the data deleted through the middleware is evicted
+	require.Error(t, d.Delete(ctx, blobsRoot+"sha256/aa"))
+	_, err = d.GetContent(ctx, blob)
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+func TestPartialReadsNotCached(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newDiskCache(t, backend, t.TempDir(), 1024)
+
+	blob := blobPath("aaaa")
+	require.NoError(t, backend.PutContent(ctx, blob, []byte("blob data")))
+
+	r, err := d.Reader(ctx, blob, 5)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	// closed before the end of the data
+	r, err = d.Reader(ctx, blob, 0)
+	require.NoError(t, err)
+	_, err = r.Read(make([]byte, 4))
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	require.Empty(t, d.entries)
+	require.Zero(t, d.size)
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	root := t.TempDir()
+	d := newDiskCache(t, backend, root, 20)
+
+	for _, hex := range []string{"aaaa", "bbbb", "cccc"} {
+		require.NoError(t, backend.PutContent(ctx, blobPath(hex), []byte("0123456789")))
+	}
+	require.NoError(t, backend.PutContent(ctx, blobPath("dddd"), []byte("too large to be cached")))
+
+	for _, hex := range []string{"aaaa", "bbbb", "aaaa", "cccc", "dddd"} {
+		_, err := d.GetContent(ctx, blobPath(hex))
+		require.NoError(t, err)
+	}
+
+	// bbbb is the least recently used
+	require.Len(t, d.entries, 2)
+	require.Contains(t, d.entries, blobPath("aaaa"))
+	require.Contains(t, d.entries, blobPath("cccc"))
+	require.Equal(t, int64(20), d.size)
+
+	// the cached data is loaded on restart
+	d = newDiskCache(t, backend, root, 10)
+	require.Len(t, d.entries, 1)
+	require.Equal(t, int64(10), d.size)
+}
+
+type redirectingDriver struct {
+	storagedriver.StorageDriver
+}
+
+func (d redirectingDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	return "https://storage.example.com" + path, nil
+}
+
+func TestRedirectURL(t *testing.T) {
+	d := newDiskCache(t, redirectingDriver{inmemory.New()}, t.TempDir(), 1024)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	url, err := d.RedirectURL(r, blobPath("abcdef"))
+	require.NoError(t, err)
+	require.Empty(t, url, "blob data must be read through the cache")
+
+	url, err = d.RedirectURL(r, "/docker/registry/v2/repositories/foo/_uploads/id/data")
+	require.NoError(t, err)
+	require.Equal(t, "https://storage.example.com/docker/registry/v2/repositories/foo/_uploads/id/data", url)
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The objects are stored as a header followed by frames, each holding a chunk
+// of the content sealed with AES-GCM:
+//
+//	header: magic (4) | version (1) | key ID length (1) | key ID, zero padded (58)
+//	frame:  nonce (12) | ciphertext (up to chunkSize) | tag (16)
+//
+// Every frame but the last holds a full chunk, so that the frame holding a
+// plaintext offset is found without reading the object. The last frame, which
+// may be empty, is always written. The header, the index of the frame and
+// whether it is the last one are authenticated with each frame, preventing
+// frames from being reordered, moved between objects or dropped from the end
+// of the object.
+const (
+	headerSize     = 64
+	maxKeyIDLength = headerSize - 6
+	formatVersion  = 1
+
+	chunkSize = 64 << 10
+	nonceSize = 12
+	tagSize   = 16
+	overhead  = nonceSize + tagSize
+	frameSize = chunkSize + overhead
+)
+
+var magic = [4]byte{'D', 'E', 'N', 'C'}
+
+// errMalformed is returned when an object was not written by the middleware
+// or was corrupted.
+var errMalformed = errors.New("encrypt: malformed object")
+
+// errTruncated is returned when an object ends before its last frame.
+var errTruncated = fmt.Errorf("encrypt: truncated object: %w", io.ErrUnexpectedEOF)
+
+// newHeader returns the header of an object encrypted with the key.
+func newHeader(keyID string) []byte {
+	header := make([]byte, headerSize)
+	copy(header, magic[:])
+	header[4] = formatVersion
+	header[5] = byte(len(keyID))
+	copy(header[6:], keyID)
+	return header
+}
+
+// parseHeader returns the ID of the key encrypting the object.
+func parseHeader(header []byte) (string, error) {
+	if len(header) < headerSize || [4]byte(header[:4]) != magic {
+		return "", errMalformed
+	}
+	if header[4] != formatVersion {
+		return "", fmt.Errorf("encrypt: unsupported format version %d", header[4])
+	}
+	n := int(header[5])
+	if n == 0 || n > maxKeyIDLength {
+		return "", errMalformed
+	}
+	return string(header[6 : 6+n]), nil
+}
+
+// plaintextSize returns the size of the content of an object of the size.
+func plaintextSize(size int64) int64 {
+	size -= headerSize
+	if size <= 0 {
+		return 0
+	}
+	n := size / frameSize * chunkSize
+	if rem := size % frameSize; rem > overhead {
+		n += rem - overhead
+	}
+	return n
+}
+
+// frameOffset returns the offset of the frame of the index.
+func frameOffset(index uint64) int64 {
+	return headerSize + int64(index)*frameSize
+}
+
+// additionalData returns the data authenticated with the frame of the index.
+func additionalData(header []byte, index uint64, last bool) []byte {
+	data := binary.BigEndian.AppendUint64(append([]byte(nil), header[:headerSize]...), index)
+	if last {
+		return append(data, 1)
+	}
+	return append(data, 0)
+}
+
+// seal appends the frame of the chunk to dst.
+func seal(dst []byte, aead cipher.AEAD, header []byte, index uint64, chunk []byte, last bool) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, chunk, additionalData(header, index, last)), nil
+}
+
+// open returns the chunk of the frame and whether the frame is the last one
+// of the object. Only a full frame may be followed by other frames.
+func open(aead cipher.AEAD, header []byte, index uint64, frame []byte) ([]byte, bool, error) {
+	if len(frame) < overhead {
+		return nil, false, errMalformed
+	}
+	nonce, sealed := frame[:nonceSize], frame[nonceSize:]
+	if len(frame) == frameSize {
+		if chunk, err := aead.Open(nil, nonce, sealed, additionalData(header, index, false)); err == nil {
+			return chunk, false, nil
+		}
+	}
+	chunk, err := aead.Open(nil, nonce, sealed, additionalData(header, index, true))
+	if err != nil {
+		return nil, false, fmt.Errorf("encrypt: unable to decrypt chunk %d: %w", index, err)
+	}
+	return chunk, true, nil
+}
+
+// reader decrypts the frames of an object, starting with the frame of the
+// index.
+type reader struct {
+	rc     io.ReadCloser
+	aead   cipher.AEAD
+	header []byte
+	index  uint64
+	frame  []byte
+	chunk  []byte
+	err    error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// next decrypts the next frame, returning io.EOF after the last frame.
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.rc, r.frame)
+	switch {
+	case errors.Is(err, io.EOF):
+		// the object ends before its last frame
+		return errTruncated
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// a frame shorter than the others can only be the last one
+	case err != nil:
+		return err
+	}
+
+	var last bool
+	r.chunk, last, err = open(r.aead, r.header, r.index, r.frame[:n])
+	if err != nil {
+		return err
+	}
+	r.index++
+	if last {
+		return io.EOF
+	}
+	return nil
+}
+
+func (r *reader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,395 @@
+// Package middleware provides a storage middleware encrypting the content
+// stored by the wrapped driver with AES-GCM.
+package middleware
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	if err := storagemiddleware.Register("encrypt", newEncryptStorageMiddleware); err != nil {
+		logrus.Errorf("failed to register encrypt storage middleware: %v", err)
+	}
+}
+
+// partialSuffix is the suffix of the paths at which the partial chunks of
+// the writers closed before being committed are stored.
+const partialSuffix = ".encrypt-partial"
+
+// encryptStorageMiddleware encrypts the content written with the current key,
+// and decrypts the content read with the key named in its header.
+type encryptStorageMiddleware struct {
+	storagedriver.StorageDriver
+	keys  map[string]cipher.AEAD
+	keyID string
+}
+
+var _ storagedriver.StorageDriver = &encryptStorageMiddleware{}
+
+// keyFile is the file of the keys, encoded in base64.
+type keyFile struct {
+	Keys []struct {
+		ID  string `yaml:"id"`
+		Key string `yaml:"key"`
+	} `yaml:"keys"`
+}
+
+func getStringOption(key string, options map[string]interface{}) (string, error) {
+	o, ok := options[key]
+	if !ok {
+		return "", nil
+	}
+	s, ok := o.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func newEncryptStorageMiddleware(ctx context.Context, sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	keyFilePath, err := getStringOption("keyfile", options)
+	if err != nil {
+		return nil, err
+	}
+	if keyFilePath == "" {
+		return nil, fmt.Errorf("no keyfile provided")
+	}
+	keyID, err := getStringOption("keyid", options)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := loadKeys(keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("keyid must be set when the keyfile holds several keys")
+		}
+		for id := range keys {
+			keyID = id
+		}
+	}
+	if _, ok := keys[keyID]; !ok {
+		return nil, fmt.Errorf("key %q not found in %s", keyID, keyFilePath)
+	}
+
+	return &encryptStorageMiddleware{
+		StorageDriver: sd,
+		keys:          keys,
+		keyID:         keyID,
+	}, nil
+}
+
+// loadKeys reads the keys of the file, each of 16, 24 or 32 bytes selecting
+// AES-128, AES-192 or AES-256.
+func loadKeys(path string) (map[string]cipher.AEAD, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keyfile: %v", err)
+	}
+	var f keyFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse keyfile %s: %v", path, err)
+	}
+	if len(f.Keys) == 0 {
+		return nil, fmt.Errorf("no keys in keyfile %s", path)
+	}
+
+	keys := make(map[string]cipher.AEAD, len(f.Keys))
+	for _, k := range f.Keys {
+		if k.ID == "" || len(k.ID) > maxKeyIDLength {
+			return nil, fmt.Errorf("invalid key id %q: must have between 1 and %d bytes", k.ID, maxKeyIDLength)
+		}
+		if _, ok := keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", k.ID, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %v", k.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keys[k.ID] = aead
+	}
+	return keys, nil
+}
+
+// partialPath returns the path at which the partial chunk of the writer of
+// the path is stored.
+func partialPath(path string) string {
+	return path + partialSuffix
+}
+
+// aead returns the cipher of the key named in the header.
+func (d *encryptStorageMiddleware) aead(header []byte) (cipher.AEAD, error) {
+	keyID, err := parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := d.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("encrypt: unknown key %q", keyID)
+	}
+	return aead, nil
+}
+
+func (d *encryptStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	data, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := d.aead(data)
+	if err != nil {
+		return nil, err
+	}
+	header := data[:headerSize]
+	content := make([]byte, 0, plaintextSize(int64(len(data))))
+	data = data[headerSize:]
+	for index := uint64(0); ; index++ {
+		if len(data) == 0 {
+			return nil, errTruncated
+		}
+		frame := data[:min(frameSize, len(data))]
+		chunk, last, err := open(aead, header, index, frame)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, chunk...)
+		data = data[len(frame):]
+		if last {
+			if len(data) > 0 {
+				return nil, errMalformed
+			}
+			return content, nil
+		}
+	}
+}
+
+func (d *encryptStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	header := newHeader(d.keyID)
+	aead := d.keys[d.keyID]
+	data := append(make([]byte, 0, headerSize+len(content)/chunkSize*frameSize+frameSize), header...)
+	for index := uint64(0); ; index++ {
+		chunk := content[:min(chunkSize, len(content))]
+		content = content[len(chunk):]
+		last := len(content) == 0
+		var err error
+		if data, err = seal(data, aead, header, index, chunk, last); err != nil {
+			return err
+		}
+		if last {
+			break
+		}
+	}
+	return d.StorageDriver.PutContent(ctx, path, data)
+}
+
+func (d *encryptStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(rc, header); err != nil {
+		rc.Close()
+		return nil, errMalformed
+	}
+	aead, err := d.aead(header)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	// the object is read from the frame holding the byte before the offset,
+	// skipping the frames before it, so that a read from the end of the
+	// content still checks that the content ends there
+	var index uint64
+	var skip int
+	if offset > 0 {
+		index = uint64((offset - 1) / chunkSize)
+		skip = int(offset - int64(index)*chunkSize)
+	}
+	if index > 0 {
+		rc.Close()
+		rc, err = d.StorageDriver.Reader(ctx, path, frameOffset(index))
+		if err != nil {
+			if _, ok := err.(storagedriver.InvalidOffsetError); ok {
+				return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+			}
+			return nil, err
+		}
+	}
+
+	r := &reader{
+		rc:     rc,
+		aead:   aead,
+		header: header,
+		index:  index,
+		frame:  make([]byte, frameSize),
+	}
+	if skip > 0 {
+		r.err = r.next()
+		if r.err == errTruncated {
+			// there is no content at the offset
+			rc.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+		}
+		if r.err != nil && r.err != io.EOF {
+			rc.Close()
+			return nil, r.err
+		}
+		if len(r.chunk) < skip {
+			rc.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+		}
+		r.chunk = r.chunk[skip:]
+	}
+	return r, nil
+}
+
+// Writer returns a writer encrypting the content with the current key, or
+// with the key of the content written so far when resuming a writer.
+func (d *encryptStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if append {
+		state, err := d.GetContent(ctx, partialPath(path))
+		switch err.(type) {
+		case nil:
+			return d.resume(ctx, path, state)
+		case storagedriver.PathNotFoundError:
+			// only empty committed content can be appended to, by
+			// replacing it
+			fi, err := d.StorageDriver.Stat(ctx, path)
+			if err == nil && !fi.IsDir() && plaintextSize(fi.Size()) > 0 {
+				return nil, fmt.Errorf("encrypt: unable to append to the committed content of %s", path)
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	fw, err := d.StorageDriver.Writer(ctx, path, false)
+	if err != nil {
+		return nil, err
+	}
+	header := newHeader(d.keyID)
+	if _, err := fw.Write(header); err != nil {
+		fw.Cancel(ctx)
+		return nil, err
+	}
+	return &writer{
+		driver: d,
+		ctx:    ctx,
+		fw:     fw,
+		path:   path,
+		aead:   d.keys[d.keyID],
+		header: header,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// resume resumes the writer of the path from its stored state, holding the
+// header of the object and the partial chunk.
+func (d *encryptStorageMiddleware) resume(ctx context.Context, path string, state []byte) (storagedriver.FileWriter, error) {
+	aead, err := d.aead(state)
+	if err != nil {
+		return nil, err
+	}
+	fw, err := d.StorageDriver.Writer(ctx, path, true)
+	if err != nil {
+		return nil, err
+	}
+	size := fw.Size() - headerSize
+	if size < 0 || size%frameSize != 0 {
+		fw.Close()
+		return nil, fmt.Errorf("encrypt: unable to resume the writer of %s: unexpected size %d", path, fw.Size())
+	}
+
+	return &writer{
+		driver:  d,
+		ctx:     ctx,
+		fw:      fw,
+		path:    path,
+		aead:    aead,
+		header:  state[:headerSize],
+		index:   uint64(size / frameSize),
+		size:    size / frameSize * chunkSize,
+		buf:     append(make([]byte, 0, chunkSize), state[headerSize:]...),
+		partial: true,
+	}, nil
+}
+
+func (d *encryptStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return plaintextFileInfo(fi), nil
+}
+
+func (d *encryptStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	children, err := d.StorageDriver.List(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	filtered := children[:0]
+	for _, child := range children {
+		if !strings.HasSuffix(child, partialSuffix) {
+			filtered = append(filtered, child)
+		}
+	}
+	return filtered, nil
+}
+
+func (d *encryptStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return d.StorageDriver.Walk(ctx, path, func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() && strings.HasSuffix(fi.Path(), partialSuffix) {
+			return nil
+		}
+		return f(plaintextFileInfo(fi))
+	}, options...)
+}
+
+// RedirectURL disables the redirects, as the clients would be served the
+// encrypted content.
+func (d *encryptStorageMiddleware) RedirectURL(r *http.Request, path string) (string, error) {
+	return "", nil
+}
+
+// plaintextFileInfo returns the file info reporting the size of the content
+// of a file.
+func plaintextFileInfo(fi storagedriver.FileInfo) storagedriver.FileInfo {
+	if fi.IsDir() {
+		return fi
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    plaintextSize(fi.Size()),
+		ModTime: fi.ModTime(),
+	}}
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyFile writes a file of keys of the ids, each filled with its first
+// byte.
+func writeKeyFile(t *testing.T, ids ...string) string {
+	var b strings.Builder
+	b.WriteString("keys:\n")
+	for _, id := range ids {
+		b.WriteString("  - id: " + id + "\n")
+		b.WriteString("    key: " + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{id[0]}, 32)) + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "keys.yml")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o600))
+	return path
+}
+
+func newEncryptDriver(t *testing.T, sd storagedriver.StorageDriver, keyFile, keyID string) storagedriver.StorageDriver {
+	d, err := newEncryptStorageMiddleware(context.Background(), sd, map[string]interface{}{
+		"keyfile": keyFile,
+		"keyid":   keyID,
+	})
+	require.NoError(t, err)
+	return d
+}
+
+func TestEncryptDriverSuite(t *testing.T) {
+	keyFile := writeKeyFile(t, "first")
+	testsuites.Driver(t, func() (storagedriver.StorageDriver, error) {
+		return newEncryptStorageMiddleware(context.Background(), inmemory.New(), map[string]interface{}{
+			"keyfile": keyFile,
+		})
+	}, false)
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := newEncryptStorageMiddleware(ctx, nil, map[string]interface{}{})
+	require.ErrorContains(t, err, "no keyfile provided")
+
+	keyFile := writeKeyFile(t, "first", "second")
+	_, err = newEncryptStorageMiddleware(ctx, nil, map[string]interface{}{"keyfile": keyFile})
+	require.ErrorContains(t, err, "keyid must be set")
+
+	_, err = newEncryptStorageMiddleware(ctx, nil, map[string]interface{}{"keyfile": keyFile, "keyid": "third"})
+	require.ErrorContains(t, err, `key "third" not found`)
+
+	invalid := filepath.Join(t.TempDir(), "keys.yml")
+	require.NoError(t, os.WriteFile(invalid, []byte("keys:\n  - id: short\n    key: c2hvcnQ=\n"), 0o600))
+	_, err = newEncryptStorageMiddleware(ctx, nil, map[string]interface{}{"keyfile": invalid})
+	require.ErrorContains(t, err, `invalid key "short"`)
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newEncryptDriver(t, backend, writeKeyFile(t, "first"), "")
+
+	content := bytes.Repeat([]byte("plaintext"), chunkSize/4)
+	require.NoError(t, d.PutContent(ctx, "/blob", content))
+
+	stored, err := backend.GetContent(ctx, "/blob")
+	require.NoError(t, err)
+	require.NotContains(t, string(stored), "plaintext")
+
+	fi, err := d.Stat(ctx, "/blob")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), fi.Size())
+
+	// tampering with a frame is detected
+	stored[len(stored)-1] ^= 1
+	require.NoError(t, backend.PutContent(ctx, "/blob", stored))
+	_, err = d.GetContent(ctx, "/blob")
+	require.ErrorContains(t, err, "unable to decrypt chunk 2")
+
+	url, err := d.RedirectURL(nil, "/blob")
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
+
+func TestTruncation(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newEncryptDriver(t, backend, writeKeyFile(t, "first"), "")
+
+	for _, size := range []int{0, 100, chunkSize, 2*chunkSize + 100} {
+		content := bytes.Repeat([]byte{'a'}, size)
+		require.NoError(t, d.PutContent(ctx, "/blob", content))
+		stored, err := backend.GetContent(ctx, "/blob")
+		require.NoError(t, err)
+
+		// the object is truncated to its full frames, dropping the last one
+		truncated := stored[:headerSize+(len(stored)-headerSize-1)/frameSize*frameSize]
+		require.NoError(t, backend.PutContent(ctx, "/blob", truncated))
+
+		_, err = d.GetContent(ctx, "/blob")
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, "size %d", size)
+
+		r, err := d.Reader(ctx, "/blob", 0)
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, "size %d", size)
+		require.NoError(t, r.Close())
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	keyFile := writeKeyFile(t, "first", "second")
+
+	first := newEncryptDriver(t, backend, keyFile, "first")
+	require.NoError(t, first.PutContent(ctx, "/old", []byte("old content")))
+
+	// the writer resumed after the rotation keeps the key of its content
+	w, err := first.Writer(ctx, "/upload", false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("uploaded "))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	second := newEncryptDriver(t, backend, keyFile, "second")
+	require.NoError(t, second.PutContent(ctx, "/new", []byte("new content")))
+
+	w, err = second.Writer(ctx, "/upload", true)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), w.Size())
+	_, err = w.Write([]byte("content"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+
+	for path, want := range map[string]string{
+		"/old":    "old content",
+		"/new":    "new content",
+		"/upload": "uploaded content",
+	} {
+		content, err := second.GetContent(ctx, path)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+	}
+
+	stored, err := backend.GetContent(ctx, "/upload")
+	require.NoError(t, err)
+	keyID, err := parseHeader(stored)
+	require.NoError(t, err)
+	require.Equal(t, "first", keyID)
+
+	// the partial chunk is removed when the writer is committed
+	_, err = backend.Stat(ctx, partialPath("/upload"))
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+
+	// the objects encrypted with a removed key cannot be read
+	rotated := newEncryptDriver(t, backend, writeKeyFile(t, "second"), "")
+	_, err = rotated.GetContent(ctx, "/old")
+	require.ErrorContains(t, err, `unknown key "first"`)
+}
+
+func TestReaderOffsets(t *testing.T) {
+	ctx := context.Background()
+	d := newEncryptDriver(t, inmemory.New(), writeKeyFile(t, "first"), "")
+
+	content := make([]byte, 3*chunkSize+100)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	// written across resumed writers, splitting the chunks
+	parts := [][]byte{content[:1000], content[1000 : 2*chunkSize+5], content[2*chunkSize+5:]}
+	var written int64
+	for i, part := range parts {
+		w, err := d.Writer(ctx, "/blob", i > 0)
+		require.NoError(t, err)
+		require.Equal(t, written, w.Size())
+		_, err = w.Write(part)
+		require.NoError(t, err)
+		written += int64(len(part))
+
+		if i < len(parts)-1 {
+			require.NoError(t, w.Close())
+
+			// the partial chunk is hidden
+			list, err := d.List(ctx, "/")
+			require.NoError(t, err)
+			require.Equal(t, []string{"/blob"}, list)
+			continue
+		}
+		require.NoError(t, w.Commit(ctx))
+		require.NoError(t, w.Close())
+	}
+
+	for _, offset := range []int64{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 1, int64(len(content))} {
+		r, err := d.Reader(ctx, "/blob", offset)
+		require.NoError(t, err)
+		read, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content[offset:], read, "offset %d", offset)
+	}
+
+	_, err := d.Reader(ctx, "/blob", int64(len(content))+1)
+	require.ErrorAs(t, err, new(storagedriver.InvalidOffsetError))
+}
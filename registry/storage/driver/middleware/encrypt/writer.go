@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// writer encrypts the content written to it in chunks, keeping the partial
+// chunk aside when closed before being committed, as the frames written
+// cannot be rewritten when the writer is resumed.
+type writer struct {
+	driver *encryptStorageMiddleware
+	ctx    context.Context
+	fw     storagedriver.FileWriter
+	path   string
+	aead   cipher.AEAD
+	header []byte
+	index  uint64
+	size   int64
+	buf    []byte
+	frame  []byte
+
+	// partial is set when the partial chunk may be stored.
+	partial   bool
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+var _ storagedriver.FileWriter = &writer{}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		// a full chunk is only flushed once followed by more content, as
+		// the last chunk is sealed as such on commit
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		m := min(chunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// flush writes the frame of the buffered chunk, the last one of the object
+// if last is set.
+func (w *writer) flush(last bool) error {
+	var err error
+	w.frame, err = seal(w.frame[:0], w.aead, w.header, w.index, w.buf, last)
+	if err != nil {
+		return err
+	}
+	if _, err := w.fw.Write(w.frame); err != nil {
+		return err
+	}
+	w.index++
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Size() int64 {
+	return w.size + int64(len(w.buf))
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		state := append(append([]byte(nil), w.header...), w.buf...)
+		if err := w.driver.PutContent(w.ctx, partialPath(w.path), state); err != nil {
+			w.fw.Close()
+			return err
+		}
+	}
+	return w.fw.Close()
+}
+
+func (w *writer) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+
+	if err := w.fw.Cancel(ctx); err != nil {
+		return err
+	}
+	return w.deletePartial(ctx)
+}
+
+func (w *writer) Commit(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	if err := w.fw.Commit(ctx); err != nil {
+		return err
+	}
+	w.committed = true
+	return w.deletePartial(ctx)
+}
+
+// deletePartial deletes the stored partial chunk, if any.
+func (w *writer) deletePartial(ctx context.Context) error {
+	if !w.partial {
+		return nil
+	}
+	err := w.driver.StorageDriver.Delete(ctx, partialPath(w.path))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,382 @@
+// Package mirror provides a storage driver writing to two storage drivers,
+// such as to migrate the content of a registry between storage services or to
+// keep a copy of it in another region.
+//
+// The writes and the deletes go to the primary and to the secondary driver,
+// while the reads go to the primary driver, falling back to the secondary. The
+// primary driver is the reference: its errors are returned, while the errors
+// of the secondary driver and the content found in only one of the drivers are
+// logged and counted as divergences.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+const driverName = "mirror"
+
+// divergenceCount is the number of actions on which the drivers diverged.
+var divergenceCount = prometheus.StorageNamespace.NewLabeledCounter("mirror_divergences", "The number of actions on which the mirrored storage drivers diverged", "action")
+
+func init() {
+	factory.Register(driverName, &mirrorDriverFactory{})
+}
+
+// mirrorDriverFactory implements the factory.StorageDriverFactory interface.
+type mirrorDriverFactory struct{}
+
+func (factory *mirrorDriverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(ctx, parameters)
+}
+
+type driver struct {
+	primary   storagedriver.StorageDriver
+	secondary storagedriver.StorageDriver
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation mirroring the
+// content of a primary driver to a secondary driver.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map.
+// Required parameters:
+// - primary
+// - secondary
+//
+// Each holds the name of a storage driver mapped to its parameters, as the
+// storage section of the configuration does.
+func FromParameters(ctx context.Context, parameters map[string]interface{}) (*Driver, error) {
+	primary, err := createDriver(ctx, parameters, "primary")
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := createDriver(ctx, parameters, "secondary")
+	if err != nil {
+		return nil, err
+	}
+	return New(primary, secondary), nil
+}
+
+// createDriver creates the driver configured by the parameter.
+func createDriver(ctx context.Context, parameters map[string]interface{}, key string) (storagedriver.StorageDriver, error) {
+	v, ok := parameters[key]
+	if !ok || v == nil {
+		return nil, fmt.Errorf("no %s storage driver provided", key)
+	}
+
+	var (
+		name   string
+		params map[string]interface{}
+	)
+	if s, ok := v.(string); ok {
+		name = s
+	} else {
+		m, ok := toParameters(v)
+		if !ok || len(m) != 1 {
+			return nil, fmt.Errorf("%s must configure exactly one storage driver", key)
+		}
+		for k, p := range m {
+			name = k
+			if params, ok = toParameters(p); p != nil && !ok {
+				return nil, fmt.Errorf("the parameters of the %s storage driver must be a map", key)
+			}
+		}
+	}
+
+	if name == driverName {
+		return nil, fmt.Errorf("%s storage driver must not be a mirror", key)
+	}
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	d, err := factory.Create(ctx, name, params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s storage driver: %v", key, err)
+	}
+	return d, nil
+}
+
+// toParameters returns the map decoded with string or interface keys as
+// parameters.
+func toParameters(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		params := make(map[string]interface{}, len(m))
+		for k, p := range m {
+			params[fmt.Sprint(k)] = p
+		}
+		return params, true
+	}
+	return nil, false
+}
+
+// New constructs a new Driver mirroring the primary driver to the secondary
+// driver.
+func New(primary, secondary storagedriver.StorageDriver) *Driver {
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{
+					primary:   primary,
+					secondary: secondary,
+				},
+			},
+		},
+	}
+}
+
+// Implement the storagedriver.StorageDriver interface.
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// diverged logs and counts the divergence of the drivers on the action.
+func diverged(ctx context.Context, action, path string, err error) {
+	divergenceCount.WithValues(action).Inc(1)
+	dcontext.GetLogger(ctx).Warnf("mirror: %s %s diverged: %v", action, path, err)
+}
+
+// isPathNotFound returns true if the error reports a missing path.
+func isPathNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
+
+// fallback returns true if the read failing on the primary driver with the
+// error is retried on the secondary driver.
+func fallback(err error) bool {
+	switch err.(type) {
+	case nil, storagedriver.InvalidPathError, storagedriver.InvalidOffsetError:
+		return false
+	}
+	return true
+}
+
+// read reads from the primary driver, falling back to the secondary driver.
+func read[T any](ctx context.Context, action, path string, d *driver, f func(storagedriver.StorageDriver) (T, error)) (T, error) {
+	v, err := f(d.primary)
+	if !fallback(err) {
+		return v, err
+	}
+	sv, serr := f(d.secondary)
+	if serr != nil {
+		return v, err
+	}
+	diverged(ctx, action, path, fmt.Errorf("served by the secondary: %w", err))
+	return sv, nil
+}
+
+// write writes to the primary driver, then to the secondary driver if it
+// succeeded.
+func write(ctx context.Context, action, path string, d *driver, f func(storagedriver.StorageDriver) error) error {
+	if err := f(d.primary); err != nil {
+		return err
+	}
+	if err := f(d.secondary); err != nil {
+		diverged(ctx, action, path, fmt.Errorf("secondary: %w", err))
+	}
+	return nil
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	return read(ctx, "GetContent", path, d, func(sd storagedriver.StorageDriver) ([]byte, error) {
+		return sd.GetContent(ctx, path)
+	})
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
+	return write(ctx, "PutContent", path, d, func(sd storagedriver.StorageDriver) error {
+		return sd.PutContent(ctx, path, contents)
+	})
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return read(ctx, "Reader", path, d, func(sd storagedriver.StorageDriver) (io.ReadCloser, error) {
+		return sd.Reader(ctx, path, offset)
+	})
+}
+
+// Writer returns a FileWriter which will store the content written to it
+// at the location designated by "path" after the call to Commit.
+func (d *driver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	pw, err := d.primary.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{ctx: ctx, path: path, primary: pw}
+
+	sw, err := d.secondary.Writer(ctx, path, append)
+	switch {
+	case err != nil:
+		diverged(ctx, "Writer", path, fmt.Errorf("secondary: %w", err))
+	case sw.Size() != pw.Size():
+		diverged(ctx, "Writer", path, fmt.Errorf("secondary has %d bytes, primary has %d bytes", sw.Size(), pw.Size()))
+		sw.Cancel(ctx)
+		sw.Close()
+	default:
+		w.secondary = sw
+	}
+	return w, nil
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	return read(ctx, "Stat", path, d, func(sd storagedriver.StorageDriver) (storagedriver.FileInfo, error) {
+		return sd.Stat(ctx, path)
+	})
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, path string) ([]string, error) {
+	return read(ctx, "List", path, d, func(sd storagedriver.StorageDriver) ([]string, error) {
+		return sd.List(ctx, path)
+	})
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return write(ctx, "Move", sourcePath, d, func(sd storagedriver.StorageDriver) error {
+		return sd.Move(ctx, sourcePath, destPath)
+	})
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths.
+func (d *driver) Delete(ctx context.Context, path string) error {
+	err := d.primary.Delete(ctx, path)
+	if err != nil && !isPathNotFound(err) {
+		return err
+	}
+	// the content missing from the primary is deleted from the secondary
+	serr := d.secondary.Delete(ctx, path)
+	switch {
+	case err != nil && serr == nil:
+		diverged(ctx, "Delete", path, fmt.Errorf("found in the secondary only: %w", err))
+	case err == nil && isPathNotFound(serr):
+		diverged(ctx, "Delete", path, fmt.Errorf("missing from the secondary: %w", serr))
+	case serr != nil && !isPathNotFound(serr):
+		diverged(ctx, "Delete", path, fmt.Errorf("secondary: %w", serr))
+	}
+	return err
+}
+
+// RedirectURL returns a URL of the primary driver, as the content may be
+// missing from the secondary driver. The content missing from the primary
+// driver is not redirected to, so that it is served by the registry from the
+// secondary driver.
+func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
+	url, err := d.primary.RedirectURL(r, path)
+	if err != nil || url == "" {
+		return url, err
+	}
+	if _, err := d.primary.Stat(r.Context(), path); err != nil {
+		return "", nil
+	}
+	return url, nil
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	err := d.primary.Walk(ctx, path, f, options...)
+	if !isPathNotFound(err) {
+		return err
+	}
+	if serr := d.secondary.Walk(ctx, path, f, options...); serr == nil {
+		diverged(ctx, "Walk", path, fmt.Errorf("served by the secondary: %w", err))
+		return nil
+	}
+	return err
+}
+
+// writer writes to the writers of both drivers, dropping the writer of the
+// secondary driver once it fails.
+type writer struct {
+	ctx       context.Context
+	path      string
+	primary   storagedriver.FileWriter
+	secondary storagedriver.FileWriter
+}
+
+var _ storagedriver.FileWriter = &writer{}
+
+// drop drops the writer of the secondary driver after it failed.
+func (w *writer) drop(action string, err error) {
+	diverged(w.ctx, action, w.path, fmt.Errorf("secondary: %w", err))
+	w.secondary.Cancel(w.ctx)
+	w.secondary.Close()
+	w.secondary = nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.primary.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if w.secondary != nil {
+		if _, err := w.secondary.Write(p); err != nil {
+			w.drop("Write", err)
+		}
+	}
+	return n, nil
+}
+
+func (w *writer) Size() int64 {
+	return w.primary.Size()
+}
+
+func (w *writer) Close() error {
+	if w.secondary != nil {
+		if err := w.secondary.Close(); err != nil {
+			diverged(w.ctx, "Close", w.path, fmt.Errorf("secondary: %w", err))
+		}
+	}
+	return w.primary.Close()
+}
+
+func (w *writer) Cancel(ctx context.Context) error {
+	if w.secondary != nil {
+		if err := w.secondary.Cancel(ctx); err != nil {
+			diverged(ctx, "Cancel", w.path, fmt.Errorf("secondary: %w", err))
+		}
+	}
+	return w.primary.Cancel(ctx)
+}
+
+func (w *writer) Commit(ctx context.Context) error {
+	if err := w.primary.Commit(ctx); err != nil {
+		return err
+	}
+	if w.secondary != nil {
+		if err := w.secondary.Commit(ctx); err != nil {
+			w.drop("Commit", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorDriverSuite(t *testing.T) {
+	testsuites.Driver(t, func() (storagedriver.StorageDriver, error) {
+		return New(inmemory.New(), inmemory.New()), nil
+	}, false)
+}
+
+func TestFromParameters(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := FromParameters(ctx, map[string]interface{}{"primary": "inmemory"})
+	require.ErrorContains(t, err, "no secondary storage driver provided")
+
+	_, err = FromParameters(ctx, map[string]interface{}{
+		"primary":   "inmemory",
+		"secondary": map[interface{}]interface{}{"inmemory": nil, "filesystem": nil},
+	})
+	require.ErrorContains(t, err, "secondary must configure exactly one storage driver")
+
+	_, err = FromParameters(ctx, map[string]interface{}{
+		"primary":   "inmemory",
+		"secondary": map[interface{}]interface{}{"unknown": nil},
+	})
+	require.ErrorContains(t, err, "unable to create secondary storage driver")
+
+	d, err := FromParameters(ctx, map[string]interface{}{
+		"primary":   map[interface{}]interface{}{"inmemory": map[interface{}]interface{}{}},
+		"secondary": map[string]interface{}{"inmemory": nil},
+	})
+	require.NoError(t, err)
+	require.Equal(t, driverName, d.Name())
+}
+
+func TestWritesMirrored(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := inmemory.New(), inmemory.New()
+	d := New(primary, secondary)
+
+	require.NoError(t, d.PutContent(ctx, "/a/content", []byte("content")))
+
+	w, err := d.Writer(ctx, "/a/upload", false)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("up"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	w, err = d.Writer(ctx, "/a/upload", true)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("load"))
+	require.NoError(t, err)
+	require.NoError(t, w.Commit(ctx))
+	require.NoError(t, w.Close())
+
+	require.NoError(t, d.Move(ctx, "/a/upload", "/b/blob"))
+
+	for _, sd := range []storagedriver.StorageDriver{primary, secondary} {
+		content, err := sd.GetContent(ctx, "/a/content")
+		require.NoError(t, err)
+		require.Equal(t, "content", string(content))
+		content, err = sd.GetContent(ctx, "/b/blob")
+		require.NoError(t, err)
+		require.Equal(t, "upload", string(content))
+		_, err = sd.Stat(ctx, "/a/upload")
+		require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	}
+
+	require.NoError(t, d.Delete(ctx, "/a"))
+	for _, sd := range []storagedriver.StorageDriver{primary, secondary} {
+		_, err := sd.Stat(ctx, "/a")
+		require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := inmemory.New(), inmemory.New()
+	d := New(primary, secondary)
+
+	// written before the mirroring started
+	require.NoError(t, secondary.PutContent(ctx, "/old", []byte("old content")))
+
+	content, err := d.GetContent(ctx, "/old")
+	require.NoError(t, err)
+	require.Equal(t, "old content", string(content))
+
+	r, err := d.Reader(ctx, "/old", 4)
+	require.NoError(t, err)
+	content, err = io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "content", string(content))
+
+	fi, err := d.Stat(ctx, "/old")
+	require.NoError(t, err)
+	require.Equal(t, int64(11), fi.Size())
+
+	// the errors of the primary are returned
+	_, err = d.GetContent(ctx, "/missing")
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	require.ErrorContains(t, err, driverName)
+
+	// the content missing from the primary is deleted from the secondary
+	err = d.Delete(ctx, "/old")
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+	_, err = secondary.Stat(ctx, "/old")
+	require.ErrorAs(t, err, new(storagedriver.PathNotFoundError))
+}
+
+// redirectingDriver is an inmemory driver redirecting to the URL of the
+// paths.
+type redirectingDriver struct {
+	*inmemory.Driver
+}
+
+func (d redirectingDriver) RedirectURL(r *http.Request, path string) (string, error) {
+	return "https://primary.example.com" + path, nil
+}
+
+func TestRedirectURL(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := redirectingDriver{inmemory.New()}, inmemory.New()
+	d := New(primary, secondary)
+
+	require.NoError(t, d.PutContent(ctx, "/both", []byte("content")))
+	require.NoError(t, secondary.PutContent(ctx, "/old", []byte("old content")))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	url, err := d.RedirectURL(r, "/both")
+	require.NoError(t, err)
+	require.Equal(t, "https://primary.example.com/both", url)
+
+	// the content missing from the primary is served by the registry
+	url, err = d.RedirectURL(r, "/old")
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
@@ -69,6 +69,10 @@ const (
 // listMax is the largest amount of objects you can request from S3 in a list call
 const listMax = 1000
 
+// deleteMax is the largest amount of objects you can delete from S3 in a
+// DeleteObjects call
+const deleteMax = 1000
+
 // noStorageClass defines the value to be used if storage class is not supported by the S3 endpoint
 const noStorageClass = "NONE"
 
@@ -1069,6 +1073,54 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// Copy copies an object stored at sourcePath to destPath.
+func (d *driver) Copy(ctx context.Context, sourcePath, destPath string) error {
+	return d.copy(ctx, sourcePath, destPath)
+}
+
+// MoveCopies returns true, as Move copies the object before deleting the
+// original one.
+func (d *driver) MoveCopies() bool {
+	return true
+}
+
+// DeleteFiles deletes the objects stored at the paths, in batches of up to
+// deleteMax objects.
+func (d *driver) DeleteFiles(ctx context.Context, paths []string) error {
+	var errs []error
+	for batch := range slices.Chunk(paths, deleteMax) {
+		s3Objects := make([]*s3.ObjectIdentifier, 0, len(batch))
+		for _, path := range batch {
+			s3Objects = append(s3Objects, &s3.ObjectIdentifier{
+				Key: aws.String(d.s3Path(path)),
+			})
+		}
+
+		// the keys not found are not reported as errors
+		resp, err := d.S3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.Bucket),
+			Delete: &s3.Delete{
+				Objects: s3Objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		for _, err := range resp.Errors {
+			errs = append(errs, errors.New(err.String()))
+		}
+	}
+
+	if len(errs) > 0 {
+		return storagedriver.Errors{
+			DriverName: driverName,
+			Errs:       errs,
+		}
+	}
+	return nil
+}
+
 // RedirectURL returns a URL which may be used to retrieve the content stored at the given path.
 func (d *driver) RedirectURL(r *http.Request, path string) (string, error) {
 	expiresIn := 20 * time.Minute
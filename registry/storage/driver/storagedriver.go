@@ -112,6 +112,31 @@ type StorageDriver interface {
 	Walk(ctx context.Context, path string, f WalkFn, options ...func(*WalkOptions)) error
 }
 
+// Copier is an optional interface of the storage drivers able to copy an
+// object without transferring its content through the registry, such as with
+// a server-side copy. Drivers wrapping other drivers may return
+// ErrUnsupportedMethod when the wrapped driver does not implement it.
+type Copier interface {
+	// Copy copies the object stored at sourcePath to destPath, replacing the
+	// object stored at destPath, if any.
+	Copy(ctx context.Context, sourcePath string, destPath string) error
+
+	// MoveCopies reports whether Move copies the object to its destination
+	// before deleting the source, rather than moving it atomically.
+	MoveCopies() bool
+}
+
+// BatchDeleter is an optional interface of the storage drivers able to delete
+// several objects at once, such as with a bulk delete request. Drivers
+// wrapping other drivers may return ErrUnsupportedMethod when the wrapped
+// driver does not implement it.
+type BatchDeleter interface {
+	// DeleteFiles deletes the objects stored at the paths, which must not be
+	// directories. The paths not found are ignored. The drivers keeping
+	// directories remove the directory of a path when left empty.
+	DeleteFiles(ctx context.Context, paths []string) error
+}
+
 // FileWriter provides an abstraction for an opened writable file-like object in
 // the storage backend. The FileWriter must flush all content written to it on
 // the call to Close, but is only required to make its content readable on a
@@ -705,6 +705,56 @@ func (suite *DriverSuite) TestMoveInvalid() {
 	suite.Require().Error(err) // non-nil error
 }
 
+// TestCopy checks that a copied object exists at both paths and overwrites
+// the contents at the destination, if the driver implements Copier.
+func (suite *DriverSuite) TestCopy() {
+	copier, ok := suite.StorageDriver.(storagedriver.Copier)
+	if !ok {
+		suite.T().Skip("Copy is not implemented")
+	}
+
+	sourcePath := randomPath(32)
+	destPath := randomPath(32)
+	sourceContents := randomContents(32)
+	destContents := randomContents(64)
+
+	defer suite.deletePath(firstPart(sourcePath))
+	defer suite.deletePath(firstPart(destPath))
+
+	err := suite.StorageDriver.PutContent(suite.ctx, sourcePath, sourceContents)
+	suite.Require().NoError(err)
+
+	err = suite.StorageDriver.PutContent(suite.ctx, destPath, destContents)
+	suite.Require().NoError(err)
+
+	err = copier.Copy(suite.ctx, sourcePath, destPath)
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); ok {
+		suite.T().Skip("Copy is not supported")
+	}
+	suite.Require().NoError(err)
+
+	received, err := suite.StorageDriver.GetContent(suite.ctx, destPath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(sourceContents, received)
+
+	received, err = suite.StorageDriver.GetContent(suite.ctx, sourcePath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(sourceContents, received)
+
+	// the copies are independent
+	err = suite.StorageDriver.PutContent(suite.ctx, sourcePath, destContents)
+	suite.Require().NoError(err)
+
+	received, err = suite.StorageDriver.GetContent(suite.ctx, destPath)
+	suite.Require().NoError(err)
+	suite.Require().Equal(sourceContents, received)
+
+	err = copier.Copy(suite.ctx, randomPath(32), destPath)
+	suite.Require().Error(err)
+	suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+	suite.Require().Contains(err.Error(), suite.Name())
+}
+
 // TestDelete checks that the delete operation removes data from the storage
 // driver
 func (suite *DriverSuite) TestDelete() {
@@ -725,6 +775,48 @@ func (suite *DriverSuite) TestDelete() {
 	suite.Require().Contains(err.Error(), suite.Name())
 }
 
+// TestDeleteFiles checks that the files deleted at once are removed, along
+// with the directories left empty, if the driver implements BatchDeleter.
+func (suite *DriverSuite) TestDeleteFiles() {
+	deleter, ok := suite.StorageDriver.(storagedriver.BatchDeleter)
+	if !ok {
+		suite.T().Skip("DeleteFiles is not implemented")
+	}
+
+	rootDirectory := "/" + randomFilename(int64(8+rand.Intn(8)))
+	defer suite.deletePath(rootDirectory)
+
+	kept := rootDirectory + "/kept/" + randomFilename(32)
+	deleted := []string{
+		rootDirectory + "/kept/" + randomFilename(32),
+		rootDirectory + "/emptied/" + randomFilename(32),
+		rootDirectory + "/emptied/" + randomFilename(32),
+	}
+	for _, filename := range append([]string{kept}, deleted...) {
+		err := suite.StorageDriver.PutContent(suite.ctx, filename, randomContents(32))
+		suite.Require().NoError(err)
+	}
+
+	err := deleter.DeleteFiles(suite.ctx, append(deleted, rootDirectory+"/"+randomFilename(32)))
+	if _, ok := err.(storagedriver.ErrUnsupportedMethod); ok {
+		suite.T().Skip("DeleteFiles is not supported")
+	}
+	suite.Require().NoError(err)
+
+	for _, filename := range deleted {
+		_, err = suite.StorageDriver.GetContent(suite.ctx, filename)
+		suite.Require().Error(err)
+		suite.Require().IsType(err, storagedriver.PathNotFoundError{})
+	}
+
+	_, err = suite.StorageDriver.GetContent(suite.ctx, kept)
+	suite.Require().NoError(err)
+
+	list, err := suite.StorageDriver.List(suite.ctx, rootDirectory)
+	suite.Require().NoError(err)
+	suite.Require().Equal([]string{rootDirectory + "/kept"}, list)
+}
+
 // TestRedirectURL checks that the RedirectURL method functions properly,
 // but only if it is implemented
 func (suite *DriverSuite) TestRedirectURL() {
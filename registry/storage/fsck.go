@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// FsckOpts contains options for Fsck
+type FsckOpts struct {
+	// VerifyBlobs re-hashes the data of every blob and reports the blobs
+	// whose content does not match their digest.
+	VerifyBlobs bool
+
+	// Repair removes the dangling links found: layer links and manifest
+	// revision links to missing blobs, and tags of unknown revisions. The
+	// quota usage of the repositories is recounted from the links kept.
+	Repair bool
+
+	// Quiet silences the progress output. Problems are always reported.
+	Quiet bool
+}
+
+// FsckProblemKind identifies a class of problem found by Fsck.
+type FsckProblemKind string
+
+const (
+	// FsckBlobCorrupt is a blob whose data does not match its digest.
+	FsckBlobCorrupt FsckProblemKind = "corrupt blob"
+
+	// FsckLayerLinkDangling is a layer link to a missing blob.
+	FsckLayerLinkDangling FsckProblemKind = "dangling layer link"
+
+	// FsckRevisionLinkDangling is a manifest revision link to a missing blob.
+	FsckRevisionLinkDangling FsckProblemKind = "dangling manifest revision link"
+
+	// FsckTagDangling is a tag without a current link, or whose current
+	// link targets an unknown manifest revision.
+	FsckTagDangling FsckProblemKind = "dangling tag"
+
+	// FsckManifestInvalid is a manifest revision which cannot be read.
+	FsckManifestInvalid FsckProblemKind = "invalid manifest"
+
+	// FsckReferenceMissing is a manifest reference, such as a layer or an
+	// index entry, missing from the repository.
+	FsckReferenceMissing FsckProblemKind = "missing reference"
+)
+
+// FsckProblem describes an inconsistency found in the registry storage.
+type FsckProblem struct {
+	Kind FsckProblemKind
+
+	// Repository is the repository the problem was found in. It is empty
+	// for blobs.
+	Repository string
+
+	// Tag is the dangling tag, if any.
+	Tag string
+
+	// Digest is the blob, link target or manifest concerned.
+	Digest digest.Digest
+
+	// Detail describes the problem further.
+	Detail string
+
+	// Repaired is true if the problem was fixed by Fsck.
+	Repaired bool
+}
+
+func (p FsckProblem) String() string {
+	s := string(p.Kind)
+	if p.Repository != "" {
+		s = p.Repository + ": " + s
+	}
+	if p.Tag != "" {
+		s += " " + p.Tag
+	}
+	if p.Digest != "" {
+		s += " " + p.Digest.String()
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// Fsck verifies the integrity of the registry storage. It returns the
+// problems found, repairing the dangling links if opts.Repair is set.
+func Fsck(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts FsckOpts) ([]FsckProblem, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var problems []FsckProblem
+	report := func(problem FsckProblem) {
+		emit("%s", problem)
+		problems = append(problems, problem)
+	}
+
+	blobStatter := registry.BlobStatter()
+	blobSize := func(dgst digest.Digest) (int64, bool, error) {
+		desc, err := blobStatter.Stat(ctx, dgst)
+		switch err {
+		case nil:
+			return desc.Size, true, nil
+		case distribution.ErrBlobUnknown:
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+	quotas := namespaceQuotas(registry)
+
+	// removeLink removes the directory holding a dangling link
+	removeLink := func(linkPath string) (bool, error) {
+		if !opts.Repair {
+			return false, nil
+		}
+		if err := storageDriver.Delete(ctx, path.Dir(linkPath)); err != nil {
+			return false, fmt.Errorf("failed to remove link %s: %v", linkPath, err)
+		}
+		return true, nil
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		if !opts.Quiet {
+			emit(repoName)
+		}
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+
+		// usage is the size of the blobs linked into the repository, as
+		// accounted by the quotas
+		var usage int64
+
+		// The enumerators skip the links to missing blobs, so the link
+		// directories are walked directly.
+		err = walkLinks(ctx, storageDriver, manifestRevisionsPathSpec{name: repoName}, func(linkPath string, dgst digest.Digest, linkErr error) error {
+			if linkErr != nil {
+				repaired, err := removeLink(linkPath)
+				if err != nil {
+					return err
+				}
+				report(FsckProblem{Kind: FsckRevisionLinkDangling, Repository: repoName, Detail: fmt.Sprintf("invalid link %s: %v", linkPath, linkErr), Repaired: repaired})
+				return nil
+			}
+			size, ok, err := blobSize(dgst)
+			if err != nil || ok {
+				usage += size
+				return err
+			}
+			repaired, err := removeLink(linkPath)
+			if err != nil {
+				return err
+			}
+			report(FsckProblem{Kind: FsckRevisionLinkDangling, Repository: repoName, Digest: dgst, Detail: "blob unknown", Repaired: repaired})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		tagService := repository.Tags(ctx)
+		tags, err := tagService.All(ctx)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+				return fmt.Errorf("failed to retrieve tags of repo %s: %v", repoName, err)
+			}
+		}
+		for _, tag := range tags {
+			problem := FsckProblem{Kind: FsckTagDangling, Repository: repoName, Tag: tag}
+			desc, err := tagService.Get(ctx, tag)
+			if err != nil {
+				if _, ok := err.(distribution.ErrTagUnknown); !ok {
+					return fmt.Errorf("failed to retrieve tag %s of repo %s: %v", tag, repoName, err)
+				}
+				problem.Detail = "current link missing"
+			} else {
+				ok, err := manifestService.Exists(ctx, desc.Digest)
+				if err != nil {
+					return fmt.Errorf("failed to check manifest %s of repo %s: %v", desc.Digest, repoName, err)
+				}
+				if ok {
+					continue
+				}
+				problem.Digest = desc.Digest
+				problem.Detail = "manifest revision unknown"
+			}
+			if opts.Repair {
+				if err := tagService.Untag(ctx, tag); err != nil {
+					return fmt.Errorf("failed to delete tag %s of repo %s: %v", tag, repoName, err)
+				}
+				problem.Repaired = true
+			}
+			report(problem)
+		}
+
+		err = walkLinks(ctx, storageDriver, layersPathSpec{name: repoName}, func(linkPath string, dgst digest.Digest, linkErr error) error {
+			if linkErr != nil {
+				repaired, err := removeLink(linkPath)
+				if err != nil {
+					return err
+				}
+				report(FsckProblem{Kind: FsckLayerLinkDangling, Repository: repoName, Detail: fmt.Sprintf("invalid link %s: %v", linkPath, linkErr), Repaired: repaired})
+				return nil
+			}
+			size, ok, err := blobSize(dgst)
+			if err != nil || ok {
+				usage += size
+				return err
+			}
+			repaired, err := removeLink(linkPath)
+			if err != nil {
+				return err
+			}
+			report(FsckProblem{Kind: FsckLayerLinkDangling, Repository: repoName, Digest: dgst, Detail: "blob unknown", Repaired: repaired})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the usage of the links removed, whose blobs are missing, is
+		// unknown, so the usage of the repository is recounted instead
+		if opts.Repair {
+			if err := quotas.setRepository(ctx, repoName, usage); err != nil {
+				return fmt.Errorf("failed to update quota usage of repo %s: %v", repoName, err)
+			}
+		}
+
+		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		}
+		blobService := repository.Blobs(ctx)
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			manifest, err := manifestService.Get(ctx, dgst)
+			if err != nil {
+				report(FsckProblem{Kind: FsckManifestInvalid, Repository: repoName, Digest: dgst, Detail: err.Error()})
+				return nil
+			}
+
+			mediaType, _, err := manifest.Payload()
+			if err != nil {
+				report(FsckProblem{Kind: FsckManifestInvalid, Repository: repoName, Digest: dgst, Detail: err.Error()})
+				return nil
+			}
+			isIndex := mediaType == v1.MediaTypeImageIndex || mediaType == manifestlist.MediaTypeManifestList
+
+			for _, descriptor := range manifest.References() {
+				// non-distributable content is fetched from its URLs
+				if len(descriptor.URLs) > 0 {
+					continue
+				}
+
+				var ok bool
+				if isIndex {
+					ok, err = manifestService.Exists(ctx, descriptor.Digest)
+				} else {
+					_, err = blobService.Stat(ctx, descriptor.Digest)
+					ok = err == nil
+					if err == distribution.ErrBlobUnknown {
+						err = nil
+					}
+				}
+				if err != nil {
+					return fmt.Errorf("failed to check reference %s of manifest %s: %v", descriptor.Digest, dgst, err)
+				}
+				if !ok {
+					report(FsckProblem{Kind: FsckReferenceMissing, Repository: repoName, Digest: dgst, Detail: fmt.Sprintf("%s %s unknown", descriptor.MediaType, descriptor.Digest)})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// the repository may have no manifests
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return problems, fmt.Errorf("failed to check repositories: %v", err)
+	}
+
+	if opts.VerifyBlobs {
+		err = registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+			ok, err := verifyBlob(ctx, storageDriver, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to verify blob %s: %v", dgst, err)
+			}
+			if !ok {
+				report(FsckProblem{Kind: FsckBlobCorrupt, Digest: dgst, Detail: "content does not match digest"})
+			}
+			return nil
+		})
+		if err != nil {
+			return problems, fmt.Errorf("error enumerating blobs: %v", err)
+		}
+	}
+
+	if !opts.Quiet {
+		repaired := 0
+		for _, problem := range problems {
+			if problem.Repaired {
+				repaired++
+			}
+		}
+		emit("\n%d problems found, %d repaired", len(problems), repaired)
+	}
+
+	return problems, nil
+}
+
+// walkLinks calls fn with the path and target of every link below the
+// directory described by spec. Links whose target cannot be parsed are
+// passed with linkErr set.
+func walkLinks(ctx context.Context, storageDriver driver.StorageDriver, spec pathSpec, fn func(linkPath string, dgst digest.Digest, linkErr error) error) error {
+	rootPath, err := pathFor(spec)
+	if err != nil {
+		return err
+	}
+
+	err = storageDriver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		linkPath := fileInfo.Path()
+		if _, fileName := path.Split(linkPath); fileName != "link" {
+			return nil
+		}
+
+		content, err := storageDriver.GetContent(ctx, linkPath)
+		if err != nil {
+			return err
+		}
+		dgst, err := digest.Parse(string(content))
+		return fn(linkPath, dgst, err)
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// verifyBlob returns true if the data of the blob matches its digest.
+func verifyBlob(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) (bool, error) {
+	if err := dgst.Validate(); err != nil {
+		return false, nil
+	}
+
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return false, err
+	}
+	rc, err := storageDriver.Reader(ctx, blobPath, 0)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, rc); err != nil {
+		return false, err
+	}
+	return verifier.Verified(), nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func fsckKinds(problems []FsckProblem) map[FsckProblemKind]int {
+	kinds := make(map[FsckProblemKind]int)
+	for _, problem := range problems {
+		kinds[problem.Kind]++
+	}
+	return kinds
+}
+
+func TestFsckHealthyRegistry(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "fsck/healthy")
+	image := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	problems, err := Fsck(ctx, d, registry, FsckOpts{VerifyBlobs: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+}
+
+func TestFsckFindsProblems(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "fsck/broken")
+	image := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	unknown := digest.FromString("unknown")
+	if err := repo.Tags(ctx).Tag(ctx, "dangling", v1.Descriptor{Digest: unknown}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	// lose the data of one layer and corrupt the other
+	layers := getKeys(image.layers)
+	lostPath, err := pathFor(blobPathSpec{digest: layers[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, lostPath); err != nil {
+		t.Fatalf("failed to delete blob: %v", err)
+	}
+	corruptPath, err := pathFor(blobDataPathSpec{digest: layers[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContent(ctx, corruptPath, []byte("corrupt")); err != nil {
+		t.Fatalf("failed to corrupt blob: %v", err)
+	}
+
+	problems, err := Fsck(ctx, d, registry, FsckOpts{VerifyBlobs: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	kinds := fsckKinds(problems)
+	expected := map[FsckProblemKind]int{
+		FsckTagDangling:       1,
+		FsckLayerLinkDangling: 1,
+		FsckReferenceMissing:  1,
+		FsckBlobCorrupt:       1,
+	}
+	for kind, n := range expected {
+		if kinds[kind] != n {
+			t.Errorf("expected %d %q problems, got %d: %v", n, kind, kinds[kind], problems)
+		}
+	}
+	if len(problems) != 4 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	for _, problem := range problems {
+		if problem.Repaired {
+			t.Errorf("problem repaired without repair: %v", problem)
+		}
+	}
+
+	// repair removes the dangling links only
+	problems, err = Fsck(ctx, d, registry, FsckOpts{Repair: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	for _, problem := range problems {
+		repairable := problem.Kind == FsckTagDangling || problem.Kind == FsckLayerLinkDangling
+		if problem.Repaired != repairable {
+			t.Errorf("unexpected repair state: %v", problem)
+		}
+	}
+
+	layerLinkPath, err := pathFor(layerLinkPathSpec{name: "fsck/broken", digest: layers[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, layerLinkPath); err == nil {
+		t.Fatalf("dangling layer link %s was not removed", layerLinkPath)
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatal(err)
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "dangling"); err == nil {
+		t.Fatalf("dangling tag was not removed")
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "latest"); err != nil {
+		t.Fatalf("tag latest was removed: %v", err)
+	}
+
+	// only the unrepairable missing reference remains
+	problems, err = Fsck(ctx, d, registry, FsckOpts{Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Kind != FsckReferenceMissing {
+		t.Fatalf("unexpected problems after repair: %v", problems)
+	}
+}
+
+func TestFsckDanglingRevisionLink(t *testing.T) {
+	ctx := dcontext.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "fsck/revision")
+	image := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	manifestPath, err := pathFor(blobPathSpec{digest: image.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, manifestPath); err != nil {
+		t.Fatalf("failed to delete manifest blob: %v", err)
+	}
+
+	problems, err := Fsck(ctx, d, registry, FsckOpts{Repair: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	kinds := fsckKinds(problems)
+	if kinds[FsckRevisionLinkDangling] != 1 || kinds[FsckTagDangling] != 1 || len(problems) != 2 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	problems, err = Fsck(ctx, d, registry, FsckOpts{Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems after repair: %v", problems)
+	}
+}
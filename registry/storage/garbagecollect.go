@@ -4,14 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+var (
+	// gcMarkedCount is the number of objects marked by garbage collection
+	gcMarkedCount = prometheus.StorageNamespace.NewLabeledCounter("gc_marked", "The number of objects marked by garbage collection", "type")
+	// gcSweptCount is the number of objects swept by garbage collection
+	gcSweptCount = prometheus.StorageNamespace.NewLabeledCounter("gc_swept", "The number of objects swept by garbage collection", "type")
+)
+
+// sweepBatchSize is the number of blobs deleted at once when sweeping. Blobs
+// are checked against the LinkTracker batch by batch, keeping short the window
+// in which a blob linked by a concurrent push could still be deleted.
+var sweepBatchSize = 100
+
 func emit(format string, a ...interface{}) {
 	fmt.Printf(format+"\n", a...)
 }
@@ -21,6 +36,23 @@ type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
 	Quiet          bool
+
+	// GracePeriod protects content written less than GracePeriod before
+	// the collection started from being swept. It must be set when the
+	// registry may be written to while collecting.
+	GracePeriod time.Duration
+
+	// Tracker, if set, protects content linked through a registry
+	// configured with TrackLinks while the collection is running.
+	Tracker *LinkTracker
+
+	// RetentionPolicies are applied to the tags of each repository before
+	// marking. Expired tags are untagged, unless DryRun is set.
+	RetentionPolicies []RetentionPolicy
+
+	// ProtectTag, if set, returns true for the tags of a repository which
+	// the retention policies must never delete, such as immutable tags.
+	ProtectTag func(repository, tag string) bool
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -37,9 +69,46 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	if opts.Tracker != nil {
+		if err := opts.Tracker.start(); err != nil {
+			return err
+		}
+		defer opts.Tracker.stop()
+	}
+
+	// protected returns true if the content dgst, stored at path, must be
+	// kept because it was linked during the collection or written within
+	// the grace period.
+	now := time.Now()
+	cutoff := now.Add(-opts.GracePeriod)
+	protected := func(path string, dgst digest.Digest) (bool, error) {
+		if opts.Tracker.seen(dgst) {
+			return true, nil
+		}
+		if opts.GracePeriod <= 0 {
+			return false, nil
+		}
+		fi, err := storageDriver.Stat(ctx, path)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return false, nil
+			}
+			return false, err
+		}
+		return fi.ModTime().After(cutoff), nil
+	}
+
+	// sizes records the size of blobs whose layer links are swept, to
+	// release them from the repository quotas.
+	quotas := namespaceQuotas(registry)
+	sizes := make(map[digest.Digest]int64)
+
 	// mark
 	markSet := make(map[digest.Digest]struct{})
 	deleteLayerSet := make(map[string][]digest.Digest)
+	// deleteReferrerSet maps the dangling referrer links of each repository
+	// to the revisions they link, if valid
+	deleteReferrerSet := make(map[string]map[string]digest.Digest)
 	manifestArr := make([]ManifestDel, 0)
 	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
 		if !opts.Quiet {
@@ -56,6 +125,25 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			return fmt.Errorf("failed to construct repository: %v", err)
 		}
 
+		if policy := retentionPolicyFor(opts.RetentionPolicies, repoName); policy != nil {
+			expired, err := expiredTags(ctx, storageDriver, repository, policy, opts.ProtectTag, now, cutoff)
+			if err != nil {
+				return fmt.Errorf("failed to apply retention policy to %s: %v", repoName, err)
+			}
+			for _, tag := range expired {
+				if !opts.Quiet {
+					emit("%s: tag eligible for deletion: %s", repoName, tag)
+				}
+				if opts.DryRun {
+					continue
+				}
+				if err := repository.Tags(ctx).Untag(ctx, tag); err != nil {
+					return fmt.Errorf("failed to delete tag %s of repo %s: %v", tag, repoName, err)
+				}
+				gcSweptCount.WithValues("tag").Inc(1)
+			}
+		}
+
 		manifestService, err := repository.Manifests(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to construct manifest service: %v", err)
@@ -74,21 +162,31 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
 				}
 				if len(tags) == 0 {
-					// fetch all tags from repository
-					// all of these tags could contain manifest in history
-					// which means that we need check (and delete) those references when deleting manifest
-					allTags, err := repository.Tags(ctx).All(ctx)
+					revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
 					if err != nil {
-						if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
-							if !opts.Quiet {
-								emit("manifest tags path of repository %s does not exist", repoName)
+						return err
+					}
+					keep, err := protected(revisionPath, dgst)
+					if err != nil {
+						return fmt.Errorf("failed to stat manifest %v: %v", dgst, err)
+					}
+					if !keep {
+						// fetch all tags from repository
+						// all of these tags could contain manifest in history
+						// which means that we need check (and delete) those references when deleting manifest
+						allTags, err := repository.Tags(ctx).All(ctx)
+						if err != nil {
+							if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+								if !opts.Quiet {
+									emit("manifest tags path of repository %s does not exist", repoName)
+								}
+								return nil
 							}
-							return nil
+							return fmt.Errorf("failed to retrieve tags %v", err)
 						}
-						return fmt.Errorf("failed to retrieve tags %v", err)
+						manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+						return nil
 					}
-					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
-					return nil
 				}
 			}
 			// Mark the manifest's blob
@@ -119,6 +217,29 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 				return err
 			}
 		}
+
+		// the referrer links of the revisions no longer linked into the
+		// repository are dangling, as the revisions are linked first
+		deleteReferrers := make(map[string]digest.Digest)
+		err = walkLinks(ctx, storageDriver, referrersRootPathSpec{name: repoName}, func(linkPath string, dgst digest.Digest, linkErr error) error {
+			if linkErr != nil {
+				deleteReferrers[linkPath] = ""
+				return nil
+			}
+			linked, err := revisionLinked(ctx, storageDriver, repoName, dgst)
+			if err != nil || linked {
+				return err
+			}
+			deleteReferrers[linkPath] = dgst
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to walk referrers of repo %s: %v", repoName, err)
+		}
+		if len(deleteReferrers) > 0 {
+			deleteReferrerSet[repoName] = deleteReferrers
+		}
+
 		blobService := repository.Blobs(ctx)
 		layerEnumerator, ok := blobService.(distribution.ManifestEnumerator)
 		if !ok {
@@ -127,9 +248,34 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 		var deleteLayers []digest.Digest
 		err = layerEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if _, ok := markSet[dgst]; !ok {
-				deleteLayers = append(deleteLayers, dgst)
+			if _, ok := markSet[dgst]; ok {
+				return nil
 			}
+			layerPath, err := pathFor(layerLinkPathSpec{name: repoName, digest: dgst})
+			if err != nil {
+				return err
+			}
+			keep, err := protected(layerPath, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to stat layer link %v: %v", dgst, err)
+			}
+			if keep {
+				if !opts.Quiet {
+					emit("%s: marking recently linked blob %s", repoName, dgst)
+				}
+				markSet[dgst] = struct{}{}
+				return nil
+			}
+			if quotas != nil {
+				if _, ok := sizes[dgst]; !ok {
+					desc, err := registry.BlobStatter().Stat(ctx, dgst)
+					if err != nil && err != distribution.ErrBlobUnknown {
+						return fmt.Errorf("failed to stat blob %v: %v", dgst, err)
+					}
+					sizes[dgst] = desc.Size
+				}
+			}
+			deleteLayers = append(deleteLayers, dgst)
 			return nil
 		})
 		if len(deleteLayers) > 0 {
@@ -142,22 +288,54 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	}
 
 	manifestArr = unmarkReferencedManifest(manifestArr, markSet, opts.Quiet)
+	gcMarkedCount.WithValues("blob").Inc(float64(len(markSet)))
 
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
 	if !opts.DryRun {
 		for _, obj := range manifestArr {
+			// the manifest may have been tagged since it was marked, in which
+			// case it is kept along with its references
+			if opts.Tracker.seen(obj.Digest) {
+				if err := markLinkedManifest(ctx, registry, obj, markSet); err != nil {
+					return err
+				}
+				continue
+			}
+			var size int64
+			if quotas != nil {
+				desc, err := registry.BlobStatter().Stat(ctx, obj.Digest)
+				if err != nil && err != distribution.ErrBlobUnknown {
+					return fmt.Errorf("failed to stat manifest %s: %v", obj.Digest, err)
+				}
+				size = desc.Size
+			}
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
+			if err := quotas.release(ctx, obj.Name, size); err != nil {
+				return fmt.Errorf("failed to release quota of manifest %s of repo %s: %v", obj.Digest, obj.Name, err)
+			}
+			gcSweptCount.WithValues("manifest").Inc(1)
 		}
 	}
 	blobService := registry.Blobs()
 	deleteSet := make(map[digest.Digest]struct{})
 	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
 		// check if digest is in markSet. If not, delete it!
-		if _, ok := markSet[dgst]; !ok {
+		if _, ok := markSet[dgst]; ok {
+			return nil
+		}
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		keep, err := protected(blobPath, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to stat blob %v: %v", dgst, err)
+		}
+		if !keep {
 			deleteSet[dgst] = struct{}{}
 		}
 		return nil
@@ -168,6 +346,7 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	if !opts.Quiet {
 		emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
 	}
+	removeBlobs := make([]digest.Digest, 0, len(deleteSet))
 	for dgst := range deleteSet {
 		if !opts.Quiet {
 			emit("blob eligible for deletion: %s", dgst)
@@ -175,10 +354,28 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		if opts.DryRun {
 			continue
 		}
-		err = vacuum.RemoveBlob(string(dgst))
+		removeBlobs = append(removeBlobs, dgst)
+	}
+	for len(removeBlobs) > 0 {
+		n := min(len(removeBlobs), sweepBatchSize)
+		batch := make([]digest.Digest, 0, n)
+		for _, dgst := range removeBlobs[:n] {
+			// content may have been linked since the blobs were enumerated,
+			// so check each batch right before deleting it
+			if opts.Tracker.seen(dgst) {
+				continue
+			}
+			batch = append(batch, dgst)
+		}
+		removeBlobs = removeBlobs[n:]
+		if len(batch) == 0 {
+			continue
+		}
+		err = vacuum.RemoveBlobs(batch)
 		if err != nil {
-			return fmt.Errorf("failed to delete blob %s: %v", dgst, err)
+			return fmt.Errorf("failed to delete blobs: %v", err)
 		}
+		gcSweptCount.WithValues("blob").Inc(float64(len(batch)))
 	}
 
 	for repo, dgsts := range deleteLayerSet {
@@ -189,16 +386,73 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			if opts.DryRun {
 				continue
 			}
+			if _, ok := markSet[dgst]; ok || opts.Tracker.seen(dgst) {
+				continue
+			}
 			err = vacuum.RemoveLayer(repo, dgst)
 			if err != nil {
 				return fmt.Errorf("failed to delete layer link %s of repo %s: %v", dgst, repo, err)
 			}
+			if err := quotas.release(ctx, repo, sizes[dgst]); err != nil {
+				return fmt.Errorf("failed to release quota of layer link %s of repo %s: %v", dgst, repo, err)
+			}
+			gcSweptCount.WithValues("layer").Inc(1)
+		}
+	}
+
+	for repo, links := range deleteReferrerSet {
+		for linkPath, dgst := range links {
+			if !opts.Quiet {
+				emit("%s: dangling referrer link eligible for deletion: %s", repo, linkPath)
+			}
+			if opts.DryRun {
+				continue
+			}
+			// the revision may have been pushed again since it was marked
+			if dgst != "" {
+				linked, err := revisionLinked(ctx, storageDriver, repo, dgst)
+				if err != nil {
+					return fmt.Errorf("failed to stat manifest %s of repo %s: %v", dgst, repo, err)
+				}
+				if linked {
+					continue
+				}
+			}
+			err := storageDriver.Delete(ctx, path.Dir(linkPath))
+			if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+				return fmt.Errorf("failed to delete referrer link %s of repo %s: %v", linkPath, repo, err)
+			}
+			gcSweptCount.WithValues("referrer").Inc(1)
 		}
 	}
 
 	return err
 }
 
+// revisionLinked returns true if the manifest revision is linked into the
+// named repository.
+func revisionLinked(ctx context.Context, storageDriver driver.StorageDriver, name string, dgst digest.Digest) (bool, error) {
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: dgst})
+	if err != nil {
+		return false, err
+	}
+	if _, err := storageDriver.Stat(ctx, revisionPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// namespaceQuotas returns the quotas enforced by the registry, if any.
+func namespaceQuotas(ns distribution.Namespace) *quotaStore {
+	if reg, ok := ns.(*registry); ok {
+		return reg.quotas
+	}
+	return nil
+}
+
 // unmarkReferencedManifest filters out manifest present in markSet
 func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Digest]struct{}, quietOutput bool) []ManifestDel {
 	filtered := make([]ManifestDel, 0)
@@ -214,6 +468,30 @@ func unmarkReferencedManifest(manifestArr []ManifestDel, markSet map[digest.Dige
 	return filtered
 }
 
+// markLinkedManifest marks a manifest linked during the collection, and its
+// references.
+func markLinkedManifest(ctx context.Context, registry distribution.Namespace, obj ManifestDel, markSet map[digest.Digest]struct{}) error {
+	named, err := reference.WithName(obj.Name)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", obj.Name, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("failed to construct repository: %v", err)
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	markSet[obj.Digest] = struct{}{}
+	return markManifestReferences(obj.Digest, manifestService, ctx, func(d digest.Digest) bool {
+		_, marked := markSet[d]
+		markSet[d] = struct{}{}
+		return marked
+	})
+}
+
 // markManifestReferences marks the manifest references
 func markManifestReferences(dgst digest.Digest, manifestService distribution.ManifestService, ctx context.Context, ingester func(digest.Digest) bool) error {
 	manifest, err := manifestService.Get(ctx, dgst)
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"context"
 	"io"
 	"path"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
@@ -14,6 +19,7 @@ import (
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -702,6 +708,262 @@ func TestOrphanBlobDeleted(t *testing.T) {
 	}
 }
 
+func TestOrphanBlobDeletedWithoutBatchDeleter(t *testing.T) {
+	// hide the optional methods of the driver
+	storageDriver := struct{ driver.StorageDriver }{inmemory.New()}
+
+	registry := createRegistry(t, storageDriver)
+	repo := makeRepository(t, registry, "unbatched")
+
+	digests, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	// formality to create the necessary directories
+	uploadRandomSchema2Image(t, repo)
+
+	// Run GC
+	err = MarkAndSweep(dcontext.Background(), storageDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+
+	// check that orphan blob layers are not still around
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; ok {
+			t.Fatalf("Orphan layer is present: %v", dgst)
+		}
+	}
+}
+
+func TestOrphanBlobWithinGracePeriod(t *testing.T) {
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "grace")
+
+	digests, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatalf("Failed to create random digest: %v", err)
+	}
+
+	if err = testutil.UploadBlobs(repo, digests); err != nil {
+		t.Fatalf("Failed to upload blob: %v", err)
+	}
+
+	// formality to create the necessary directories
+	uploadRandomSchema2Image(t, repo)
+
+	// Run GC
+	err = MarkAndSweep(dcontext.Background(), inmemoryDriver, registry, GCOpts{
+		DryRun:         false,
+		RemoveUntagged: true,
+		GracePeriod:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+
+	// check that recently uploaded blob layers are still around
+	for dgst := range digests {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("Recently uploaded layer is missing: %v", dgst)
+		}
+	}
+}
+
+func TestLinkTrackerRecordsLinks(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	tracker := NewLinkTracker()
+	registry := createRegistry(t, inmemoryDriver, TrackLinks(tracker))
+	repo := makeRepository(t, registry, "tracked")
+
+	if err := tracker.start(); err != nil {
+		t.Fatalf("Failed to start tracker: %v", err)
+	}
+
+	image := uploadRandomSchema2Image(t, repo)
+	if !tracker.seen(image.manifestDigest) {
+		t.Fatalf("manifest %v was not recorded", image.manifestDigest)
+	}
+	for dgst := range image.layers {
+		if !tracker.seen(dgst) {
+			t.Fatalf("layer %v was not recorded", dgst)
+		}
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{Tracker: tracker})
+	if err != errLinkTrackerActive {
+		t.Fatalf("expected %v running concurrent collections, got %v", errLinkTrackerActive, err)
+	}
+
+	tracker.stop()
+	if tracker.seen(image.manifestDigest) {
+		t.Fatal("tracker should not record links once stopped")
+	}
+}
+
+// walkHookDriver calls hook before walking a path.
+type walkHookDriver struct {
+	driver.StorageDriver
+	hook func(path string)
+}
+
+func (d *walkHookDriver) Walk(ctx context.Context, path string, f driver.WalkFn, options ...func(*driver.WalkOptions)) error {
+	d.hook(path)
+	return d.StorageDriver.Walk(ctx, path, f, options...)
+}
+
+func TestManifestTaggedDuringCollection(t *testing.T) {
+	ctx := dcontext.Background()
+	storageDriver := &walkHookDriver{StorageDriver: inmemory.New(), hook: func(string) {}}
+
+	tracker := NewLinkTracker()
+	registry := createRegistry(t, storageDriver, TrackLinks(tracker))
+	repo := makeRepository(t, registry, "retagged")
+	image := uploadRandomSchema2Image(t, repo)
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "tagged", v1.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	// tag the untagged manifest once the manifests are marked, when the
+	// layer links of the repository are enumerated
+	storageDriver.hook = func(p string) {
+		if path.Base(p) != "_layers" {
+			return
+		}
+		storageDriver.hook = func(string) {}
+		if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+			t.Fatalf("failed to tag manifest: %v", err)
+		}
+	}
+
+	err := MarkAndSweep(ctx, storageDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Tracker:        tracker,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	if _, ok := allManifests(t, makeManifestService(t, repo))[image.manifestDigest]; !ok {
+		t.Fatalf("manifest tagged during the collection was deleted: %v", image.manifestDigest)
+	}
+	blobs := allBlobs(t, registry)
+	for dgst := range image.layers {
+		if _, ok := blobs[dgst]; !ok {
+			t.Fatalf("layer of the manifest tagged during the collection was deleted: %v", dgst)
+		}
+		if _, err := repo.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			t.Fatalf("layer link of the manifest tagged during the collection was deleted: %v", dgst)
+		}
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	ciRepo := makeRepository(t, registry, "ci/app")
+	otherRepo := makeRepository(t, registry, "other")
+
+	for _, repo := range []distribution.Repository{ciRepo, otherRepo} {
+		image := uploadRandomSchema2Image(t, repo)
+		for _, tag := range []string{"latest", "pr-1", "pr-2", "pr-3"} {
+			if err := repo.Tags(ctx).Tag(ctx, tag, v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+				t.Fatalf("failed to tag manifest: %v", err)
+			}
+			// ensure tags are ordered by modification time
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	opts := GCOpts{
+		DryRun: true,
+		RetentionPolicies: []RetentionPolicy{{
+			Repositories: "ci/*",
+			KeepLast:     1,
+			Protect:      regexp.MustCompile("^latest$"),
+		}},
+	}
+
+	checkTags := func(repo distribution.Repository, expected []string) {
+		t.Helper()
+		tags, err := repo.Tags(ctx).All(ctx)
+		if err != nil {
+			t.Fatalf("failed to list tags: %v", err)
+		}
+		if !reflect.DeepEqual(tags, expected) {
+			t.Fatalf("unexpected tags for %s: %v != %v", repo.Named(), tags, expected)
+		}
+	}
+
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, opts); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkTags(ciRepo, []string{"latest", "pr-1", "pr-2", "pr-3"})
+
+	opts.DryRun = false
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, opts); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	checkTags(ciRepo, []string{"latest", "pr-3"})
+	checkTags(otherRepo, []string{"latest", "pr-1", "pr-2", "pr-3"})
+}
+
+func TestRetentionPolicyProtectTag(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "ci/app")
+	image := uploadRandomSchema2Image(t, repo)
+	for _, tag := range []string{"v1", "pr-1", "pr-2"} {
+		if err := repo.Tags(ctx).Tag(ctx, tag, v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+			t.Fatalf("failed to tag manifest: %v", err)
+		}
+		// ensure tags are ordered by modification time
+		time.Sleep(time.Millisecond)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		RetentionPolicies: []RetentionPolicy{{
+			Repositories: "ci/*",
+			KeepLast:     1,
+		}},
+		ProtectTag: func(repository, tag string) bool {
+			return repository == "ci/app" && strings.HasPrefix(tag, "v")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if expected := []string{"pr-2", "v1"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("unexpected tags: %v != %v", tags, expected)
+	}
+}
+
 func TestTaggedManifestlistWithUntaggedManifest(t *testing.T) {
 	ctx := dcontext.Background()
 	inmemoryDriver := inmemory.New()
@@ -923,3 +1185,147 @@ func TestTaggedManifestlistWithDeletedReference(t *testing.T) {
 		t.Fatalf("Garbage collection affected storage: %d != %d", len(after), 0)
 	}
 }
+
+func TestReferrerLinksRemoved(t *testing.T) {
+	ctx := dcontext.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "referrers")
+	manifestService := makeManifestService(t, repo)
+	subject := uploadRandomOCIImage(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: subject.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	if _, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeEmptyJSON, v1.DescriptorEmptyJSON.Data); err != nil {
+		t.Fatalf("failed to put empty blob: %v", err)
+	}
+	putReferrer := func(artifactType string) digest.Digest {
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned:    specs.Versioned{SchemaVersion: 2},
+			MediaType:    v1.MediaTypeImageManifest,
+			ArtifactType: artifactType,
+			Config:       v1.DescriptorEmptyJSON,
+			Layers:       []v1.Descriptor{v1.DescriptorEmptyJSON},
+			Subject:      &v1.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject.manifestDigest},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := manifestService.Put(ctx, m)
+		if err != nil {
+			t.Fatalf("failed to put referrer: %v", err)
+		}
+		return dgst
+	}
+	linked := func(revision digest.Digest) bool {
+		linkPath, err := pathFor(referrersLinkPathSpec{name: repo.Named().Name(), subject: subject.manifestDigest, revision: revision})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = inmemoryDriver.Stat(ctx, linkPath)
+		return err == nil
+	}
+
+	// deleting a referrer removes its link
+	deleted := putReferrer("application/vnd.example.signature")
+	if !linked(deleted) {
+		t.Fatalf("referrer %s not linked", deleted)
+	}
+	if err := manifestService.Delete(ctx, deleted); err != nil {
+		t.Fatalf("failed to delete referrer: %v", err)
+	}
+	if linked(deleted) {
+		t.Fatalf("link of deleted referrer %s not removed", deleted)
+	}
+
+	// as does sweeping it, along with the links left dangling
+	swept := putReferrer("application/vnd.example.sbom")
+	kept := putReferrer("application/vnd.example.attestation")
+	if err := repo.Tags(ctx).Tag(ctx, "attestation", v1.Descriptor{Digest: kept}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	dangling := digest.FromString("dangling")
+	danglingPath, err := pathFor(referrersLinkPathSpec{name: repo.Named().Name(), subject: subject.manifestDigest, revision: dangling})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, danglingPath, []byte(dangling)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{RemoveUntagged: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if linked(swept) {
+		t.Fatalf("link of swept referrer %s not removed", swept)
+	}
+	if linked(dangling) {
+		t.Fatalf("dangling referrer link %s not removed", dangling)
+	}
+	if !linked(kept) {
+		t.Fatalf("link of tagged referrer %s removed", kept)
+	}
+}
+
+type deleteHookDriver struct {
+	driver.StorageDriver
+	hook func(paths []string)
+}
+
+func (d *deleteHookDriver) DeleteFiles(ctx context.Context, paths []string) error {
+	d.hook(paths)
+	return d.StorageDriver.(driver.BatchDeleter).DeleteFiles(ctx, paths)
+}
+
+func TestBlobLinkedDuringSweep(t *testing.T) {
+	defer func(n int) { sweepBatchSize = n }(sweepBatchSize)
+	sweepBatchSize = 1
+
+	ctx := dcontext.Background()
+	storageDriver := &deleteHookDriver{StorageDriver: inmemory.New(), hook: func([]string) {}}
+
+	tracker := NewLinkTracker()
+	registry := createRegistry(t, storageDriver, TrackLinks(tracker))
+	repo := makeRepository(t, registry, "relinked")
+	image := uploadRandomSchema2Image(t, repo)
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatalf("failed to get manifest service: %v", err)
+	}
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+
+	// link the blob of a later batch once the first batch has been checked
+	// against the tracker, as a push committing concurrently would
+	var linked digest.Digest
+	storageDriver.hook = func(paths []string) {
+		storageDriver.hook = func([]string) {}
+		deleted := path.Base(path.Dir(paths[0]))
+		for dgst := range image.layers {
+			if dgst.Encoded() != deleted {
+				linked = dgst
+				tracker.record(dgst)
+				return
+			}
+		}
+	}
+
+	err = MarkAndSweep(ctx, storageDriver, registry, GCOpts{
+		RemoveUntagged: true,
+		Tracker:        tracker,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	if linked == "" {
+		t.Fatal("no blob was linked during the sweep")
+	}
+	if _, ok := allBlobs(t, registry)[linked]; !ok {
+		t.Fatalf("blob linked during the sweep was deleted: %v", linked)
+	}
+}
@@ -34,6 +34,10 @@ type linkedBlobStore struct {
 	deleteEnabled          bool
 	resumableDigestEnabled bool
 
+	// quotas, if set, accounts the size of the blobs linked by the store
+	// against the quota policies of the repository.
+	quotas *quotaStore
+
 	// linkPath allows one to control the repository blob link set to which
 	// the blob store dispatches. This is required because manifest and layer
 	// blobs have not yet been fully merged. At some point, this functionality
@@ -143,6 +147,10 @@ func (lbs *linkedBlobStore) Create(ctx context.Context, options ...distribution.
 			// Mount successful, no need to initiate an upload session
 			return nil, distribution.ErrBlobMounted{From: opts.Mount.From, Descriptor: desc}
 		}
+		if _, ok := err.(distribution.ErrQuotaExceeded); ok {
+			// An upload of the same blob would fail to commit as well
+			return nil, err
+		}
 	}
 
 	uuid := uuid.NewString()
@@ -215,7 +223,7 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 	}
 
 	// Ensure the blob is available for deletion
-	_, err := lbs.blobAccessController.Stat(ctx, dgst)
+	desc, err := lbs.blobAccessController.Stat(ctx, dgst)
 	if err != nil {
 		return err
 	}
@@ -225,7 +233,7 @@ func (lbs *linkedBlobStore) Delete(ctx context.Context, dgst digest.Digest) erro
 		return err
 	}
 
-	return nil
+	return lbs.quotas.release(ctx, lbs.repository.Named().Name(), desc.Size)
 }
 
 func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.Digest) error) error {
@@ -331,26 +339,48 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical v1.Descripto
 	// since we don't care about the aliases. They are generally unused except
 	// for tarsum but those versions don't care about mediatype.
 
-	// Don't make duplicate links.
-	seenDigests := make(map[digest.Digest]struct{}, len(dgsts))
+	link := func() error {
+		// Don't make duplicate links.
+		seenDigests := make(map[digest.Digest]struct{}, len(dgsts))
 
-	for _, dgst := range dgsts {
-		if _, seen := seenDigests[dgst]; seen {
-			continue
-		}
-		seenDigests[dgst] = struct{}{}
+		for _, dgst := range dgsts {
+			if _, seen := seenDigests[dgst]; seen {
+				continue
+			}
+			seenDigests[dgst] = struct{}{}
 
-		blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
-		if err != nil {
-			return err
-		}
+			blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
+			if err != nil {
+				return err
+			}
 
-		if err := lbs.blobStore.link(ctx, blobLinkPath, canonical.Digest); err != nil {
-			return err
+			if err := lbs.blobStore.link(ctx, blobLinkPath, canonical.Digest); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
 
-	return nil
+	linked := func() (bool, error) {
+		return lbs.linked(ctx, canonical.Digest)
+	}
+	return lbs.quotas.link(ctx, lbs.repository.Named().Name(), canonical.Size, linked, link)
+}
+
+// linked returns true if dgst is already linked into the repository.
+func (lbs *linkedBlobStore) linked(ctx context.Context, dgst digest.Digest) (bool, error) {
+	blobLinkPath, err := lbs.linkPath(lbs.repository.Named().Name(), dgst)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := lbs.driver.Stat(ctx, blobLinkPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
 }
 
 type linkedBlobStatter struct {
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+var errLinkTrackerActive = errors.New("garbage collection already in progress")
+
+// LinkTracker records the digests linked into repositories while a garbage
+// collection is running against the same registry instance. Content linked
+// during the collection is treated as marked, so that pushes which complete
+// while the registry is being collected are never swept.
+//
+// A LinkTracker only observes writes made through the registry it has been
+// configured on with TrackLinks. Writes made by other registry processes
+// sharing the storage backend must be covered by GCOpts.GracePeriod.
+type LinkTracker struct {
+	mu     sync.Mutex
+	linked map[digest.Digest]struct{} // nil unless a collection is running
+}
+
+// NewLinkTracker returns an idle LinkTracker.
+func NewLinkTracker() *LinkTracker {
+	return &LinkTracker{}
+}
+
+// start begins recording links. Only one collection may use the tracker at
+// a time.
+func (lt *LinkTracker) start() error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.linked != nil {
+		return errLinkTrackerActive
+	}
+	lt.linked = make(map[digest.Digest]struct{})
+	return nil
+}
+
+// stop discards the recorded links and stops recording.
+func (lt *LinkTracker) stop() {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.linked = nil
+}
+
+// record notes that dgst has been linked. It is a no-op when no collection
+// is running.
+func (lt *LinkTracker) record(dgst digest.Digest) {
+	if lt == nil {
+		return
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.linked != nil {
+		lt.linked[dgst] = struct{}{}
+	}
+}
+
+// seen returns true if dgst has been linked since the collection started.
+func (lt *LinkTracker) seen(dgst digest.Digest) bool {
+	if lt == nil {
+		return false
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	_, ok := lt.linked[dgst]
+	return ok
+}
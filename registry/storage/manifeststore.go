@@ -47,13 +47,18 @@ type manifestStore struct {
 
 	skipDependencyVerification bool
 
+	referrers *referrersStore
+
 	schema2Handler        ManifestHandler
 	manifestListHandler   ManifestHandler
 	ocischemaHandler      ManifestHandler
 	ocischemaIndexHandler ManifestHandler
 }
 
-var _ distribution.ManifestService = &manifestStore{}
+var (
+	_ distribution.ManifestService    = &manifestStore{}
+	_ distribution.ReferrerEnumerator = &manifestStore{}
+)
 
 func (ms *manifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Exists")
@@ -148,10 +153,14 @@ func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest
 	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 }
 
-// Delete removes the revision of the specified manifest.
+// Delete removes the revision of the specified manifest, and its link in the
+// referrers index of its subject.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
-	return ms.blobStore.Delete(ctx, dgst)
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	return ms.referrers.unlink(ctx, dgst)
 }
 
 func (ms *manifestStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"golang.org/x/sync/errgroup"
+)
+
+// MigrateOpts contains options for Migrate
+type MigrateOpts struct {
+	// Concurrency is the number of files copied in parallel. It defaults to
+	// DefaultConcurrencyLimit.
+	Concurrency int
+
+	// Checkpoint is the path of a local file recording the progress of the
+	// migration. An interrupted migration is resumed from its checkpoint.
+	Checkpoint string
+
+	// Incremental only copies the files modified since the start of the
+	// last migration completed with the same checkpoint.
+	Incremental bool
+
+	Quiet bool
+}
+
+// Migrate copies the registry data from the src storage driver to dst. Blobs
+// are copied first and verified against their digest once written, then the
+// repositories: their layer links, manifest revisions and tags. Uploads in
+// progress are not copied, nor are the removals from src propagated to dst.
+func Migrate(ctx context.Context, src, dst driver.StorageDriver, opts MigrateOpts) error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrencyLimit
+	}
+
+	cp, err := openCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	defer cp.close()
+
+	var since time.Time
+	if opts.Incremental {
+		if cp.since.IsZero() {
+			return fmt.Errorf("incremental migration requires the checkpoint of a completed migration")
+		}
+		since = cp.since
+	}
+
+	var copied, skipped, size int64
+	migrate := func(root string, skip func(fileInfo driver.FileInfo) bool, verify bool) error {
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(concurrency)
+
+		err := src.Walk(gctx, root, func(fileInfo driver.FileInfo) error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			if skip(fileInfo) {
+				return driver.ErrSkipDir
+			}
+			if fileInfo.IsDir() {
+				return nil
+			}
+
+			p := fileInfo.Path()
+			if cp.done(p) || (!since.IsZero() && !fileInfo.ModTime().After(since)) {
+				atomic.AddInt64(&skipped, 1)
+				return nil
+			}
+
+			g.Go(func() error {
+				n, err := migrateFile(gctx, src, dst, p, verify)
+				if err != nil {
+					return err
+				}
+				if !opts.Quiet {
+					emit("copied %s", p)
+				}
+				atomic.AddInt64(&copied, 1)
+				atomic.AddInt64(&size, n)
+				return cp.record(p)
+			})
+			return nil
+		})
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	// blobs are copied before the links pointing at them
+	blobsPath, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return err
+	}
+	err = migrate(blobsPath, func(fileInfo driver.FileInfo) bool { return false }, true)
+	if err != nil {
+		return fmt.Errorf("failed to migrate blobs: %v", err)
+	}
+
+	err = migrate(path.Join(storagePathRoot, storagePathVersion), func(fileInfo driver.FileInfo) bool {
+		if !fileInfo.IsDir() {
+			return false
+		}
+		_, name := path.Split(fileInfo.Path())
+		return fileInfo.Path() == blobsPath || name == "_uploads"
+	}, false)
+	if err != nil {
+		return fmt.Errorf("failed to migrate repositories: %v", err)
+	}
+
+	if err := cp.complete(); err != nil {
+		return err
+	}
+
+	if !opts.Quiet {
+		emit("\n%d files (%d bytes) copied, %d skipped", copied, size, skipped)
+	}
+	return nil
+}
+
+// migrateFile copies the file at p from src to dst, returning its size. If
+// verify is set, p is a blob data file whose copy is checked against its
+// digest.
+func migrateFile(ctx context.Context, src, dst driver.StorageDriver, p string, verify bool) (int64, error) {
+	if !verify {
+		content, err := src.GetContent(ctx, p)
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(content)), dst.PutContent(ctx, p, content)
+	}
+
+	dgst, err := digestFromPath(p)
+	if err != nil {
+		return 0, err
+	}
+
+	rc, err := src.Reader(ctx, p, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	fw, err := dst.Writer(ctx, p, false)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(fw, rc)
+	if err != nil {
+		// nolint:errcheck
+		fw.Cancel(ctx)
+		return 0, err
+	}
+	if err := fw.Commit(ctx); err != nil {
+		return 0, err
+	}
+	if err := fw.Close(); err != nil {
+		return 0, err
+	}
+
+	ok, err := verifyBlob(ctx, dst, dgst)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("blob %s does not match its digest on the destination", dgst)
+	}
+	return n, nil
+}
+
+// checkpoint records the progress of a migration in a local file. The file
+// holds the start time of the last completed migration, the start time of
+// the migration in progress, and the paths it copied:
+//
+//	since <time>
+//	started <time>
+//	<path>
+//	...
+//
+// Once the migration completes, the file only holds its start time as the
+// new since time.
+type checkpoint struct {
+	filename string
+	since    time.Time
+	started  time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	copied map[string]struct{}
+}
+
+// openCheckpoint loads the checkpoint stored in filename, creating it if
+// needed. An empty filename disables checkpointing.
+func openCheckpoint(filename string) (*checkpoint, error) {
+	cp := &checkpoint{
+		filename: filename,
+		copied:   make(map[string]struct{}),
+	}
+	if filename == "" {
+		cp.started = time.Now()
+		return cp, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %v", err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "since "):
+			cp.since, err = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "since "))
+		case strings.HasPrefix(line, "started "):
+			cp.started, err = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "started "))
+		default:
+			cp.copied[line] = struct{}{}
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("invalid checkpoint %s: %v", filename, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	cp.file = f
+
+	if cp.started.IsZero() {
+		cp.started = time.Now()
+		if _, err := fmt.Fprintf(f, "started %s\n", cp.started.Format(time.RFC3339Nano)); err != nil {
+			return nil, fmt.Errorf("failed to write checkpoint: %v", err)
+		}
+	}
+	return cp, nil
+}
+
+// done returns true if the path was copied by the migration being resumed.
+func (cp *checkpoint) done(p string) bool {
+	_, ok := cp.copied[p]
+	return ok
+}
+
+// record records the path as copied.
+func (cp *checkpoint) record(p string) error {
+	if cp.file == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	_, err := fmt.Fprintln(cp.file, p)
+	return err
+}
+
+// complete records the migration as completed.
+func (cp *checkpoint) complete() error {
+	if cp.file == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	if err := cp.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if _, err := fmt.Fprintf(cp.file, "since %s\n", cp.started.Format(time.RFC3339Nano)); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return cp.file.Sync()
+}
+
+func (cp *checkpoint) close() {
+	if cp.file != nil {
+		cp.file.Close()
+	}
+}
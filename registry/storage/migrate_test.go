@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := dcontext.Background()
+	src := inmemory.New()
+	dst := inmemory.New()
+
+	srcRegistry := createRegistry(t, src)
+	srcRepo := makeRepository(t, srcRegistry, "migrate/repo")
+	image := uploadRandomSchema2Image(t, srcRepo)
+	if err := srcRepo.Tags(ctx).Tag(ctx, "v1", v1.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	if err := Migrate(ctx, src, dst, MigrateOpts{Incremental: true, Checkpoint: checkpoint, Quiet: true}); err == nil {
+		t.Fatalf("expected incremental migration without a completed checkpoint to fail")
+	}
+	if err := Migrate(ctx, src, dst, MigrateOpts{Checkpoint: checkpoint, Concurrency: 2, Quiet: true}); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+
+	dstRegistry := createRegistry(t, dst)
+	dstRepo := makeRepository(t, dstRegistry, "migrate/repo")
+	desc, err := dstRepo.Tags(ctx).Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("failed to get migrated tag: %v", err)
+	}
+	if desc.Digest != image.manifestDigest {
+		t.Fatalf("migrated tag points at %s, expected %s", desc.Digest, image.manifestDigest)
+	}
+	for dgst := range image.layers {
+		if _, err := dstRepo.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			t.Fatalf("layer %s not migrated: %v", dgst, err)
+		}
+	}
+	problems, err := Fsck(ctx, dst, dstRegistry, FsckOpts{VerifyBlobs: true, Quiet: true})
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("migrated registry has problems: %v", problems)
+	}
+
+	// an incremental migration only copies the new content
+	updated := uploadRandomSchema2Image(t, srcRepo)
+	if err := srcRepo.Tags(ctx).Tag(ctx, "v1", v1.Descriptor{Digest: updated.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	staleLayer := getAnyKey(image.layers)
+	stalePath, err := pathFor(blobDataPathSpec{digest: staleLayer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Delete(ctx, stalePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(ctx, src, dst, MigrateOpts{Incremental: true, Checkpoint: checkpoint, Quiet: true}); err != nil {
+		t.Fatalf("incremental migration failed: %v", err)
+	}
+	desc, err = dstRepo.Tags(ctx).Get(ctx, "v1")
+	if err != nil {
+		t.Fatalf("failed to get migrated tag: %v", err)
+	}
+	if desc.Digest != updated.manifestDigest {
+		t.Fatalf("migrated tag points at %s, expected %s", desc.Digest, updated.manifestDigest)
+	}
+	for dgst := range updated.layers {
+		if _, err := dstRepo.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			t.Fatalf("layer %s not migrated: %v", dgst, err)
+		}
+	}
+	if _, err := dst.Stat(ctx, stalePath); err == nil {
+		t.Fatalf("unmodified blob %s copied by incremental migration", staleLayer)
+	} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateResume(t *testing.T) {
+	ctx := dcontext.Background()
+	src := inmemory.New()
+	dst := inmemory.New()
+
+	srcRegistry := createRegistry(t, src)
+	srcRepo := makeRepository(t, srcRegistry, "migrate/resume")
+	image := uploadRandomSchema2Image(t, srcRepo)
+
+	// the checkpoint of an interrupted migration which copied one layer
+	layer := getAnyKey(image.layers)
+	layerPath, err := pathFor(blobDataPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	if err := os.WriteFile(checkpoint, []byte("started 2024-01-01T00:00:00Z\n"+layerPath+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(ctx, src, dst, MigrateOpts{Checkpoint: checkpoint, Quiet: true}); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	if _, err := dst.Stat(ctx, layerPath); err == nil {
+		t.Fatalf("blob %s copied again when resuming", layer)
+	}
+	manifestPath, err := pathFor(blobDataPathSpec{digest: image.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Stat(ctx, manifestPath); err != nil {
+		t.Fatalf("manifest not migrated: %v", err)
+	}
+
+	content, err := os.ReadFile(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "since 2024-01-01T00:00:00Z\n" {
+		t.Fatalf("unexpected checkpoint after completion: %q", content)
+	}
+}
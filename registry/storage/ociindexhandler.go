@@ -2,16 +2,19 @@ package storage
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/internal/dcontext"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // ocischemaIndexHandler is a ManifestHandler that covers the OCI Image Index.
 type ocischemaIndexHandler struct {
 	*manifestListHandler
+	referrers *referrersStore
 }
 
 var _ ManifestHandler = &manifestListHandler{}
@@ -26,3 +29,42 @@ func (ms *ocischemaIndexHandler) Unmarshal(ctx context.Context, dgst digest.Dige
 
 	return m, nil
 }
+
+func (ms *ocischemaIndexHandler) Put(ctx context.Context, manifest distribution.Manifest, skipDependencyVerification bool) (digest.Digest, error) {
+	dcontext.GetLogger(ms.ctx).Debug("(*ociIndexHandler).Put")
+
+	m, ok := manifest.(*ocischema.DeserializedImageIndex)
+	if !ok {
+		return "", fmt.Errorf("non-ocischema index put to ocischemaIndexHandler: %T", manifest)
+	}
+
+	if m.Subject != nil {
+		if err := m.Subject.Digest.Validate(); err != nil {
+			return "", distribution.ErrManifestVerification{err}
+		}
+	}
+
+	dgst, err := ms.manifestListHandler.Put(ctx, manifest, skipDependencyVerification)
+	if err != nil {
+		return "", err
+	}
+
+	if m.Subject != nil {
+		mt, payload, err := m.Payload()
+		if err != nil {
+			return "", err
+		}
+
+		desc := v1.Descriptor{
+			MediaType: mt,
+			Digest:    dgst,
+			Size:      int64(len(payload)),
+		}
+		if err := ms.referrers.link(ctx, m.Subject.Digest, desc); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error linking referrer into subject index: %v", err)
+			return "", err
+		}
+	}
+
+	return dgst, nil
+}
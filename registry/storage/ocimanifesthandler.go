@@ -16,6 +16,7 @@ import (
 type ocischemaManifestHandler struct {
 	repository   distribution.Repository
 	blobStore    distribution.BlobStore
+	referrers    *referrersStore
 	ctx          context.Context
 	manifestURLs manifestURLs
 }
@@ -56,6 +57,13 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 		return "", err
 	}
 
+	if m.Subject != nil {
+		if err := ms.referrers.link(ctx, m.Subject.Digest, revision); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error linking referrer into subject index: %v", err)
+			return "", err
+		}
+	}
+
 	return revision.Digest, nil
 }
 
@@ -69,6 +77,12 @@ func (ms *ocischemaManifestHandler) verifyManifest(ctx context.Context, mnfst oc
 		return fmt.Errorf("unrecognized manifest schema version %d", mnfst.Manifest.SchemaVersion)
 	}
 
+	if mnfst.Subject != nil {
+		if err := mnfst.Subject.Digest.Validate(); err != nil {
+			return distribution.ErrManifestVerification{err}
+		}
+	}
+
 	if skipDependencyVerification {
 		return nil
 	}
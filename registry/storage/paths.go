@@ -35,6 +35,10 @@ const (
 //	        │   ├── revisions
 //	        │   │   └── <manifest digest path>
 //	        │   │       └── link
+//	        │   ├── referrers
+//	        │   │   └── <subject digest path>
+//	        │   │       └── <manifest digest path>
+//	        │   │           └── link
 //	        │   └── tags
 //	        │       └── <tag>
 //	        │           ├── current
@@ -73,7 +77,9 @@ const (
 // implied as to the ordering of changes to a manifest. The tag store provides
 // support for name, tag lookups of manifests, using "current/link" under a
 // named tag directory. An index is maintained to support deletions of all
-// revisions of a given manifest tag.
+// revisions of a given manifest tag. Manifests which declare a subject are
+// additionally linked into the referrers index, keyed by the digest of that
+// subject, to support the referrers API.
 //
 // We cover the path formats implemented by this path mapper below.
 //
@@ -88,6 +94,12 @@ const (
 //	manifestRevisionPathSpec:      <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/
 //	manifestRevisionLinkPathSpec:  <root>/v2/repositories/<name>/_manifests/revisions/<algorithm>/<hex digest>/link
 //
+//	Referrers:
+//
+//	referrersRootPathSpec:         <root>/v2/repositories/<name>/_manifests/referrers/
+//	referrersPathSpec:             <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/
+//	referrersLinkPathSpec:         <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/<algorithm>/<hex digest>/link
+//
 //	Tags:
 //
 //	manifestTagsPathSpec:                  <root>/v2/repositories/<name>/_manifests/tags/
@@ -108,6 +120,11 @@ const (
 //	uploadStartedAtPathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/startedat
 //	uploadHashStatePathSpec:        <root>/v2/repositories/<name>/_uploads/<id>/hashstates/<algorithm>/<offset>
 //
+//	Usage:
+//
+//	repositoryUsagePathSpec:        <root>/v2/repositories/<name>/_usage/size
+//	namespaceUsagePathSpec:         <root>/v2/usage/<hex digest of prefix>/size
+//
 //	Blob Store:
 //
 //	blobsPathSpec:                  <root>/v2/blobs/
@@ -154,6 +171,30 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, "link"), nil
+	case referrersRootPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "referrers")...), nil
+	case referrersPathSpec:
+		components, err := digestPathComponents(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_manifests", "referrers"), components...)...), nil
+	case referrersLinkPathSpec:
+		root, err := pathFor(referrersPathSpec{
+			name:    v.name,
+			subject: v.subject,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "link"), nil
 	case manifestTagsPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_manifests", "tags")...), nil
 	case manifestTagPathSpec:
@@ -250,6 +291,10 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case repositoryUsagePathSpec:
+		return path.Join(append(repoPrefix, v.name, "_usage", "size")...), nil
+	case namespaceUsagePathSpec:
+		return path.Join(append(rootPrefix, "usage", digest.FromString(v.prefix).Encoded(), "size")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -298,6 +343,34 @@ type manifestRevisionLinkPathSpec struct {
 
 func (manifestRevisionLinkPathSpec) pathSpec() {}
 
+// referrersRootPathSpec describes the directory path of the referrers index
+// of a repository.
+type referrersRootPathSpec struct {
+	name string
+}
+
+func (referrersRootPathSpec) pathSpec() {}
+
+// referrersPathSpec describes the directory path of the referrers index for
+// a given subject manifest.
+type referrersPathSpec struct {
+	name    string
+	subject digest.Digest
+}
+
+func (referrersPathSpec) pathSpec() {}
+
+// referrersLinkPathSpec describes the link recording that the manifest
+// revision declares the given subject. The contents of this file should just
+// be the digest of the referring manifest.
+type referrersLinkPathSpec struct {
+	name     string
+	subject  digest.Digest
+	revision digest.Digest
+}
+
+func (referrersLinkPathSpec) pathSpec() {}
+
 // manifestTagsPathSpec describes the path elements required to point to the
 // manifest tags directory.
 type manifestTagsPathSpec struct {
@@ -450,6 +523,23 @@ type repositoriesRootPathSpec struct{}
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// repositoryUsagePathSpec describes the file recording the size of the
+// blobs linked into the named repository.
+type repositoryUsagePathSpec struct {
+	name string
+}
+
+func (repositoryUsagePathSpec) pathSpec() {}
+
+// namespaceUsagePathSpec describes the file recording the size of the blobs
+// linked into all repositories starting with prefix. As prefix may be empty
+// or end with a slash, it is identified by its digest.
+type namespaceUsagePathSpec struct {
+	prefix string
+}
+
+func (namespaceUsagePathSpec) pathSpec() {}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
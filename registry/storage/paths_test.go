@@ -68,6 +68,27 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
+		{
+			spec: referrersRootPathSpec{
+				name: "foo/bar",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers",
+		},
+		{
+			spec: referrersPathSpec{
+				name:    "foo/bar",
+				subject: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec: referrersLinkPathSpec{
+				name:     "foo/bar",
+				subject:  "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				revision: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/link",
+		},
 
 		{
 			spec: uploadDataPathSpec{
@@ -87,6 +108,14 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec:     repositoryUsagePathSpec{name: "foo/bar"},
+			expected: "/docker/registry/v2/repositories/foo/bar/_usage/size",
+		},
+		{
+			spec:     namespaceUsagePathSpec{prefix: "team-a/"},
+			expected: "/docker/registry/v2/usage/05a5166a61e41c30be46ea83e7254d3da893e00790be93ef3a14f5f8134c4cc0/size",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
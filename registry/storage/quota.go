@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// QuotaPolicy limits the size of the blobs and manifests linked into the
+// repositories whose name starts with Prefix. Blobs shared by several
+// repositories are counted once for each repository they are linked into.
+type QuotaPolicy struct {
+	// Prefix selects the repositories the policy applies to. An empty
+	// prefix applies to all repositories.
+	Prefix string
+
+	// Limit is the maximum size, in bytes, of the blobs linked into all the
+	// repositories selected by the policy. Zero means no limit.
+	Limit int64
+
+	// RepositoryLimit is the maximum size, in bytes, of the blobs linked into
+	// each repository selected by the policy. Zero means no limit.
+	RepositoryLimit int64
+}
+
+// ParseQuotaPolicies parses the storage.quota.policies configuration, a list
+// of policies each with the keys "prefix", "limit" and "repositorylimit".
+func ParseQuotaPolicies(config interface{}) ([]QuotaPolicy, error) {
+	entries, ok := config.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("quota policies must be a list")
+	}
+
+	policies := make([]QuotaPolicy, 0, len(entries))
+	for i, entry := range entries {
+		params, ok := entry.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("quota policy %d must contain additional keys", i)
+		}
+
+		var policy QuotaPolicy
+		for k, v := range params {
+			switch k {
+			case "prefix":
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("quota policy %d: prefix is not a string", i)
+				}
+				policy.Prefix = s
+			case "limit", "repositorylimit":
+				var n int64
+				switch v := v.(type) {
+				case int:
+					n = int64(v)
+				case int64:
+					n = v
+				default:
+					return nil, fmt.Errorf("quota policy %d: %v is not an integer", i, k)
+				}
+				if n < 0 {
+					return nil, fmt.Errorf("quota policy %d: %v must not be negative", i, k)
+				}
+				if k == "limit" {
+					policy.Limit = n
+				} else {
+					policy.RepositoryLimit = n
+				}
+			default:
+				return nil, fmt.Errorf("quota policy %d: unknown key %v", i, k)
+			}
+		}
+
+		if policy.Limit == 0 && policy.RepositoryLimit == 0 {
+			return nil, fmt.Errorf("quota policy %d: limit or repositorylimit must be set", i)
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// quotaStore enforces quota policies. The usage of each repository, and of
+// each policy with a Limit, is recorded in the storage driver and updated
+// whenever a layer link or a manifest revision is added or removed, so that
+// checks never need to walk the repositories.
+//
+// Updates are serialized within a process only. Registry instances sharing
+// the same storage may race, making the recorded usage approximate.
+type quotaStore struct {
+	driver   driver.StorageDriver
+	policies []QuotaPolicy
+
+	mu sync.Mutex
+}
+
+// matching returns the policies applying to the named repository.
+func (qs *quotaStore) matching(name string) []QuotaPolicy {
+	var policies []QuotaPolicy
+	for _, policy := range qs.policies {
+		if strings.HasPrefix(name, policy.Prefix) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// check returns ErrQuotaExceeded if linking size more bytes into the named
+// repository would exceed any applicable policy.
+func (qs *quotaStore) check(ctx context.Context, name string, size int64) error {
+	if qs == nil {
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	return qs.checkLocked(ctx, name, size)
+}
+
+func (qs *quotaStore) checkLocked(ctx context.Context, name string, size int64) error {
+	policies := qs.matching(name)
+	if len(policies) == 0 {
+		return nil
+	}
+
+	var repositoryUsage int64 = -1
+	for _, policy := range policies {
+		if policy.RepositoryLimit > 0 {
+			if repositoryUsage < 0 {
+				var err error
+				repositoryUsage, err = qs.read(ctx, repositoryUsagePathSpec{name: name})
+				if err != nil {
+					return err
+				}
+			}
+			if repositoryUsage+size > policy.RepositoryLimit {
+				return distribution.ErrQuotaExceeded{Name: name, Prefix: policy.Prefix, Limit: policy.RepositoryLimit}
+			}
+		}
+
+		if policy.Limit > 0 {
+			usage, err := qs.read(ctx, namespaceUsagePathSpec{prefix: policy.Prefix})
+			if err != nil {
+				return err
+			}
+			if usage+size > policy.Limit {
+				return distribution.ErrQuotaExceeded{Name: name, Prefix: policy.Prefix, Limit: policy.Limit}
+			}
+		}
+	}
+
+	return nil
+}
+
+// link calls link to link size more bytes into the named repository,
+// recording them as used unless linked returns true, once checked against the
+// applicable policies. The check, the link and the update are serialized, so
+// that concurrent pushes of the same content record it once.
+func (qs *quotaStore) link(ctx context.Context, name string, size int64, linked func() (bool, error), link func() error) error {
+	if qs == nil || len(qs.matching(name)) == 0 {
+		return link()
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	ok, err := linked()
+	if err != nil {
+		return err
+	}
+	if ok {
+		return link()
+	}
+
+	if err := qs.checkLocked(ctx, name, size); err != nil {
+		return err
+	}
+	if err := link(); err != nil {
+		return err
+	}
+	return qs.addLocked(ctx, name, size)
+}
+
+// release records size bytes as no longer used by the named repository.
+func (qs *quotaStore) release(ctx context.Context, name string, size int64) error {
+	if qs == nil {
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	return qs.addLocked(ctx, name, -size)
+}
+
+// releaseRepository records the named repository as removed, releasing all
+// the usage recorded for it.
+func (qs *quotaStore) releaseRepository(ctx context.Context, name string) error {
+	if qs == nil {
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	usage, err := qs.read(ctx, repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	return qs.addLocked(ctx, name, -usage)
+}
+
+// setRepository records usage as the usage of the named repository, such as
+// once recounted from the blobs linked into it.
+func (qs *quotaStore) setRepository(ctx context.Context, name string, usage int64) error {
+	if qs == nil {
+		return nil
+	}
+
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	current, err := qs.read(ctx, repositoryUsagePathSpec{name: name})
+	if err != nil {
+		return err
+	}
+	return qs.addLocked(ctx, name, usage-current)
+}
+
+func (qs *quotaStore) addLocked(ctx context.Context, name string, delta int64) error {
+	policies := qs.matching(name)
+	if len(policies) == 0 || delta == 0 {
+		return nil
+	}
+
+	if err := qs.add(ctx, repositoryUsagePathSpec{name: name}, delta); err != nil {
+		return err
+	}
+
+	// policies sharing a prefix share the namespace usage
+	added := make(map[string]struct{})
+	for _, policy := range policies {
+		if _, ok := added[policy.Prefix]; ok || policy.Limit == 0 {
+			continue
+		}
+		if err := qs.add(ctx, namespaceUsagePathSpec{prefix: policy.Prefix}, delta); err != nil {
+			return err
+		}
+		added[policy.Prefix] = struct{}{}
+	}
+	return nil
+}
+
+func (qs *quotaStore) add(ctx context.Context, spec pathSpec, delta int64) error {
+	usage, err := qs.read(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	usage += delta
+	if usage < 0 {
+		usage = 0
+	}
+
+	p, err := pathFor(spec)
+	if err != nil {
+		return err
+	}
+	return qs.driver.PutContent(ctx, p, []byte(strconv.FormatInt(usage, 10)))
+}
+
+// read returns the usage recorded at spec, or zero if none is.
+func (qs *quotaStore) read(ctx context.Context, spec pathSpec) (int64, error) {
+	p, err := pathFor(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := qs.driver.GetContent(ctx, p)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(content), 10, 64)
+}
@@ -0,0 +1,299 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestParseQuotaPolicies(t *testing.T) {
+	policies, err := ParseQuotaPolicies([]interface{}{
+		map[interface{}]interface{}{
+			"prefix":          "team-a/",
+			"limit":           1000,
+			"repositorylimit": 100,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error parsing quota policies: %v", err)
+	}
+
+	expected := QuotaPolicy{Prefix: "team-a/", Limit: 1000, RepositoryLimit: 100}
+	if len(policies) != 1 || policies[0] != expected {
+		t.Fatalf("unexpected policies: %+v", policies)
+	}
+
+	for _, invalid := range []interface{}{
+		map[interface{}]interface{}{"prefix": "team-a/"},
+		[]interface{}{map[interface{}]interface{}{"prefix": "team-a/"}},
+		[]interface{}{map[interface{}]interface{}{"prefix": "team-a/", "limit": "1GB"}},
+		[]interface{}{map[interface{}]interface{}{"prefix": "team-a/", "limit": -1}},
+		[]interface{}{map[interface{}]interface{}{"prefix": "team-a/", "size": 1}},
+	} {
+		if _, err := ParseQuotaPolicies(invalid); err == nil {
+			t.Fatalf("expected error parsing %v", invalid)
+		}
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	ctx := dcontext.Background()
+	driver := inmemory.New()
+	registry := createRegistry(t, driver, Quotas(
+		QuotaPolicy{Prefix: "team-a/", Limit: 250},
+		QuotaPolicy{Prefix: "team-a/", RepositoryLimit: 150},
+	))
+
+	first := makeRepository(t, registry, "team-a/first")
+	second := makeRepository(t, registry, "team-a/second")
+	other := makeRepository(t, registry, "team-b/other")
+
+	var firstDigest digest.Digest
+	put := func(repo distribution.Repository, size int, fill byte) error {
+		p := make([]byte, size)
+		for i := range p {
+			p[i] = fill
+		}
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", p)
+		if repo == first && err == nil {
+			firstDigest = desc.Digest
+		}
+		return err
+	}
+
+	if err := put(first, 100, 'a'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// linking the same blob again is not accounted twice
+	if err := put(first, 100, 'a'); err != nil {
+		t.Fatalf("unexpected error putting existing blob: %v", err)
+	}
+
+	// repository limit
+	err := put(first, 100, 'b')
+	if _, ok := err.(distribution.ErrQuotaExceeded); !ok {
+		t.Fatalf("expected repository quota to be exceeded, got %v", err)
+	}
+
+	if err := put(second, 100, 'c'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// namespace limit
+	err = put(second, 60, 'd')
+	if _, ok := err.(distribution.ErrQuotaExceeded); !ok {
+		t.Fatalf("expected namespace quota to be exceeded, got %v", err)
+	}
+
+	// mounting is accounted as well
+	canonical, _ := reference.WithDigest(first.Named(), firstDigest)
+	_, err = second.Blobs(ctx).Create(ctx, WithMountFrom(canonical))
+	if _, ok := err.(distribution.ErrQuotaExceeded); !ok {
+		t.Fatalf("expected mount to exceed quota, got %v", err)
+	}
+
+	// repositories outside the prefix are not limited
+	if err := put(other, 1000, 'e'); err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+
+	// deleting releases the quota
+	if err := first.Blobs(ctx).Delete(ctx, firstDigest); err != nil {
+		t.Fatalf("unexpected error deleting blob: %v", err)
+	}
+	if err := put(second, 50, 'd'); err != nil {
+		t.Fatalf("unexpected error putting blob after release: %v", err)
+	}
+
+	// removing a repository releases its quota
+	remover := registry.(distribution.RepositoryRemover)
+	if err := remover.Remove(ctx, second.Named()); err != nil {
+		t.Fatalf("unexpected error removing repository: %v", err)
+	}
+	if err := put(first, 150, 'f'); err != nil {
+		t.Fatalf("unexpected error putting blob after repository removal: %v", err)
+	}
+}
+
+func TestQuotaManifests(t *testing.T) {
+	ctx := dcontext.Background()
+	driver := inmemory.New()
+	registry := createRegistry(t, driver, Quotas(QuotaPolicy{RepositoryLimit: 1 << 30}))
+	quotas := namespaceQuotas(registry)
+	repo := makeRepository(t, registry, "quota/manifests")
+
+	usage := func() int64 {
+		n, err := quotas.read(ctx, repositoryUsagePathSpec{name: repo.Named().Name()})
+		if err != nil {
+			t.Fatalf("failed to read usage: %v", err)
+		}
+		return n
+	}
+	size := func(dgsts ...digest.Digest) int64 {
+		var n int64
+		for _, dgst := range dgsts {
+			desc, err := registry.BlobStatter().Stat(ctx, dgst)
+			if err != nil {
+				t.Fatalf("failed to stat blob %s: %v", dgst, err)
+			}
+			n += desc.Size
+		}
+		return n
+	}
+
+	// the manifests are accounted along with their layers
+	kept := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", v1.Descriptor{Digest: kept.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	keptLayers := getKeys(kept.layers)
+	keptUsage := size(append(keptLayers, kept.manifestDigest)...)
+	if got := usage(); got != keptUsage {
+		t.Fatalf("expected usage %d, got %d", keptUsage, got)
+	}
+
+	// a manifest is refused once it would exceed the quota
+	layers, err := testutil.CreateRandomLayers(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repo, layers); err != nil {
+		t.Fatalf("layer upload failed: %v", err)
+	}
+	untagged, err := testutil.MakeSchema2Manifest(repo, getKeys(layers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layersUsage := size(getKeys(layers)...)
+	limited := createRegistry(t, driver, Quotas(QuotaPolicy{RepositoryLimit: keptUsage + layersUsage + 1}))
+	_, err = makeManifestService(t, makeRepository(t, limited, repo.Named().Name())).Put(ctx, untagged)
+	if _, ok := err.(distribution.ErrQuotaExceeded); !ok {
+		t.Fatalf("expected manifest to exceed quota, got %v", err)
+	}
+
+	// garbage collection releases the manifests and the layers swept
+	untaggedDigest, err := makeManifestService(t, repo).Put(ctx, untagged)
+	if err != nil {
+		t.Fatalf("manifest upload failed: %v", err)
+	}
+	if got, want := usage(), keptUsage+layersUsage+size(untaggedDigest); got != want {
+		t.Fatalf("expected usage %d, got %d", want, got)
+	}
+	if err := MarkAndSweep(ctx, driver, registry, GCOpts{RemoveUntagged: true, Quiet: true}); err != nil {
+		t.Fatalf("failed mark and sweep: %v", err)
+	}
+	if got := usage(); got != keptUsage {
+		t.Fatalf("expected usage %d after collection, got %d", keptUsage, got)
+	}
+
+	// repairing the storage recounts the usage without the dangling links
+	missing := keptLayers[0]
+	missingUsage := size(missing)
+	manifestUsage := size(kept.manifestDigest)
+	blobPath, err := pathFor(blobPathSpec{digest: missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Delete(ctx, blobPath); err != nil {
+		t.Fatalf("failed to delete blob: %v", err)
+	}
+	if _, err := Fsck(ctx, driver, registry, FsckOpts{Repair: true, Quiet: true}); err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if got, want := usage(), keptUsage-missingUsage; got != want {
+		t.Fatalf("expected usage %d after repair, got %d", want, got)
+	}
+
+	// deleting a manifest releases it
+	if err := makeManifestService(t, repo).Delete(ctx, kept.manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+	if got, want := usage(), keptUsage-missingUsage-manifestUsage; got != want {
+		t.Fatalf("expected usage %d after manifest deletion, got %d", want, got)
+	}
+}
+
+// statHookDriver calls hook once the paths are stated.
+type statHookDriver struct {
+	driver.StorageDriver
+	hook func(path string)
+}
+
+func (d *statHookDriver) Stat(ctx context.Context, path string) (driver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	d.hook(path)
+	return fi, err
+}
+
+func TestQuotaConcurrentPushes(t *testing.T) {
+	ctx := dcontext.Background()
+
+	// hold the first push once it checked whether the layer is linked until
+	// the second push checks as well, unless the second push waits for it
+	var stats atomic.Int32
+	checked := make(chan struct{})
+	storageDriver := &statHookDriver{StorageDriver: inmemory.New(), hook: func(p string) {
+		if !strings.Contains(p, "/_layers/") || path.Base(p) != "link" {
+			return
+		}
+		switch stats.Add(1) {
+		case 1:
+			select {
+			case <-checked:
+			case <-time.After(100 * time.Millisecond):
+			}
+		case 2:
+			close(checked)
+		}
+	}}
+	registry := createRegistry(t, storageDriver, Quotas(QuotaPolicy{Prefix: "team-a/", Limit: 1000}))
+	quotas := namespaceQuotas(registry)
+	repo := makeRepository(t, registry, "team-a/concurrent")
+
+	p := make([]byte, 100)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", p)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error putting blob: %v", err)
+		}
+	}
+
+	// the layer pushed concurrently is accounted once
+	for _, spec := range []pathSpec{
+		repositoryUsagePathSpec{name: repo.Named().Name()},
+		namespaceUsagePathSpec{prefix: "team-a/"},
+	} {
+		usage, err := quotas.read(ctx, spec)
+		if err != nil {
+			t.Fatalf("failed to read usage: %v", err)
+		}
+		if usage != int64(len(p)) {
+			t.Fatalf("expected usage %d at %T, got %d", len(p), spec, usage)
+		}
+	}
+}
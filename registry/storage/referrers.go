@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/internal/dcontext"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrersStore maintains the index of manifests which declare a subject.
+// Links are kept per subject under the _manifests/referrers directory of the
+// repository so that the referrers of a manifest can be listed without
+// walking every revision.
+type referrersStore struct {
+	repository *repository
+	blobStore  *blobStore
+}
+
+// linkedBlobStore returns the linkedBlobStore for the referrers of subject.
+// Only referrers which are still linked as revisions of the repository are
+// visible through the returned store.
+func (rs *referrersStore) linkedBlobStore(ctx context.Context, subject digest.Digest) *linkedBlobStore {
+	return &linkedBlobStore{
+		blobStore: rs.blobStore,
+		blobAccessController: &linkedBlobStatter{
+			blobStore:  rs.blobStore,
+			repository: rs.repository,
+			linkPath:   manifestRevisionLinkPath,
+		},
+		repository: rs.repository,
+		ctx:        ctx,
+		linkPath: func(name string, dgst digest.Digest) (string, error) {
+			return pathFor(referrersLinkPathSpec{
+				name:     name,
+				subject:  subject,
+				revision: dgst,
+			})
+		},
+		linkDirectoryPathSpec: referrersPathSpec{
+			name:    rs.repository.Named().Name(),
+			subject: subject,
+		},
+	}
+}
+
+// link records that the manifest identified by desc refers to subject.
+func (rs *referrersStore) link(ctx context.Context, subject digest.Digest, desc v1.Descriptor) error {
+	return rs.linkedBlobStore(ctx, subject).linkBlob(ctx, desc)
+}
+
+// unlink removes the link recording that the manifest revision refers to
+// its subject, if it declares one, once the revision is removed.
+func (rs *referrersStore) unlink(ctx context.Context, revision digest.Digest) error {
+	return removeReferrerLink(ctx, rs.blobStore.driver, rs.repository.Named().Name(), revision)
+}
+
+// removeReferrerLink removes the link of the manifest revision from the
+// referrers index of the named repository, if the manifest declares a
+// subject. The manifest is read from the blob store, so the link must be
+// removed before the blob is.
+func removeReferrerLink(ctx context.Context, driver storagedriver.StorageDriver, name string, revision digest.Digest) error {
+	blobPath, err := pathFor(blobDataPathSpec{digest: revision})
+	if err != nil {
+		return err
+	}
+	payload, err := driver.GetContent(ctx, blobPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	// only the subject of the manifest is needed, whatever its type
+	var m struct {
+		Subject *v1.Descriptor `json:"subject,omitempty"`
+	}
+	if err := json.Unmarshal(payload, &m); err != nil || m.Subject == nil || m.Subject.Digest.Validate() != nil {
+		return nil
+	}
+
+	linkPath, err := pathFor(referrersLinkPathSpec{
+		name:     name,
+		subject:  m.Subject.Digest,
+		revision: revision,
+	})
+	if err != nil {
+		return err
+	}
+	err = driver.Delete(ctx, path.Dir(linkPath))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// Enumerate calls ingester with the digest of each manifest referring to
+// subject. A subject without any referrers is not an error.
+func (rs *referrersStore) Enumerate(ctx context.Context, subject digest.Digest, ingester func(digest.Digest) error) error {
+	err := rs.linkedBlobStore(ctx, subject).Enumerate(ctx, ingester)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// Referrers calls ingester with a descriptor for each manifest in the
+// repository whose subject is the given digest. The descriptors carry the
+// artifact type and annotations of the referring manifest, as required for
+// the entries of a referrers response.
+func (ms *manifestStore) Referrers(ctx context.Context, subject digest.Digest, ingester func(v1.Descriptor) error) error {
+	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Referrers")
+
+	return ms.referrers.Enumerate(ctx, subject, func(dgst digest.Digest) error {
+		manifest, err := ms.Get(ctx, dgst)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				return nil
+			}
+			return err
+		}
+
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return err
+		}
+
+		desc := v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    dgst,
+			Size:      int64(len(payload)),
+		}
+
+		switch m := manifest.(type) {
+		case *ocischema.DeserializedManifest:
+			desc.ArtifactType = m.ArtifactType
+			if desc.ArtifactType == "" {
+				desc.ArtifactType = m.Config.MediaType
+			}
+			desc.Annotations = m.Annotations
+		case *ocischema.DeserializedImageIndex:
+			desc.ArtifactType = m.ArtifactType
+			desc.Annotations = m.Annotations
+		}
+
+		return ingester(desc)
+	})
+}
@@ -27,6 +27,7 @@ type registry struct {
 	resumableDigestEnabled       bool
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	driver                       storagedriver.StorageDriver
+	quotas                       *quotaStore
 
 	// Validation
 	manifestURLs         manifestURLs
@@ -122,6 +123,32 @@ func AddValidateImageIndexImagesExistPlatform(architecture string, os string) Re
 	}
 }
 
+// TrackLinks returns a functional option for NewRegistry. It records the
+// content linked through the registry with the given tracker, allowing
+// MarkAndSweep to run while the registry accepts writes.
+func TrackLinks(tracker *LinkTracker) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobStore.tracker = tracker
+		return nil
+	}
+}
+
+// Quotas returns a functional option for NewRegistry. It enforces the given
+// quota policies on the blobs linked into repositories.
+func Quotas(policies ...QuotaPolicy) RegistryOption {
+	return func(registry *registry) error {
+		if len(policies) == 0 {
+			registry.quotas = nil
+			return nil
+		}
+		registry.quotas = &quotaStore{
+			driver:   registry.driver,
+			policies: policies,
+		}
+		return nil
+	}
+}
+
 // BlobDescriptorServiceFactory returns a functional option for NewRegistry. It sets the
 // factory to create BlobDescriptorServiceFactory middleware.
 func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFactory) RegistryOption {
@@ -280,6 +307,12 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		// manifests. This instance cannot be used for blob checks.
 		linkPath:              manifestRevisionLinkPath,
 		linkDirectoryPathSpec: manifestDirectoryPathSpec,
+		quotas:                repo.registry.quotas,
+	}
+
+	referrers := &referrersStore{
+		repository: repo,
+		blobStore:  repo.blobStore,
 	}
 
 	manifestListHandler := &manifestListHandler{
@@ -293,6 +326,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		ctx:        ctx,
 		repository: repo,
 		blobStore:  blobStore,
+		referrers:  referrers,
 		schema2Handler: &schema2ManifestHandler{
 			ctx:          ctx,
 			repository:   repo,
@@ -304,10 +338,12 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 			ctx:          ctx,
 			repository:   repo,
 			blobStore:    blobStore,
+			referrers:    referrers,
 			manifestURLs: repo.registry.manifestURLs,
 		},
 		ocischemaIndexHandler: &ocischemaIndexHandler{
 			manifestListHandler: manifestListHandler,
+			referrers:           referrers,
 		},
 	}
 
@@ -354,5 +390,6 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		linkDirectoryPathSpec:  layersPathSpec{name: repo.name.Name()},
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
+		quotas:                 repo.registry.quotas,
 	}
 }
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// RetentionPolicy describes which tags of a set of repositories are kept by
+// garbage collection. A tag is kept if it matches Protect, if it is one of
+// the KeepLast most recently updated unprotected tags, or if it was updated
+// less than MaxAge ago. A policy without KeepLast and MaxAge keeps all tags.
+type RetentionPolicy struct {
+	// Repositories is a pattern, as accepted by path.Match, selecting the
+	// repositories the policy applies to.
+	Repositories string
+
+	// KeepLast is the number of most recently updated tags to keep.
+	KeepLast int
+
+	// MaxAge is the age after which tags are eligible for deletion.
+	MaxAge time.Duration
+
+	// Protect matches tags which are never deleted.
+	Protect *regexp.Regexp
+}
+
+// ParseRetentionPolicies parses the storage.maintenance.retention
+// configuration, a list of policies each with the keys "repositories",
+// "keeplast", "maxage" and "protect".
+func ParseRetentionPolicies(config interface{}) ([]RetentionPolicy, error) {
+	entries, ok := config.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("retention policies must be a list")
+	}
+
+	policies := make([]RetentionPolicy, 0, len(entries))
+	for i, entry := range entries {
+		params, ok := entry.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("retention policy %d must contain additional keys", i)
+		}
+
+		var policy RetentionPolicy
+		for k, v := range params {
+			switch k {
+			case "repositories":
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("retention policy %d: repositories is not a string", i)
+				}
+				if _, err := path.Match(s, ""); err != nil {
+					return nil, fmt.Errorf("retention policy %d: invalid repositories pattern %q: %v", i, s, err)
+				}
+				policy.Repositories = s
+			case "keeplast":
+				n, ok := v.(int)
+				if !ok || n < 0 {
+					return nil, fmt.Errorf("retention policy %d: keeplast must be a non-negative integer", i)
+				}
+				policy.KeepLast = n
+			case "maxage":
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("retention policy %d: maxage is not a string", i)
+				}
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return nil, fmt.Errorf("retention policy %d: cannot parse maxage: %v", i, err)
+				}
+				policy.MaxAge = d
+			case "protect":
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("retention policy %d: protect is not a string", i)
+				}
+				re, err := regexp.Compile(s)
+				if err != nil {
+					return nil, fmt.Errorf("retention policy %d: invalid protect expression: %v", i, err)
+				}
+				policy.Protect = re
+			default:
+				return nil, fmt.Errorf("retention policy %d: unknown key %v", i, k)
+			}
+		}
+
+		if policy.Repositories == "" {
+			return nil, fmt.Errorf("retention policy %d: repositories missing", i)
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// retentionPolicyFor returns the first policy applying to the repository
+// name, or nil if none does.
+func retentionPolicyFor(policies []RetentionPolicy, name string) *RetentionPolicy {
+	for i := range policies {
+		if ok, _ := path.Match(policies[i].Repositories, name); ok {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// expiredTags returns the tags of the repository which are not retained by
+// policy. Tags updated after notBefore and the tags for which protect, if
+// not nil, returns true are always retained.
+func expiredTags(ctx context.Context, storageDriver driver.StorageDriver, repository distribution.Repository, policy *RetentionPolicy, protect func(repository, tag string) bool, now, notBefore time.Time) ([]string, error) {
+	if policy.KeepLast == 0 && policy.MaxAge == 0 {
+		return nil, nil
+	}
+
+	name := repository.Named().Name()
+	tags, err := repository.Tags(ctx).All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type taggedAt struct {
+		tag     string
+		modTime time.Time
+	}
+
+	candidates := make([]taggedAt, 0, len(tags))
+	for _, tag := range tags {
+		if policy.Protect != nil && policy.Protect.MatchString(tag) {
+			continue
+		}
+		if protect != nil && protect(name, tag) {
+			continue
+		}
+
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := storageDriver.Stat(ctx, currentPath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		candidates = append(candidates, taggedAt{tag: tag, modTime: fi.ModTime()})
+	}
+
+	// newest first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].modTime.After(candidates[j].modTime)
+	})
+
+	var expired []string
+	for i, c := range candidates {
+		if i < policy.KeepLast {
+			continue
+		}
+		if policy.MaxAge > 0 && now.Sub(c.modTime) < policy.MaxAge {
+			continue
+		}
+		if c.modTime.After(notBefore) {
+			continue
+		}
+		expired = append(expired, c.tag)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicies(t *testing.T) {
+	policies, err := ParseRetentionPolicies([]interface{}{
+		map[interface{}]interface{}{
+			"repositories": "ci/*",
+			"keeplast":     10,
+			"maxage":       "720h",
+			"protect":      "^(latest|v[0-9].*)$",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error parsing retention policies: %v", err)
+	}
+	if len(policies) != 1 {
+		t.Fatalf("expected 1 policy, got %d", len(policies))
+	}
+
+	policy := policies[0]
+	if policy.Repositories != "ci/*" || policy.KeepLast != 10 || policy.MaxAge != 720*time.Hour {
+		t.Fatalf("unexpected policy: %+v", policy)
+	}
+	if !policy.Protect.MatchString("v1.2") || policy.Protect.MatchString("pr-1234-abc") {
+		t.Fatalf("unexpected protect expression: %v", policy.Protect)
+	}
+
+	if retentionPolicyFor(policies, "ci/app") == nil {
+		t.Fatal("expected policy to apply to ci/app")
+	}
+	if retentionPolicyFor(policies, "ci/app/sub") != nil {
+		t.Fatal("expected policy not to apply to ci/app/sub")
+	}
+
+	for _, invalid := range []interface{}{
+		"ci/*",
+		[]interface{}{map[interface{}]interface{}{"keeplast": 1}},
+		[]interface{}{map[interface{}]interface{}{"repositories": "[", "keeplast": 1}},
+		[]interface{}{map[interface{}]interface{}{"repositories": "*", "keeplast": -1}},
+		[]interface{}{map[interface{}]interface{}{"repositories": "*", "maxage": "forever"}},
+		[]interface{}{map[interface{}]interface{}{"repositories": "*", "protect": "("}},
+		[]interface{}{map[interface{}]interface{}{"repositories": "*", "keepfirst": 1}},
+	} {
+		if _, err := ParseRetentionPolicies(invalid); err == nil {
+			t.Fatalf("expected error parsing %v", invalid)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"path"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -104,6 +105,29 @@ func (ts *tagStore) Get(ctx context.Context, tag string) (v1.Descriptor, error)
 	return v1.Descriptor{Digest: revision}, nil
 }
 
+// ModTime returns the time the tag was last updated.
+func (ts *tagStore) ModTime(ctx context.Context, tag string) (time.Time, error) {
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fi, err := ts.blobStore.driver.Stat(ctx, currentPath)
+	if err != nil {
+		switch err.(type) {
+		case storagedriver.PathNotFoundError:
+			return time.Time{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
 // Untag removes the tag association
 func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 	tagPath, err := pathFor(manifestTagPathSpec{
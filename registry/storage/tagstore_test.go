@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/schema2"
@@ -120,6 +121,32 @@ func TestTagStoreUnTag(t *testing.T) {
 	}
 }
 
+func TestTagStoreModTime(t *testing.T) {
+	env := testTagStore(t)
+	tags := env.ts.(*tagStore)
+	ctx := env.ctx
+	desc := v1.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	_, err := tags.ModTime(ctx, "latest")
+	if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		t.Errorf("expected unknown tag error, got %v", err)
+	}
+
+	before := time.Now()
+	err = tags.Tag(ctx, "latest", desc)
+	if err != nil {
+		t.Error(err)
+	}
+
+	modTime, err := tags.ModTime(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modTime.Before(before) {
+		t.Errorf("expected modification time after %v, got %v", before, modTime)
+	}
+}
+
 func TestTagStoreAll(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
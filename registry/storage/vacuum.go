@@ -50,6 +50,38 @@ func (v Vacuum) RemoveBlob(dgst string) error {
 	return nil
 }
 
+// RemoveBlobs removes blobs from the filesystem, deleting their data at once
+// when the driver implements driver.BatchDeleter.
+func (v Vacuum) RemoveBlobs(dgsts []digest.Digest) error {
+	if bd, ok := v.driver.(driver.BatchDeleter); ok {
+		paths := make([]string, 0, len(dgsts))
+		for _, dgst := range dgsts {
+			dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+			if err != nil {
+				return err
+			}
+			paths = append(paths, dataPath)
+		}
+
+		err := bd.DeleteFiles(v.ctx, paths)
+		if _, ok := err.(driver.ErrUnsupportedMethod); !ok {
+			if err == nil {
+				for _, dataPath := range paths {
+					dcontext.GetLogger(v.ctx).Infof("Deleted blob: %s", dataPath)
+				}
+			}
+			return err
+		}
+	}
+
+	for _, dgst := range dgsts {
+		if err := v.RemoveBlob(dgst.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RemoveManifest removes a manifest from the filesystem
 func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) error {
 	// remove a tag manifest reference, in case of not found continue to next one
@@ -81,7 +113,10 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 		return err
 	}
 	dcontext.GetLogger(v.ctx).Infof("deleting manifest: %s", manifestPath)
-	return v.driver.Delete(v.ctx, manifestPath)
+	if err := v.driver.Delete(v.ctx, manifestPath); err != nil {
+		return err
+	}
+	return removeReferrerLink(v.ctx, v.driver, name, dgst)
 }
 
 // RemoveRepository removes a repository directory from the